@@ -0,0 +1,10 @@
+// Package api встраивает спецификацию OpenAPI и страницу документации в бинарный файл сервиса
+package api
+
+import _ "embed"
+
+//go:embed openapi.json
+var OpenAPI []byte
+
+//go:embed docs.html
+var Docs []byte
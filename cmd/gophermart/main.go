@@ -2,21 +2,46 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/server"
-
-	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/lib/pq"
 )
 
 func main() {
-	ctx := context.Background()
-	config, err := config.New(ctx)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	config, err := config.New()
 	if err != nil {
 		panic(err)
 	}
-	err = server.Start(ctx, config)
+	storage, err := repository.NewStorage(ctx, config)
+	if err != nil {
+		panic(err)
+	}
+	defer storage.Close()
+	// gophermart [flags] migrate up|down|force|status [arg]
+	// gophermart [flags] admin grant|revoke <login>
+	if args := flag.Args(); len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			err = server.RunMigrateCommand(ctx, config, storage, args[1:], os.Stdout)
+		case "admin":
+			err = server.RunAdminCommand(ctx, config, storage, args[1:], os.Stdout)
+		default:
+			err = fmt.Errorf("unknown command %q", args[0])
+		}
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+	err = server.Start(ctx, config, storage)
 	if err != nil {
 		panic(err)
 	}
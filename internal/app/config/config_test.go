@@ -0,0 +1,30 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateCookie(t *testing.T) {
+	assert.NoError(t, (&Config{CookieSameSite: SameSiteStrict}).validateCookie())
+	assert.NoError(t, (&Config{CookieSameSite: SameSiteNone, CookieSecure: true}).validateCookie())
+	assert.Error(t, (&Config{CookieSameSite: SameSiteNone}).validateCookie())
+	assert.Error(t, (&Config{CookieSameSite: "relaxed"}).validateCookie())
+}
+
+func TestLoadPasswordPolicy(t *testing.T) {
+	denyList := filepath.Join(t.TempDir(), "denied.txt")
+	require.NoError(t, os.WriteFile(denyList, []byte("gophermart\n\n  loyalty  \n"), 0o600))
+	c := &Config{PasswordHash: PasswordHashArgon2id, PasswordDenyList: denyList}
+	require.NoError(t, c.loadPasswordPolicy())
+	assert.Equal(t, []string{"gophermart", "loyalty"}, c.PasswordDenied)
+
+	assert.Error(t, (&Config{PasswordHash: "md5"}).loadPasswordPolicy())
+	assert.Error(t, (&Config{BcryptCost: 40}).loadPasswordPolicy())
+	assert.Error(t, (&Config{PasswordHash: PasswordHashArgon2id, Argon2Threads: 256}).loadPasswordPolicy())
+	assert.Error(t, (&Config{PasswordDenyList: filepath.Join(t.TempDir(), "missing.txt")}).loadPasswordPolicy())
+}
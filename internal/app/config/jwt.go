@@ -0,0 +1,194 @@
+package config
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmRS256 = "RS256"
+	JWTAlgorithmEdDSA = "EdDSA"
+
+	// DefaultJWTKeyID kid ключа, заданного через JWT_SECRET
+	DefaultJWTKeyID = "default"
+	// MinJWTSecretLength минимальная длина секрета HS256 в байтах
+	MinJWTSecretLength = 32
+)
+
+// JWTKey ключ подписи токенов. Ключ без закрытой части (только PublicKey)
+// используется лишь для проверки токенов, выпущенных до ротации.
+type JWTKey struct {
+	ID         string
+	Algorithm  string
+	Secret     []byte
+	PrivateKey crypto.PrivateKey
+	PublicKey  crypto.PublicKey
+}
+
+// CanSign сообщает, можно ли подписывать этим ключом новые токены
+func (k *JWTKey) CanSign() bool {
+	return len(k.Secret) > 0 || k.PrivateKey != nil
+}
+
+// Файл ключей (JWT_KEYS_FILE или флаг -jwt-keys-file):
+//
+//	{
+//		"signing_key": "2024-06",
+//		"keys": [
+//			{"kid": "2024-06", "alg": "EdDSA", "private_key_file": "/etc/gophermart/ed25519.pem"},
+//			{"kid": "2024-01", "alg": "HS256", "secret_file": "/etc/gophermart/hs256.secret"},
+//			{"kid": "legacy-rsa", "alg": "RS256", "public_key_file": "/etc/gophermart/rsa.pub.pem"}
+//		]
+//	}
+type jwtKeysFile struct {
+	SigningKey string          `json:"signing_key"`
+	Keys       []jwtKeyFileRow `json:"keys"`
+}
+
+type jwtKeyFileRow struct {
+	ID             string `json:"kid"`
+	Algorithm      string `json:"alg"`
+	Secret         string `json:"secret"`
+	SecretFile     string `json:"secret_file"`
+	PrivateKeyFile string `json:"private_key_file"`
+	PublicKeyFile  string `json:"public_key_file"`
+}
+
+// SigningJWTKey ключ, которым подписываются новые токены
+func (c *Config) SigningJWTKey() (*JWTKey, error) {
+	key := c.FindJWTKey(c.JWTSigningKeyID)
+	if key == nil || !key.CanSign() {
+		return nil, fmt.Errorf("jwt signing key %q is not configured", c.JWTSigningKeyID)
+	}
+	return key, nil
+}
+
+// FindJWTKey ищет ключ проверки по kid из заголовка токена
+func (c *Config) FindJWTKey(id string) *JWTKey {
+	for i := range c.JWTKeys {
+		if c.JWTKeys[i].ID == id {
+			return &c.JWTKeys[i]
+		}
+	}
+	return nil
+}
+
+// loadJWTKeys собирает набор ключей из JWT_SECRET и файла ключей и выбирает ключ подписи
+func (c *Config) loadJWTKeys() error {
+	keys := make([]JWTKey, 0)
+	signingKeyID := c.JWTSigningKeyID
+	if c.JWTSecret != "" {
+		if len(c.JWTSecret) < MinJWTSecretLength {
+			return fmt.Errorf("JWT_SECRET must be at least %d bytes", MinJWTSecretLength)
+		}
+		keys = append(keys, JWTKey{ID: DefaultJWTKeyID, Algorithm: JWTAlgorithmHS256, Secret: []byte(c.JWTSecret)})
+	}
+	if c.JWTKeysFile != "" {
+		data, err := os.ReadFile(c.JWTKeysFile)
+		if err != nil {
+			return err
+		}
+		file := &jwtKeysFile{}
+		if err = json.Unmarshal(data, file); err != nil {
+			return fmt.Errorf("parse jwt keys file: %w", err)
+		}
+		for _, row := range file.Keys {
+			key, err := row.load()
+			if err != nil {
+				return fmt.Errorf("jwt key %q: %w", row.ID, err)
+			}
+			keys = append(keys, *key)
+		}
+		if signingKeyID == "" {
+			signingKeyID = file.SigningKey
+		}
+	}
+	if len(keys) == 0 {
+		return errors.New("jwt key is not configured: set JWT_SECRET or JWT_KEYS_FILE")
+	}
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if key.ID == "" || seen[key.ID] {
+			return fmt.Errorf("jwt key id %q is empty or duplicated", key.ID)
+		}
+		seen[key.ID] = true
+	}
+	if signingKeyID == "" {
+		signingKeyID = keys[0].ID
+	}
+	c.JWTKeys = keys
+	c.JWTSigningKeyID = signingKeyID
+	_, err := c.SigningJWTKey()
+	return err
+}
+
+func (row *jwtKeyFileRow) load() (*JWTKey, error) {
+	key := &JWTKey{ID: row.ID, Algorithm: row.Algorithm}
+	switch row.Algorithm {
+	case JWTAlgorithmHS256:
+		secret := []byte(row.Secret)
+		if row.SecretFile != "" {
+			data, err := os.ReadFile(row.SecretFile)
+			if err != nil {
+				return nil, err
+			}
+			// файлы с секретом обычно заканчиваются переводом строки, он не часть секрета
+			secret = bytes.TrimSpace(data)
+		}
+		if len(secret) < MinJWTSecretLength {
+			return nil, fmt.Errorf("secret must be at least %d bytes", MinJWTSecretLength)
+		}
+		key.Secret = secret
+	case JWTAlgorithmRS256:
+		if row.PrivateKeyFile != "" {
+			private, err := readPEM(row.PrivateKeyFile, jwt.ParseRSAPrivateKeyFromPEM)
+			if err != nil {
+				return nil, err
+			}
+			key.PrivateKey, key.PublicKey = private, &private.PublicKey
+		} else if row.PublicKeyFile != "" {
+			public, err := readPEM(row.PublicKeyFile, jwt.ParseRSAPublicKeyFromPEM)
+			if err != nil {
+				return nil, err
+			}
+			key.PublicKey = public
+		}
+	case JWTAlgorithmEdDSA:
+		if row.PrivateKeyFile != "" {
+			private, err := readPEM(row.PrivateKeyFile, jwt.ParseEdPrivateKeyFromPEM)
+			if err != nil {
+				return nil, err
+			}
+			key.PrivateKey, key.PublicKey = private, private.(ed25519.PrivateKey).Public()
+		} else if row.PublicKeyFile != "" {
+			public, err := readPEM(row.PublicKeyFile, jwt.ParseEdPublicKeyFromPEM)
+			if err != nil {
+				return nil, err
+			}
+			key.PublicKey = public
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", row.Algorithm)
+	}
+	if key.Secret == nil && key.PublicKey == nil {
+		return nil, errors.New("key material is missing")
+	}
+	return key, nil
+}
+
+func readPEM[T any](path string, parse func([]byte) (T, error)) (T, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return parse(data)
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePEM(t *testing.T, dir string, name string, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return path
+}
+
+func TestLoadJWTKeysFile(t *testing.T) {
+	dir := t.TempDir()
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edDER, err := x509.MarshalPKCS8PrivateKey(edPrivate)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	secretFile := filepath.Join(dir, "hs256.secret")
+	require.NoError(t, os.WriteFile(secretFile, []byte("0123456789abcdef0123456789abcdef\n"), 0o600))
+	keysFile := filepath.Join(dir, "keys.json")
+	require.NoError(t, os.WriteFile(keysFile, []byte(fmt.Sprintf(`{
+		"signing_key": "ed",
+		"keys": [
+			{"kid": "ed", "alg": "EdDSA", "private_key_file": %q},
+			{"kid": "hs", "alg": "HS256", "secret_file": %q},
+			{"kid": "rsa", "alg": "RS256", "public_key_file": %q}
+		]
+	}`, writePEM(t, dir, "ed.pem", "PRIVATE KEY", edDER), secretFile, writePEM(t, dir, "rsa.pub.pem", "PUBLIC KEY", rsaDER))), 0o600))
+
+	c := &Config{JWTKeysFile: keysFile}
+	require.NoError(t, c.loadJWTKeys())
+	require.Len(t, c.JWTKeys, 3)
+	signing, err := c.SigningJWTKey()
+	require.NoError(t, err)
+	assert.Equal(t, "ed", signing.ID)
+	assert.NotNil(t, signing.PublicKey)
+	assert.False(t, c.FindJWTKey("rsa").CanSign())
+	assert.Equal(t, []byte("0123456789abcdef0123456789abcdef"), c.FindJWTKey("hs").Secret)
+
+	// ключом только для проверки подписывать нельзя
+	c = &Config{JWTKeysFile: keysFile, JWTSigningKeyID: "rsa"}
+	assert.Error(t, c.loadJWTKeys())
+
+	// пробелы и переводы строк не добирают секрет до минимальной длины
+	require.NoError(t, os.WriteFile(secretFile, []byte("0123456789abcdef\n\n\n\n\n\n\n\n\n\n\n\n\n\n\n\n\n"), 0o600))
+	c = &Config{JWTKeysFile: keysFile}
+	assert.Error(t, c.loadJWTKeys())
+}
+
+func TestLoadJWTKeysSecret(t *testing.T) {
+	c := &Config{JWTSecret: "0123456789abcdef0123456789abcdef"}
+	require.NoError(t, c.loadJWTKeys())
+	assert.Equal(t, DefaultJWTKeyID, c.JWTSigningKeyID)
+
+	assert.Error(t, (&Config{JWTSecret: "secretkey"}).loadJWTKeys())
+	assert.Error(t, (&Config{}).loadJWTKeys())
+}
@@ -0,0 +1,200 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	"github.com/go-chi/chi"
+	"github.com/go-playground/validator/v10"
+)
+
+type AdminService interface {
+	ListUsers(ctx context.Context) ([]*AdminUserResponse, error)
+	GetUserOrders(ctx context.Context, userID int, query *ListQuery) ([]*OrderResponse, string, error)
+	GetUserBalance(ctx context.Context, userID int) (*BalanceResponse, error)
+	BlockUser(ctx context.Context, userID int) error
+	UnblockUser(ctx context.Context, userID int) error
+	AdjustBalance(ctx context.Context, userID int, dto *AdjustBalanceRequest) error
+	GetAuditRecords(ctx context.Context, userID int) ([]*AuditRecordResponse, error)
+}
+
+type AdminHandler struct {
+	config *config.Config
+	AdminService
+}
+
+func NewAdminHandler(config *config.Config, service AdminService) *AdminHandler {
+	return &AdminHandler{config, service}
+}
+
+type AdminUserResponse struct {
+	ID        int    `json:"id"`
+	Login     string `json:"login"`
+	Role      string `json:"role"`
+	Blocked   bool   `json:"blocked"`
+	BlockedAt string `json:"blocked_at,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+func (h *AdminHandler) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	responseArr, err := h.ListUsers(r.Context())
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	sendOKWithBody(w, responseArr)
+}
+
+func (h *AdminHandler) GetUserOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromPath(r)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	query, err := parseListQuery(r, true)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	responseArr, next, err := h.GetUserOrders(r.Context(), userID, query)
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	sendPage(w, responseArr, next)
+}
+
+func (h *AdminHandler) GetUserBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromPath(r)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	balanceResponse, err := h.GetUserBalance(r.Context(), userID)
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	sendOKWithBody(w, balanceResponse)
+}
+
+func (h *AdminHandler) BlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromPath(r)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	err = h.BlockUser(r.Context(), userID)
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *AdminHandler) UnblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromPath(r)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	err = h.UnblockUser(r.Context(), userID)
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// AdjustBalanceRequest корректировка баланса: положительная сумма зачисляется, отрицательная списывается
+type AdjustBalanceRequest struct {
+	Amount entity.Money `json:"amount"`
+	Reason string       `json:"reason" validate:"required"`
+}
+
+// UnmarshalJSON не округляет сумму корректировки, как и WithdrawRequest
+func (r *AdjustBalanceRequest) UnmarshalJSON(data []byte) error {
+	type adjustBalanceRequest AdjustBalanceRequest
+	aux := struct {
+		*adjustBalanceRequest
+		Amount json.RawMessage `json:"amount"`
+	}{adjustBalanceRequest: (*adjustBalanceRequest)(r)}
+	err := json.Unmarshal(data, &aux)
+	if err != nil || aux.Amount == nil {
+		return err
+	}
+	return r.Amount.UnmarshalExactJSON(aux.Amount)
+}
+
+func (h *AdminHandler) AdjustBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromPath(r)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	buf, err := io.ReadAll(io.Reader(r.Body))
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	var dto AdjustBalanceRequest
+	err = json.Unmarshal(buf, &dto)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	err = validate.Struct(dto)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	err = h.AdjustBalance(r.Context(), userID, &dto)
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type AuditRecordResponse struct {
+	ID        int64  `json:"id"`
+	AdminID   int    `json:"admin_id,omitempty"`
+	Action    string `json:"action"`
+	UserID    int    `json:"user_id,omitempty"`
+	Details   string `json:"details,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// GetAuditRecordsHandler отдаёт журнал целиком или, с параметром user_id, по одному пользователю
+func (h *AdminHandler) GetAuditRecordsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := 0
+	if val := r.URL.Query().Get("user_id"); val != "" {
+		id, err := strconv.Atoi(val)
+		if err != nil {
+			sendClientErr(err, w)
+			return
+		}
+		userID = id
+	}
+	responseArr, err := h.GetAuditRecords(r.Context(), userID)
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	sendOKWithBody(w, responseArr)
+}
+
+func userIDFromPath(r *http.Request) (int, error) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || userID <= 0 {
+		return 0, errors.New("invalid user id")
+	}
+	return userID, nil
+}
@@ -0,0 +1,93 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/go-chi/chi"
+	"github.com/go-playground/validator/v10"
+)
+
+type APIKeyService interface {
+	CreateAPIKey(ctx context.Context, dto *CreateAPIKeyRequest) (*APIKeyResponse, error)
+	ListAPIKeys(ctx context.Context) ([]*APIKeyResponse, error)
+	RevokeAPIKey(ctx context.Context, ID int) error
+}
+
+type APIKeyHandler struct {
+	config *config.Config
+	APIKeyService
+}
+
+func NewAPIKeyHandler(config *config.Config, service APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{config, service}
+}
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" validate:"required,max=255"`
+	Scopes []string `json:"scopes" validate:"required,min=1"`
+}
+
+// APIKeyResponse описание ключа; Key заполняется только при создании
+type APIKeyResponse struct {
+	ID         int      `json:"id"`
+	Name       string   `json:"name"`
+	Key        string   `json:"key,omitempty"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+}
+
+func (h *APIKeyHandler) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	buf, err := io.ReadAll(io.Reader(r.Body))
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	var dto CreateAPIKeyRequest
+	err = json.Unmarshal(buf, &dto)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	err = validate.Struct(dto)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	response, err := h.CreateAPIKey(r.Context(), &dto)
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	sendOKWithBody(w, response)
+}
+
+func (h *APIKeyHandler) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	responseArr, err := h.ListAPIKeys(r.Context())
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	sendOKWithBody(w, responseArr)
+}
+
+func (h *APIKeyHandler) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	ID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	err = h.RevokeAPIKey(r.Context(), ID)
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -7,14 +7,15 @@ import (
 	"net/http"
 
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
 )
 
 type BalanceOperationService interface {
 	CreateNewOrder(context.Context, *CreateOrderRequest) error
-	GetListOrders(ctx context.Context, userID int) ([]*OrderResponse, error)
+	GetListOrders(ctx context.Context, userID int, query *ListQuery) ([]*OrderResponse, string, error)
 	GetBalance(ctx context.Context, userID int) (*BalanceResponse, error)
 	CreateWithdraw(ctx context.Context, userID int, withdraw *WithdrawRequest) error
-	GetWithdrawals(ctx context.Context, userID int) ([]*WithdrawResponse, error)
+	GetWithdrawals(ctx context.Context, userID int, query *ListQuery) ([]*WithdrawResponse, string, error)
 }
 
 type BalanceOperationHandler struct {
@@ -57,10 +58,10 @@ func (h *BalanceOperationHandler) CreateOrderHandler(w http.ResponseWriter, r *h
 }
 
 type OrderResponse struct {
-	Number     string  `json:"number"`
-	Status     string  `json:"status"`
-	Accrual    float32 `json:"accrual"`
-	UploadedAt string  `json:"uploaded_at"`
+	Number     string       `json:"number"`
+	Status     string       `json:"status"`
+	Accrual    entity.Money `json:"accrual,omitempty"`
+	UploadedAt string       `json:"uploaded_at"`
 }
 
 func (h *BalanceOperationHandler) GetOrdersHandler(w http.ResponseWriter, r *http.Request) {
@@ -69,17 +70,22 @@ func (h *BalanceOperationHandler) GetOrdersHandler(w http.ResponseWriter, r *htt
 		sendServerErr(err, w)
 		return
 	}
-	responseArr, err := h.GetListOrders(r.Context(), userID)
+	query, err := parseListQuery(r, true)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	responseArr, next, err := h.GetListOrders(r.Context(), userID, query)
 	if err != nil {
 		sendServerErr(err, w)
 		return
 	}
-	sendOKWithBody(w, responseArr)
+	sendPage(w, responseArr, next)
 }
 
 type BalanceResponse struct {
-	Current   float32 `json:"current"`
-	Withdrawn float32 `json:"withdrawn"`
+	Current   entity.Money `json:"current"`
+	Withdrawn entity.Money `json:"withdrawn"`
 }
 
 func (h *BalanceOperationHandler) GetBalanceHandler(w http.ResponseWriter, r *http.Request) {
@@ -97,8 +103,24 @@ func (h *BalanceOperationHandler) GetBalanceHandler(w http.ResponseWriter, r *ht
 }
 
 type WithdrawRequest struct {
-	Order string  `json:"order"`
-	Sum   float32 `json:"sum"`
+	Order string       `json:"order"`
+	Sum   entity.Money `json:"sum"`
+	// TOTPCode нужен пользователям с 2FA для списаний больше порога WithdrawTOTPAbove
+	TOTPCode string `json:"totp_code,omitempty"`
+}
+
+// UnmarshalJSON не округляет сумму списания: дробные копейки в запросе — ошибка клиента
+func (r *WithdrawRequest) UnmarshalJSON(data []byte) error {
+	type withdrawRequest WithdrawRequest
+	aux := struct {
+		*withdrawRequest
+		Sum json.RawMessage `json:"sum"`
+	}{withdrawRequest: (*withdrawRequest)(r)}
+	err := json.Unmarshal(data, &aux)
+	if err != nil || aux.Sum == nil {
+		return err
+	}
+	return r.Sum.UnmarshalExactJSON(aux.Sum)
 }
 
 func (h *BalanceOperationHandler) WithdrawHandler(w http.ResponseWriter, r *http.Request) {
@@ -118,6 +140,11 @@ func (h *BalanceOperationHandler) WithdrawHandler(w http.ResponseWriter, r *http
 		sendServerErr(err, w)
 		return
 	}
+	err = h.VerifyWithdrawal(r.Context(), userID, withdraw)
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
 	err = h.CreateWithdraw(r.Context(), userID, withdraw)
 	if err != nil {
 		sendServerErr(err, w)
@@ -127,9 +154,9 @@ func (h *BalanceOperationHandler) WithdrawHandler(w http.ResponseWriter, r *http
 }
 
 type WithdrawResponse struct {
-	Order       string  `json:"order"`
-	Sum         float32 `json:"sum"`
-	ProcessedAt string  `json:"processed_at"`
+	Order       string       `json:"order"`
+	Sum         entity.Money `json:"sum"`
+	ProcessedAt string       `json:"processed_at"`
 }
 
 func (h *BalanceOperationHandler) GetWithdrawalsHandler(w http.ResponseWriter, r *http.Request) {
@@ -138,10 +165,15 @@ func (h *BalanceOperationHandler) GetWithdrawalsHandler(w http.ResponseWriter, r
 		sendServerErr(err, w)
 		return
 	}
-	responseArr, err := h.GetWithdrawals(r.Context(), userID)
+	query, err := parseListQuery(r, false)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	responseArr, next, err := h.GetWithdrawals(r.Context(), userID, query)
 	if err != nil {
 		sendServerErr(err, w)
 		return
 	}
-	sendOKWithBody(w, responseArr)
+	sendPage(w, responseArr, next)
 }
@@ -3,27 +3,90 @@ package http
 import (
 	"encoding/json"
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
 
 	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+	"github.com/go-playground/validator/v10"
 )
 
+// ProblemContentType тип тела ответа с ошибкой (RFC 7807)
+const ProblemContentType = "application/problem+json"
+
+// Problem тело ответа с ошибкой по RFC 7807. Code — стабильный код из каталога customerr,
+// по нему клиенты различают ошибки с одинаковым статусом.
+type Problem struct {
+	Type   string         `json:"type"`
+	Title  string         `json:"title"`
+	Status int            `json:"status"`
+	Code   customerr.Code `json:"code"`
+	Detail string         `json:"detail,omitempty"`
+}
+
 func sendServerErr(err error, w http.ResponseWriter) {
+	var retryErr interface{ RetryAfter() time.Duration }
+	if errors.As(err, &retryErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryErr.RetryAfter().Seconds()))))
+	}
 	customErr := &customerr.CustomError{}
 	if errors.As(err, &customErr) {
-		w.WriteHeader(customErr.HTTPStatus)
+		SendProblem(w, customErr.HTTPStatus, customErr.ErrorCode(), customErr.Error())
 		return
 	}
-	w.WriteHeader(http.StatusInternalServerError)
+	SendProblem(w, http.StatusInternalServerError, customerr.CodeInternal, "")
 }
 
 func sendClientErr(err error, w http.ResponseWriter) {
 	customErr := &customerr.CustomError{}
 	if errors.As(err, &customErr) {
-		w.WriteHeader(customErr.HTTPStatus)
+		SendProblem(w, customErr.HTTPStatus, customErr.ErrorCode(), customErr.Error())
+		return
+	}
+	code := customerr.CodeBadRequest
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		code = customerr.CodeValidationFailed
+	}
+	if errors.Is(err, entity.ErrMoneyPrecision) {
+		code = customerr.CodeInvalidAmount
+	}
+	SendProblem(w, http.StatusBadRequest, code, err.Error())
+}
+
+// SendError отвечает ошибкой из usecase так же, как обработчики; нужна middleware
+func SendError(w http.ResponseWriter, err error) {
+	sendServerErr(err, w)
+}
+
+// SendProblem отвечает телом application/problem+json. Успешные статусы (200 для уже загруженного заказа,
+// 204 для пустого списка) отдаются без тела, а подробности ошибок сервера клиенту не раскрываются.
+func SendProblem(w http.ResponseWriter, status int, code customerr.Code, detail string) {
+	if status < http.StatusBadRequest {
+		w.WriteHeader(status)
+		return
+	}
+	if status >= http.StatusInternalServerError {
+		detail = ""
+	}
+	data, err := json.Marshal(&Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	})
+	if err != nil {
+		w.WriteHeader(status)
 		return
 	}
-	w.WriteHeader(http.StatusBadRequest)
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(status)
+	w.Write(data)
 }
 
 func sendOKWithBody(w http.ResponseWriter, responseBody any) {
@@ -37,12 +100,53 @@ func sendOKWithBody(w http.ResponseWriter, responseBody any) {
 	w.Write(data)
 }
 
-func sendOKWithCookie(token string, w http.ResponseWriter) {
-	cookie := &http.Cookie{
-		Name:  string("USER_ID"),
-		Value: token,
-	}
+const (
+	AccessTokenCookie  = "USER_ID"
+	RefreshTokenCookie = "REFRESH_TOKEN"
+	// refresh-токен нужен только эндпоинтам обновления и выхода
+	refreshTokenCookiePath = "/api/user"
+)
+
+// sendOKWithCookie отдаёт access-токен и в cookie для браузеров, и в заголовке Authorization для API-клиентов.
+// Срок жизни cookie совпадает со сроком жизни токена.
+func sendOKWithCookie(c *config.Config, tokens *AuthTokens, w http.ResponseWriter) {
+	setSessionHeaders(c, tokens, w)
 	w.Header().Set("Content-Type", "application/json")
-	http.SetCookie(w, cookie)
 	w.WriteHeader(http.StatusOK)
 }
+
+func setSessionHeaders(c *config.Config, tokens *AuthTokens, w http.ResponseWriter) {
+	http.SetCookie(w, newCookie(c, AccessTokenCookie, tokens.AccessToken, "/", tokens.AccessTokenExpiresAt))
+	http.SetCookie(w, newCookie(c, RefreshTokenCookie, tokens.RefreshToken, refreshTokenCookiePath, tokens.RefreshTokenExpiresAt))
+	w.Header().Set("Authorization", "Bearer "+tokens.AccessToken)
+}
+
+func clearCookies(c *config.Config, w http.ResponseWriter) {
+	for _, cookie := range []*http.Cookie{
+		newCookie(c, AccessTokenCookie, "", "/", time.Time{}),
+		newCookie(c, RefreshTokenCookie, "", refreshTokenCookiePath, time.Time{}),
+	} {
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+	}
+}
+
+func newCookie(c *config.Config, name string, value string, path string, expires time.Time) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   c.CookieDomain,
+		Expires:  expires,
+		Secure:   c.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	switch c.CookieSameSite {
+	case config.SameSiteStrict:
+		cookie.SameSite = http.SameSiteStrictMode
+	case config.SameSiteNone:
+		cookie.SameSite = http.SameSiteNoneMode
+	}
+	return cookie
+}
@@ -0,0 +1,76 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+)
+
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+	// NextCursorHeader курсор следующей страницы; заголовка нет на последней странице
+	NextCursorHeader = "X-Next-Cursor"
+)
+
+// ListQuery параметры постраничного списка заказов или списаний:
+// limit, cursor (из NextCursorHeader), status через запятую (только для заказов),
+// from и to в RFC 3339 (from включительно, to не включительно), sort=desc (новые первыми, по умолчанию) или asc
+type ListQuery struct {
+	Limit     int
+	Cursor    string
+	Statuses  []string
+	From      time.Time
+	To        time.Time
+	Ascending bool
+}
+
+func parseListQuery(r *http.Request, withStatus bool) (*ListQuery, error) {
+	values := r.URL.Query()
+	query := &ListQuery{Limit: DefaultListLimit, Cursor: values.Get("cursor")}
+	if val := values.Get("limit"); val != "" {
+		limit, err := strconv.Atoi(val)
+		if err != nil || limit < 1 || limit > MaxListLimit {
+			return nil, invalidQuery(errors.New("limit must be between 1 and " + strconv.Itoa(MaxListLimit)))
+		}
+		query.Limit = limit
+	}
+	if val := values.Get("status"); val != "" {
+		if !withStatus {
+			return nil, invalidQuery(errors.New("status filter is not supported"))
+		}
+		query.Statuses = strings.Split(strings.ToUpper(val), ",")
+	}
+	for name, target := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		if val := values.Get(name); val != "" {
+			t, err := time.Parse(time.RFC3339, val)
+			if err != nil {
+				return nil, invalidQuery(err)
+			}
+			*target = t
+		}
+	}
+	switch values.Get("sort") {
+	case "", "desc":
+	case "asc":
+		query.Ascending = true
+	default:
+		return nil, invalidQuery(errors.New("sort must be asc or desc"))
+	}
+	return query, nil
+}
+
+func invalidQuery(err error) error {
+	return customerr.NewCodedError(err, http.StatusBadRequest, customerr.CodeInvalidQuery)
+}
+
+func sendPage(w http.ResponseWriter, responseArr any, next string) {
+	if next != "" {
+		w.Header().Set(NextCursorHeader, next)
+	}
+	sendOKWithBody(w, responseArr)
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	handlers "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/controller/http"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/usecase"
+)
+
+type RoleService interface {
+	HasRole(ctx context.Context, userID int, role entity.Role) bool
+}
+
+// AuthorizationMiddleware ставится после SecurityMiddleware и проверяет роль пользователя и права API-ключа
+type AuthorizationMiddleware struct {
+	RoleService
+}
+
+func NewAuthorizationMiddleware(s RoleService) *AuthorizationMiddleware {
+	return &AuthorizationMiddleware{s}
+}
+
+func (m *AuthorizationMiddleware) RequireRole(role entity.Role) func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := r.Context().Value(usecase.UserID).(int)
+			if !ok {
+				handlers.SendProblem(w, http.StatusUnauthorized, customerr.CodeUnauthorized, "user is not authenticated")
+				return
+			}
+			if !m.HasRole(r.Context(), userID, role) {
+				handlers.SendProblem(w, http.StatusForbidden, customerr.CodeForbidden, "role "+string(role)+" is required")
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession пропускает только запросы с JWT сессии: управление аккаунтом, ключами
+// и администрирование по API-ключу недоступны
+func (m *AuthorizationMiddleware) RequireSession(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(usecase.Scopes).([]string); ok {
+			handlers.SendProblem(w, http.StatusForbidden, customerr.CodeSessionRequired, "endpoint is not available with an api key")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// RequireScope пропускает запросы с JWT сессии и запросы по API-ключу с правом scope
+func (m *AuthorizationMiddleware) RequireScope(scope string) func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scopes, ok := r.Context().Value(usecase.Scopes).([]string)
+			if ok && !slices.Contains(scopes, scope) {
+				handlers.SendProblem(w, http.StatusForbidden, customerr.CodeInsufficientScope, "api key has no scope "+scope)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"io"
 	"net/http"
 	"strings"
+
+	handlers "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/controller/http"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
 )
 
 type compressWriter struct {
@@ -82,7 +85,7 @@ func (*CompressionMiddleware) CompressionMiddleware(h http.Handler) http.Handler
 			if strings.Contains(contentEncoding, "gzip") {
 				dr, err := newDecompressReader(r.Body)
 				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
+					handlers.SendProblem(w, http.StatusInternalServerError, customerr.CodeInternal, "")
 					return
 				}
 				r.Body = dr
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+
+	handlers "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/controller/http"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/usecase"
+	log "github.com/go-kit/log"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+type IdempotencyService interface {
+	BeginRequest(ctx context.Context, userID int, key string, method string, path string, body []byte) (*entity.IdempotencyRecord, bool, error)
+	FinishRequest(ctx context.Context, reservation *entity.IdempotencyRecord, statusCode int, contentType string, body []byte) error
+}
+
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	if rw.status != 0 {
+		return
+	}
+	rw.status = code
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(data []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	rw.body.Write(data)
+	return rw.ResponseWriter.Write(data)
+}
+
+type IdempotencyMiddleware struct {
+	IdempotencyService
+	logger log.Logger
+}
+
+func NewIdempotencyMiddleware(s IdempotencyService, logger log.Logger) *IdempotencyMiddleware {
+	return &IdempotencyMiddleware{s, logger}
+}
+
+// IdempotencyMiddleware повторяет сохранённый ответ на запрос с уже использованным Idempotency-Key.
+// Должен стоять после SecurityMiddleware: ключи хранятся в разрезе пользователя.
+func (m *IdempotencyMiddleware) IdempotencyMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		userID, ok := r.Context().Value(usecase.UserID).(int)
+		if !ok {
+			handlers.SendProblem(w, http.StatusUnauthorized, customerr.CodeUnauthorized, "user is not authenticated")
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			handlers.SendProblem(w, http.StatusBadRequest, customerr.CodeBadRequest, err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		record, reserved, err := m.BeginRequest(r.Context(), userID, key, r.Method, r.URL.Path, body)
+		if err != nil {
+			handlers.SendError(w, err)
+			return
+		}
+		if !reserved {
+			if record.ContentType != "" {
+				w.Header().Set("Content-Type", record.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(record.StatusCode)
+			w.Write(record.Body)
+			return
+		}
+		recorder := &recordingWriter{ResponseWriter: w}
+		defer func() {
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if p := recover(); p != nil {
+				status = http.StatusInternalServerError
+				defer panic(p)
+			}
+			// ответ уже отправлен клиенту, поэтому сохраняем его даже при отменённом запросе
+			ctx := context.WithoutCancel(r.Context())
+			err := m.FinishRequest(ctx, record, status, w.Header().Get("Content-Type"), recorder.body.Bytes())
+			if err != nil {
+				m.logger.Log("msg", "failed to save response for idempotency key", "user_id", userID, "err", err)
+			}
+		}()
+		h.ServeHTTP(recorder, r)
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	log "github.com/go-kit/log"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type responseWriter struct {
@@ -48,12 +49,17 @@ func (m *LoggingMiddleware) LoggingMiddleware(next http.Handler) http.Handler {
 		start := time.Now()
 		wrapped := wrapResponseWriter(w)
 		next.ServeHTTP(wrapped, r)
-		m.Log(
+		keyvals := []any{
 			"status", wrapped.status,
 			"method", r.Method,
 			"path", r.URL.EscapedPath(),
 			"duration", time.Since(start),
-		)
+		}
+		// по trace_id строку журнала можно найти в трассировках
+		if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.HasTraceID() {
+			keyvals = append(keyvals, "trace_id", spanContext.TraceID().String())
+		}
+		m.Log(keyvals...)
 	}
 	return http.HandlerFunc(fn)
 }
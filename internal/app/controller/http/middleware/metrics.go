@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/metrics"
+	"github.com/go-chi/chi"
+)
+
+type MetricsMiddleware struct {
+	metrics *metrics.Metrics
+}
+
+func NewMetricsMiddleware(m *metrics.Metrics) *MetricsMiddleware {
+	return &MetricsMiddleware{m}
+}
+
+// MetricsMiddleware считает запросы и время их обработки по шаблону маршрута и статусу ответа
+func (m *MetricsMiddleware) MetricsMiddleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		wrapped := wrapResponseWriter(w)
+		next.ServeHTTP(wrapped, r)
+		status := wrapped.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		m.metrics.ObserveHTTPRequest(r.Method, routePattern(r), status, time.Since(start))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// routePattern шаблон маршрута запроса. Если запрос отклонили до маршрутизации
+// (например, при проверке по спецификации), шаблон ищется заново по дереву маршрутов.
+// Шаблоны с /* в конце остаются только от подроутеров, где маршрут не нашёлся:
+// своих маршрутов с подстановкой у сервиса нет.
+func routePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return metrics.UnmatchedRoute
+	}
+	pattern := rctx.RoutePattern()
+	if pattern == "" && rctx.Routes != nil {
+		match := chi.NewRouteContext()
+		if rctx.Routes.Match(match, r.Method, r.URL.Path) {
+			pattern = match.RoutePattern()
+		}
+	}
+	if pattern == "" || strings.HasSuffix(pattern, "/*") {
+		return metrics.UnmatchedRoute
+	}
+	return pattern
+}
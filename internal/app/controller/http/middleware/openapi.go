@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+
+	handlers "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/controller/http"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/openapi"
+	log "github.com/go-kit/log"
+)
+
+// bufferingWriter придерживает ответ, пока он не проверен по спецификации
+type bufferingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (bw *bufferingWriter) WriteHeader(code int) {
+	if bw.status == 0 {
+		bw.status = code
+	}
+}
+
+func (bw *bufferingWriter) Write(data []byte) (int, error) {
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	return bw.body.Write(data)
+}
+
+// DefaultMaxRequestBody предельный размер тела запроса, если он не задан в конфигурации
+const DefaultMaxRequestBody = 1 << 20
+
+type OpenAPIMiddleware struct {
+	doc               *openapi.Document
+	validateResponses bool
+	maxRequestBody    int64
+	logger            log.Logger
+}
+
+func NewOpenAPIMiddleware(doc *openapi.Document, validateResponses bool, maxRequestBody int64, logger log.Logger) *OpenAPIMiddleware {
+	if maxRequestBody <= 0 {
+		maxRequestBody = DefaultMaxRequestBody
+	}
+	return &OpenAPIMiddleware{doc, validateResponses, maxRequestBody, logger}
+}
+
+// OpenAPIMiddleware отклоняет запросы, не соответствующие спецификации, до обработчика.
+// Тело запроса длиннее maxRequestBody отклоняется с 413 ещё до аутентификации.
+// С validateResponses (для тестов) ответ буферизуется и сверяется со спецификацией;
+// расхождение пишется в журнал, а клиент получает 500. Пути вне спецификации пропускаются как есть.
+func (m *OpenAPIMiddleware) OpenAPIMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, m.maxRequestBody)
+		}
+		route, params, ok := m.doc.FindRoute(r.Method, r.URL.Path)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if err := route.ValidateRequest(r, params); err != nil {
+			handlers.SendError(w, err)
+			return
+		}
+		if !m.validateResponses {
+			h.ServeHTTP(w, r)
+			return
+		}
+		buffered := &bufferingWriter{ResponseWriter: w}
+		h.ServeHTTP(buffered, r)
+		status := buffered.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if err := route.ValidateResponse(status, w.Header(), buffered.body.Bytes()); err != nil {
+			m.logger.Log("msg", "response does not match openapi specification", "err", err)
+			w.Header().Del("Content-Type")
+			handlers.SendProblem(w, http.StatusInternalServerError, customerr.CodeInternal, "")
+			return
+		}
+		w.WriteHeader(status)
+		w.Write(buffered.body.Bytes())
+	})
+}
@@ -3,39 +3,92 @@ package middleware
 import (
 	"context"
 	"net/http"
+	"strings"
 
+	handlers "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/controller/http"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/usecase"
 )
 
 type UserService interface {
-	GetUserIDFromToken(token string) (int, error)
+	Authenticate(ctx context.Context, token string) (int, string, error)
 	ExistsUser(ctx context.Context, userID int) bool
+	TouchSession(ctx context.Context, sessionID string, ip string) error
 }
 
+type APIKeyService interface {
+	AuthenticateAPIKey(ctx context.Context, key string) (int, []string, error)
+}
+
+// APIKeyHeader заголовок, в котором сервисы партнёров передают API-ключ
+const APIKeyHeader = "X-API-Key"
+
 type SecurityMiddleware struct {
+	keys APIKeyService
 	UserService
 }
 
-func NewSecurityMiddleware(s UserService) *SecurityMiddleware {
-	return &SecurityMiddleware{s}
+func NewSecurityMiddleware(s UserService, keys APIKeyService) *SecurityMiddleware {
+	return &SecurityMiddleware{keys, s}
 }
 
+// SecurityMiddleware принимает JWT сессии или API-ключ. Для запросов по ключу в контекст
+// кладутся права ключа, их проверяет AuthorizationMiddleware.RequireScope.
 func (m *SecurityMiddleware) SecurityMiddleware(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie(string(usecase.UserID))
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+		if key := r.Header.Get(APIKeyHeader); key != "" {
+			m.serveAPIKey(w, r, h, key)
+			return
+		}
+		token := tokenFromRequest(r)
+		if token == "" {
+			handlers.SendProblem(w, http.StatusUnauthorized, customerr.CodeUnauthorized, "access token is missing")
 			return
 		}
-		userID, err := m.GetUserIDFromToken(cookie.Value)
-		if err != nil || userID == 0 {
-			w.WriteHeader(http.StatusUnauthorized)
+		userID, sessionID, err := m.Authenticate(r.Context(), token)
+		if err != nil {
+			handlers.SendError(w, err)
 			return
 		}
 		if !m.ExistsUser(r.Context(), userID) {
-			w.WriteHeader(http.StatusUnauthorized)
+			handlers.SendProblem(w, http.StatusUnauthorized, customerr.CodeUnauthorized, "user is deleted or blocked")
 			return
 		}
-		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), usecase.UserID, userID)))
+		// время последнего запроса нужно только для списка сессий, его ошибка не мешает запросу
+		_ = m.TouchSession(r.Context(), sessionID, handlers.ClientIP(r))
+		ctx := context.WithValue(r.Context(), usecase.UserID, userID)
+		ctx = context.WithValue(ctx, usecase.SessionID, sessionID)
+		h.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+func (m *SecurityMiddleware) serveAPIKey(w http.ResponseWriter, r *http.Request, h http.Handler, key string) {
+	userID, scopes, err := m.keys.AuthenticateAPIKey(r.Context(), key)
+	if err != nil {
+		handlers.SendError(w, err)
+		return
+	}
+	if !m.ExistsUser(r.Context(), userID) {
+		handlers.SendProblem(w, http.StatusUnauthorized, customerr.CodeUnauthorized, "user is deleted or blocked")
+		return
+	}
+	ctx := context.WithValue(r.Context(), usecase.UserID, userID)
+	ctx = context.WithValue(ctx, usecase.Scopes, scopes)
+	h.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// tokenFromRequest берёт access-токен из заголовка Authorization: Bearer, а если его нет — из cookie
+func tokenFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return ""
+		}
+		return strings.TrimSpace(token)
+	}
+	cookie, err := r.Cookie(string(usecase.UserID))
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
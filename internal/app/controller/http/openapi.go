@@ -0,0 +1,25 @@
+package http
+
+import "net/http"
+
+// OpenAPIHandler отдаёт спецификацию API и страницу документации, встроенные в бинарный файл
+type OpenAPIHandler struct {
+	spec []byte
+	docs []byte
+}
+
+func NewOpenAPIHandler(spec []byte, docs []byte) *OpenAPIHandler {
+	return &OpenAPIHandler{spec, docs}
+}
+
+func (h *OpenAPIHandler) SpecHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(h.spec)
+}
+
+func (h *OpenAPIHandler) DocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(h.docs)
+}
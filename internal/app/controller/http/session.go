@@ -0,0 +1,36 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+type SessionResponse struct {
+	ID         string `json:"id"`
+	Device     string `json:"device"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"user_agent"`
+	CreatedAt  string `json:"created_at"`
+	LastSeenAt string `json:"last_seen_at"`
+	Current    bool   `json:"current"`
+}
+
+func (userHandler *UserHandler) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	responseArr, err := userHandler.ListSessions(r.Context())
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	sendOKWithBody(w, responseArr)
+}
+
+// EndSessionHandler завершает сессию по id из списка сессий
+func (userHandler *UserHandler) EndSessionHandler(w http.ResponseWriter, r *http.Request) {
+	err := userHandler.EndSession(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,127 @@
+package http
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+type TwoFactorSetupResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TwoFactorLoginRequest struct {
+	Token      string `json:"two_factor_token" validate:"required"`
+	Code       string `json:"code" validate:"required"`
+	ClientInfo `json:"-"`
+}
+
+type TwoFactorChallengeResponse struct {
+	Token     string `json:"two_factor_token"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// sendTwoFactorChallenge отвечает 202: пароль верный, но для входа нужен код (POST /api/user/login/2fa)
+func sendTwoFactorChallenge(w http.ResponseWriter, tokens *AuthTokens) {
+	data, err := json.Marshal(&TwoFactorChallengeResponse{
+		Token:     tokens.TwoFactorToken,
+		ExpiresAt: tokens.TwoFactorTokenExpiresAt.Format(time.RFC3339),
+	})
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(data)
+}
+
+func (userHandler *UserHandler) LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	buf, err := io.ReadAll(io.Reader(r.Body))
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	var dto TwoFactorLoginRequest
+	err = json.Unmarshal(buf, &dto)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	dto.ClientInfo = NewClientInfo(r)
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	err = validate.Struct(dto)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	tokens, err := userHandler.LoginTwoFactor(r.Context(), &dto)
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	sendOKWithCookie(userHandler.config, tokens, w)
+}
+
+func (userHandler *UserHandler) SetupTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	response, err := userHandler.SetupTwoFactor(r.Context())
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	sendOKWithBody(w, response)
+}
+
+func (userHandler *UserHandler) ConfirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	dto, err := readTwoFactorCode(r)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	response, err := userHandler.ConfirmTwoFactor(r.Context(), dto)
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	sendOKWithBody(w, response)
+}
+
+func (userHandler *UserHandler) DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	dto, err := readTwoFactorCode(r)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	err = userHandler.DisableTwoFactor(r.Context(), dto)
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func readTwoFactorCode(r *http.Request) (*TwoFactorCodeRequest, error) {
+	buf, err := io.ReadAll(io.Reader(r.Body))
+	if err != nil {
+		return nil, err
+	}
+	dto := &TwoFactorCodeRequest{}
+	err = json.Unmarshal(buf, dto)
+	if err != nil {
+		return nil, err
+	}
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	return dto, validate.Struct(dto)
+}
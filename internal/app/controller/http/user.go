@@ -1,21 +1,49 @@
 package http
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"io"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
 	"github.com/go-playground/validator/v10"
 )
 
 type UserService interface {
-	RegisterUser(context.Context, *RegisterRequest) (string, error)
-	LoginUser(context.Context, *LoginRequest) (string, error)
+	RegisterUser(context.Context, *RegisterRequest) (*AuthTokens, error)
+	LoginUser(context.Context, *LoginRequest) (*AuthTokens, error)
+	RefreshTokens(ctx context.Context, refreshToken string) (*AuthTokens, error)
+	Logout(ctx context.Context) error
+	ChangePassword(ctx context.Context, dto *ChangePasswordRequest) error
+	RequestPasswordReset(ctx context.Context, dto *PasswordResetRequest) error
+	ResetPassword(ctx context.Context, dto *PasswordResetConfirmRequest) error
+	DeleteUser(ctx context.Context) error
+	ListSessions(ctx context.Context) ([]*SessionResponse, error)
+	EndSession(ctx context.Context, ID string) error
+	SetupTwoFactor(ctx context.Context) (*TwoFactorSetupResponse, error)
+	ConfirmTwoFactor(ctx context.Context, dto *TwoFactorCodeRequest) (*RecoveryCodesResponse, error)
+	DisableTwoFactor(ctx context.Context, dto *TwoFactorCodeRequest) error
+	LoginTwoFactor(ctx context.Context, dto *TwoFactorLoginRequest) (*AuthTokens, error)
+	VerifyWithdrawal(ctx context.Context, userID int, withdraw *WithdrawRequest) error
 	GetUserIDFromContext(ctx context.Context) (int, error)
 }
 
+// AuthTokens пара токенов сессии: короткоживущий access-токен и refresh-токен для его обновления.
+// Если у пользователя включена 2FA, после пароля выдаётся только TwoFactorToken для второго шага входа.
+type AuthTokens struct {
+	AccessToken             string
+	AccessTokenExpiresAt    time.Time
+	RefreshToken            string
+	RefreshTokenExpiresAt   time.Time
+	TwoFactorToken          string
+	TwoFactorTokenExpiresAt time.Time
+}
+
 type UserHandler struct {
 	config *config.Config
 	UserService
@@ -26,8 +54,9 @@ func NewUserHandler(config *config.Config, service UserService) *UserHandler {
 }
 
 type RegisterRequest struct {
-	Login    string `json:"login" validate:"required"`
-	Password string `json:"password" validate:"required"`
+	Login      string `json:"login" validate:"required"`
+	Password   string `json:"password" validate:"required"`
+	ClientInfo `json:"-"`
 }
 
 func (userHandler *UserHandler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
@@ -42,23 +71,25 @@ func (userHandler *UserHandler) RegisterHandler(w http.ResponseWriter, r *http.R
 		sendClientErr(err, w)
 		return
 	}
+	dto.ClientInfo = NewClientInfo(r)
 	validate := validator.New(validator.WithRequiredStructEnabled())
 	err = validate.Struct(dto)
 	if err != nil {
 		sendClientErr(err, w)
 		return
 	}
-	token, err := userHandler.RegisterUser(r.Context(), &dto)
+	tokens, err := userHandler.RegisterUser(r.Context(), &dto)
 	if err != nil {
 		sendServerErr(err, w)
 		return
 	}
-	sendOKWithCookie(token, w)
+	sendOKWithCookie(userHandler.config, tokens, w)
 }
 
 type LoginRequest struct {
-	Login    string `json:"login" validate:"required"`
-	Password string `json:"password" validate:"required"`
+	Login      string `json:"login" validate:"required"`
+	Password   string `json:"password" validate:"required"`
+	ClientInfo `json:"-"`
 }
 
 func (userHandler *UserHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
@@ -73,16 +104,204 @@ func (userHandler *UserHandler) LoginHandler(w http.ResponseWriter, r *http.Requ
 		sendClientErr(err, w)
 		return
 	}
+	dto.ClientInfo = NewClientInfo(r)
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	err = validate.Struct(dto)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	tokens, err := userHandler.LoginUser(r.Context(), &dto)
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	if tokens.TwoFactorToken != "" {
+		sendTwoFactorChallenge(w, tokens)
+		return
+	}
+	sendOKWithCookie(userHandler.config, tokens, w)
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshResponse struct {
+	AccessToken           string `json:"access_token"`
+	AccessTokenExpiresAt  string `json:"access_token_expires_at"`
+	RefreshToken          string `json:"refresh_token"`
+	RefreshTokenExpiresAt string `json:"refresh_token_expires_at"`
+}
+
+// RefreshHandler выдаёт новую пару токенов по refresh-токену из тела запроса или, если его там нет, из cookie;
+// предъявленный токен отзывается. Новые токены отдаются и в cookie, и в теле ответа для API-клиентов.
+func (userHandler *UserHandler) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	buf, err := io.ReadAll(io.Reader(r.Body))
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	var dto RefreshRequest
+	if len(bytes.TrimSpace(buf)) > 0 {
+		err = json.Unmarshal(buf, &dto)
+		if err != nil {
+			sendClientErr(err, w)
+			return
+		}
+	}
+	if dto.RefreshToken == "" {
+		if cookie, err := r.Cookie(RefreshTokenCookie); err == nil {
+			dto.RefreshToken = cookie.Value
+		}
+	}
+	if dto.RefreshToken == "" {
+		SendProblem(w, http.StatusUnauthorized, customerr.CodeRefreshTokenInvalid, "refresh token is missing")
+		return
+	}
+	tokens, err := userHandler.RefreshTokens(r.Context(), dto.RefreshToken)
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	setSessionHeaders(userHandler.config, tokens, w)
+	sendOKWithBody(w, &RefreshResponse{
+		AccessToken:           tokens.AccessToken,
+		AccessTokenExpiresAt:  tokens.AccessTokenExpiresAt.Format(time.RFC3339),
+		RefreshToken:          tokens.RefreshToken,
+		RefreshTokenExpiresAt: tokens.RefreshTokenExpiresAt.Format(time.RFC3339),
+	})
+}
+
+func (userHandler *UserHandler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	err := userHandler.Logout(r.Context())
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	clearCookies(userHandler.config, w)
+	w.WriteHeader(http.StatusOK)
+}
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required"`
+}
+
+func (userHandler *UserHandler) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	buf, err := io.ReadAll(io.Reader(r.Body))
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	var dto ChangePasswordRequest
+	err = json.Unmarshal(buf, &dto)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	err = validate.Struct(dto)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	err = userHandler.ChangePassword(r.Context(), &dto)
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type PasswordResetRequest struct {
+	Login      string `json:"login" validate:"required"`
+	ClientInfo `json:"-"`
+}
+
+// PasswordResetHandler всегда отвечает 202, существует логин или нет
+func (userHandler *UserHandler) PasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	buf, err := io.ReadAll(io.Reader(r.Body))
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	var dto PasswordResetRequest
+	err = json.Unmarshal(buf, &dto)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	dto.ClientInfo = NewClientInfo(r)
 	validate := validator.New(validator.WithRequiredStructEnabled())
 	err = validate.Struct(dto)
 	if err != nil {
 		sendClientErr(err, w)
 		return
 	}
-	token, err := userHandler.LoginUser(r.Context(), &dto)
+	err = userHandler.RequestPasswordReset(r.Context(), &dto)
 	if err != nil {
 		sendServerErr(err, w)
 		return
 	}
-	sendOKWithCookie(token, w)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type PasswordResetConfirmRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+func (userHandler *UserHandler) PasswordResetConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	buf, err := io.ReadAll(io.Reader(r.Body))
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	var dto PasswordResetConfirmRequest
+	err = json.Unmarshal(buf, &dto)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	err = validate.Struct(dto)
+	if err != nil {
+		sendClientErr(err, w)
+		return
+	}
+	err = userHandler.ResetPassword(r.Context(), &dto)
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (userHandler *UserHandler) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	err := userHandler.DeleteUser(r.Context())
+	if err != nil {
+		sendServerErr(err, w)
+		return
+	}
+	clearCookies(userHandler.config, w)
+	w.WriteHeader(http.StatusOK)
+}
+
+// ClientInfo адрес и User-Agent клиента: по ним ограничиваются попытки входа и узнаются сессии
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+func NewClientInfo(r *http.Request) ClientInfo {
+	return ClientInfo{IP: ClientIP(r), UserAgent: r.UserAgent()}
+}
+
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
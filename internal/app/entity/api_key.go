@@ -0,0 +1,30 @@
+package entity
+
+import "time"
+
+// Права API-ключа
+const (
+	ScopeOrdersRead  = "orders:read"
+	ScopeOrdersWrite = "orders:write"
+	ScopeBalanceRead = "balance:read"
+)
+
+var APIKeyScopes = []string{ScopeOrdersRead, ScopeOrdersWrite, ScopeBalanceRead}
+
+// API-ключ для доступа сервисов партнёров от имени пользователя.
+// Хранится только хэш ключа, Prefix — его открытое начало, по которому ключ узнают в списке.
+type APIKey struct {
+	ID         int
+	UserID     int
+	Name       string
+	Prefix     string
+	KeyHash    string
+	Scopes     []string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	RevokedAt  time.Time
+}
+
+func (k *APIKey) IsRevoked() bool {
+	return !k.RevokedAt.IsZero()
+}
@@ -0,0 +1,27 @@
+package entity
+
+import "time"
+
+type AuditAction string
+
+const (
+	AuditListUsers     AuditAction = AuditAction("LIST_USERS")
+	AuditViewOrders    AuditAction = AuditAction("VIEW_ORDERS")
+	AuditViewBalance   AuditAction = AuditAction("VIEW_BALANCE")
+	AuditBlockUser     AuditAction = AuditAction("BLOCK_USER")
+	AuditUnblockUser   AuditAction = AuditAction("UNBLOCK_USER")
+	AuditAdjustBalance AuditAction = AuditAction("ADJUST_BALANCE")
+	AuditSetRole       AuditAction = AuditAction("SET_ROLE")
+	AuditViewAudit     AuditAction = AuditAction("VIEW_AUDIT")
+)
+
+// Запись журнала действий администраторов. Нулевой AdminID означает действие
+// из командной строки сервиса, нулевой UserID — действие не над конкретным пользователем.
+type AuditRecord struct {
+	ID        int64
+	AdminID   int
+	Action    AuditAction
+	UserID    int
+	Details   string
+	CreatedAt time.Time
+}
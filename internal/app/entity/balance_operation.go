@@ -21,11 +21,32 @@ const (
 // Операция с балансом пользователя
 type BalanceOperation struct {
 	ID        int
-	Sum       int
+	Sum       Money
 	Order     string
 	Status    ProcessStatus
 	Type      BalanceOperationType
 	UserID    int
 	CreatedAt time.Time
 	DeletedAt time.Time
+	// LeaseOwner экземпляр сервиса, захвативший заказ на опрос системы начислений
+	LeaseOwner     string
+	LeaseExpiresAt time.Time
+}
+
+// BalanceOperationFilter условия выборки страницы заказов или списаний пользователя.
+// Страницы листаются по ключу (CreatedAt, ID) последней записи предыдущей страницы, а не по смещению:
+// глубокие страницы не замедляются, а новые записи не сдвигают уже просмотренные.
+type BalanceOperationFilter struct {
+	UserID    int
+	Statuses  []ProcessStatus
+	From      time.Time
+	To        time.Time
+	Ascending bool
+	After     *BalanceOperationCursor
+	Limit     int
+}
+
+type BalanceOperationCursor struct {
+	CreatedAt time.Time
+	ID        int
 }
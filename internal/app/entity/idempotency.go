@@ -0,0 +1,20 @@
+package entity
+
+import "time"
+
+// Сохранённый результат запроса с заголовком Idempotency-Key.
+// Нулевой StatusCode означает, что запрос ещё выполняется.
+type IdempotencyRecord struct {
+	UserID      int
+	Key         string
+	Fingerprint string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+func (r *IdempotencyRecord) InProgress() bool {
+	return r.StatusCode == 0
+}
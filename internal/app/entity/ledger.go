@@ -0,0 +1,125 @@
+package entity
+
+import (
+	"strconv"
+	"time"
+)
+
+// Счёт в книге проводок
+type LedgerAccount string
+
+const (
+	// Источник начислений системы лояльности
+	AccrualSource LedgerAccount = LedgerAccount("ACCRUAL_SOURCE")
+	// Сток списанных пользователями баллов
+	WithdrawalSink LedgerAccount = LedgerAccount("WITHDRAWAL_SINK")
+	// Ручные корректировки баланса администратором
+	ManualAdjustment LedgerAccount = LedgerAccount("MANUAL_ADJUSTMENT")
+)
+
+// Префикс счёта-кошелька пользователя, за ним следует ID пользователя
+const UserWalletPrefix = "USER_WALLET:"
+
+func UserWallet(userID int) LedgerAccount {
+	return LedgerAccount(UserWalletPrefix + strconv.Itoa(userID))
+}
+
+// Проводка: перемещение суммы со счёта дебета на счёт кредита.
+// Проводки неизменяемы, исправления оформляются новыми проводками.
+type Posting struct {
+	ID                 int64
+	DebitAccount       LedgerAccount
+	CreditAccount      LedgerAccount
+	Amount             Money
+	UserID             int
+	BalanceOperationID int
+	CreatedAt          time.Time
+}
+
+// Проводка начисления баллов за обработанный заказ
+func NewAccrualPosting(balanceOperation *BalanceOperation) *Posting {
+	return &Posting{
+		DebitAccount:       AccrualSource,
+		CreditAccount:      UserWallet(balanceOperation.UserID),
+		Amount:             balanceOperation.Sum,
+		UserID:             balanceOperation.UserID,
+		BalanceOperationID: balanceOperation.ID,
+	}
+}
+
+// Проводка списания баллов, сумма операции списания хранится со знаком минус
+func NewWithdrawPosting(balanceOperation *BalanceOperation) *Posting {
+	return &Posting{
+		DebitAccount:       UserWallet(balanceOperation.UserID),
+		CreditAccount:      WithdrawalSink,
+		Amount:             balanceOperation.Sum.Neg(),
+		UserID:             balanceOperation.UserID,
+		BalanceOperationID: balanceOperation.ID,
+	}
+}
+
+// Проводка ручной корректировки: положительная сумма зачисляется в кошелёк, отрицательная списывается из него.
+// В сумму списаний пользователя корректировка не входит.
+func NewAdjustmentPosting(userID int, amount Money) *Posting {
+	posting := &Posting{
+		DebitAccount:  ManualAdjustment,
+		CreditAccount: UserWallet(userID),
+		Amount:        amount,
+		UserID:        userID,
+	}
+	if amount.IsNegative() {
+		posting.DebitAccount, posting.CreditAccount = posting.CreditAccount, posting.DebitAccount
+		posting.Amount = amount.Neg()
+	}
+	return posting
+}
+
+// CurrentDelta возвращает изменение текущего баланса пользователя от проводки
+func (p *Posting) CurrentDelta() Money {
+	wallet := UserWallet(p.UserID)
+	var delta Money
+	if p.CreditAccount == wallet {
+		delta += p.Amount
+	}
+	if p.DebitAccount == wallet {
+		delta -= p.Amount
+	}
+	return delta
+}
+
+// WithdrawnDelta возвращает изменение суммы списанных пользователем баллов от проводки
+func (p *Posting) WithdrawnDelta() Money {
+	if p.DebitAccount == UserWallet(p.UserID) && p.CreditAccount == WithdrawalSink {
+		return p.Amount
+	}
+	return 0
+}
+
+// Материализованный баланс пользователя
+type UserBalance struct {
+	UserID    int
+	Current   Money
+	Withdrawn Money
+}
+
+type LedgerInvariant string
+
+const (
+	// Материализованный баланс не совпадает с суммой проводок по кошельку
+	CurrentMismatch LedgerInvariant = LedgerInvariant("CURRENT_MISMATCH")
+	// Материализованная сумма списаний не совпадает с проводками в сток
+	WithdrawnMismatch LedgerInvariant = LedgerInvariant("WITHDRAWN_MISMATCH")
+	// Баланс кошелька ушёл в минус
+	NegativeBalance LedgerInvariant = LedgerInvariant("NEGATIVE_BALANCE")
+	// Обработанная операция не отражена в книге проводок
+	MissingPosting LedgerInvariant = LedgerInvariant("MISSING_POSTING")
+)
+
+// Нарушение инварианта книги проводок
+type LedgerViolation struct {
+	Invariant          LedgerInvariant
+	UserID             int
+	BalanceOperationID int
+	Expected           Money
+	Actual             Money
+}
@@ -0,0 +1,20 @@
+package entity
+
+import "time"
+
+// Счётчик неудачных попыток по ключу ограничения (логину, IP-адресу)
+type AttemptCounter struct {
+	Key         string
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// Запись аудита неудачной попытки входа
+type LoginAttempt struct {
+	ID        int
+	Login     string
+	IP        string
+	Reason    string
+	CreatedAt time.Time
+}
@@ -0,0 +1,164 @@
+package entity
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Количество копеек в одном балле
+const MoneyScale = 100
+
+var (
+	ErrInvalidMoney  = errors.New("invalid money amount")
+	ErrMoneyOverflow = errors.New("money amount overflow")
+	// Сумма из запроса клиента точнее копейки
+	ErrMoneyPrecision = errors.New("money amount has more than two fractional digits")
+)
+
+// Денежная сумма в копейках (1 балл = 1 рубль = 100 копеек).
+// Дробные копейки округляются до ближайшей копейки, половина - от нуля.
+type Money int64
+
+func NewMoney(units int64, cents int64) Money {
+	return Money(units*MoneyScale + cents)
+}
+
+// ParseMoney разбирает десятичную запись суммы без потери точности
+func ParseMoney(s string) (Money, error) {
+	rat, err := parseRat(s)
+	if err != nil {
+		return 0, err
+	}
+	return moneyFromRat(rat)
+}
+
+// ParseExactMoney разбирает сумму из запроса клиента: дробные копейки не округляются, а отклоняются
+func ParseExactMoney(s string) (Money, error) {
+	rat, err := parseRat(s)
+	if err != nil {
+		return 0, err
+	}
+	if !new(big.Rat).Mul(rat, big.NewRat(MoneyScale, 1)).IsInt() {
+		return 0, ErrMoneyPrecision
+	}
+	return moneyFromRat(rat)
+}
+
+func parseRat(s string) (*big.Rat, error) {
+	if s == "" || len(s) > 64 || strings.ContainsAny(s, "/xX") {
+		return nil, ErrInvalidMoney
+	}
+	// огромный показатель степени заставил бы big.Rat строить гигантское число
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		exp, err := strconv.Atoi(s[i+1:])
+		if err != nil || exp > 32 || exp < -32 {
+			return nil, ErrInvalidMoney
+		}
+	}
+	rat, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, ErrInvalidMoney
+	}
+	return rat, nil
+}
+
+func moneyFromRat(rat *big.Rat) (Money, error) {
+	scaled := new(big.Rat).Mul(rat, big.NewRat(MoneyScale, 1))
+	quo, rem := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	// округление половины от нуля: |2*rem| >= denom
+	rem.Abs(rem).Lsh(rem, 1)
+	if rem.Cmp(scaled.Denom()) >= 0 {
+		if scaled.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	if !quo.IsInt64() {
+		return 0, ErrMoneyOverflow
+	}
+	return Money(quo.Int64()), nil
+}
+
+func (m Money) Cents() int64 {
+	return int64(m)
+}
+
+func (m Money) Neg() Money {
+	return -m
+}
+
+func (m Money) IsPositive() bool {
+	return m > 0
+}
+
+func (m Money) IsNegative() bool {
+	return m < 0
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if (other > 0 && m > math.MaxInt64-other) || (other < 0 && m < math.MinInt64-other) {
+		return 0, ErrMoneyOverflow
+	}
+	return m + other, nil
+}
+
+// String возвращает минимальную десятичную запись: 500, 500.5, 729.98
+func (m Money) String() string {
+	sign := ""
+	value := uint64(m)
+	if m < 0 {
+		sign = "-"
+		value = uint64(-m)
+	}
+	units := value / MoneyScale
+	cents := value % MoneyScale
+	switch {
+	case cents == 0:
+		return fmt.Sprintf("%s%d", sign, units)
+	case cents%10 == 0:
+		return fmt.Sprintf("%s%d.%d", sign, units, cents/10)
+	default:
+		return fmt.Sprintf("%s%d.%02d", sign, units, cents)
+	}
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON принимает JSON-число или строку с десятичной записью суммы
+func (m *Money) UnmarshalJSON(data []byte) error {
+	return m.unmarshalJSON(data, ParseMoney)
+}
+
+// UnmarshalExactJSON как UnmarshalJSON, но для сумм из запросов клиентов: см. ParseExactMoney
+func (m *Money) UnmarshalExactJSON(data []byte) error {
+	return m.unmarshalJSON(data, ParseExactMoney)
+}
+
+func (m *Money) unmarshalJSON(data []byte, parse func(string) (Money, error)) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	raw := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		unquoted, err := strconv.Unquote(raw)
+		if err != nil {
+			return ErrInvalidMoney
+		}
+		raw = unquoted
+	}
+	value, err := parse(raw)
+	if err != nil {
+		return fmt.Errorf("%w: %q", err, raw)
+	}
+	*m = value
+	return nil
+}
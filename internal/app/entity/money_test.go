@@ -0,0 +1,89 @@
+package entity
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected Money
+		wantErr  bool
+	}{
+		{name: "integer", value: "500", expected: 50000},
+		{name: "one fraction digit", value: "500.5", expected: 50050},
+		{name: "float32 trap", value: "729.98", expected: 72998},
+		{name: "round half up", value: "0.005", expected: 1},
+		{name: "round down", value: "0.0049", expected: 0},
+		{name: "negative round half away from zero", value: "-0.005", expected: -1},
+		{name: "exponent", value: "1.5e2", expected: 15000},
+		{name: "empty", value: "", wantErr: true},
+		{name: "fraction", value: "1/3", wantErr: true},
+		{name: "huge exponent", value: "1e100000000", wantErr: true},
+		{name: "overflow", value: "100000000000000000000", wantErr: true},
+		{name: "garbage", value: "12a", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			money, err := ParseMoney(test.value)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, money)
+		})
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		money Money
+		json  string
+	}{
+		{name: "integer", money: 50000, json: "500"},
+		{name: "one fraction digit", money: 50050, json: "500.5"},
+		{name: "two fraction digits", money: 72998, json: "729.98"},
+		{name: "leading zero cents", money: 1005, json: "10.05"},
+		{name: "negative", money: -72998, json: "-729.98"},
+		{name: "zero", money: 0, json: "0"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := json.Marshal(test.money)
+			require.NoError(t, err)
+			assert.Equal(t, test.json, string(data))
+			var money Money
+			require.NoError(t, json.Unmarshal(data, &money))
+			assert.Equal(t, test.money, money)
+		})
+	}
+	var response AccrualResponse
+	require.NoError(t, json.Unmarshal([]byte(`{"order": "1", "status": "PROCESSED", "accrual": "729.98"}`), &response))
+	assert.Equal(t, Money(72998), response.Accrual)
+	assert.Error(t, json.Unmarshal([]byte(`{"accrual": true}`), &response))
+}
+
+func TestParseExactMoney(t *testing.T) {
+	money, err := ParseExactMoney("10.05")
+	require.NoError(t, err)
+	assert.Equal(t, Money(1005), money)
+	money, err = ParseExactMoney("1.5e1")
+	require.NoError(t, err)
+	assert.Equal(t, Money(1500), money)
+	_, err = ParseExactMoney("10.005")
+	assert.ErrorIs(t, err, ErrMoneyPrecision)
+	_, err = ParseExactMoney("abc")
+	assert.ErrorIs(t, err, ErrInvalidMoney)
+
+	var response AccrualResponse
+	require.NoError(t, json.Unmarshal([]byte(`{"accrual": 10.005}`), &response))
+	assert.Equal(t, Money(1001), response.Accrual)
+	assert.ErrorIs(t, money.UnmarshalExactJSON([]byte(`"10.005"`)), ErrMoneyPrecision)
+}
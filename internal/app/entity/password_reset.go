@@ -0,0 +1,22 @@
+package entity
+
+import "time"
+
+// Токен сброса пароля. Как и у refresh-токена, хранится только хэш;
+// токен одноразовый: после использования проставляется UsedAt.
+type PasswordResetToken struct {
+	ID        int
+	UserID    int
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    time.Time
+}
+
+func (t *PasswordResetToken) IsUsed() bool {
+	return !t.UsedAt.IsZero()
+}
+
+func (t *PasswordResetToken) IsExpired(now time.Time) bool {
+	return !t.ExpiresAt.After(now)
+}
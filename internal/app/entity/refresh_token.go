@@ -0,0 +1,24 @@
+package entity
+
+import "time"
+
+// Refresh-токен сессии. Хранится только хэш токена; при каждом обновлении
+// токен заменяется новым из того же семейства, а старый отзывается.
+// Семейство (FamilyID) соответствует одной сессии входа и попадает в access-токен как sid.
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	FamilyID  string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	RevokedAt time.Time
+}
+
+func (t *RefreshToken) IsRevoked() bool {
+	return !t.RevokedAt.IsZero()
+}
+
+func (t *RefreshToken) IsExpired(now time.Time) bool {
+	return !t.ExpiresAt.After(now)
+}
@@ -0,0 +1,15 @@
+package entity
+
+import "time"
+
+// Сессия входа. ID совпадает с семейством refresh-токенов (RefreshToken.FamilyID) и sid в access-токене;
+// сессия действует, пока в семействе есть неотозванный и не истёкший refresh-токен.
+type Session struct {
+	ID         string
+	UserID     int
+	IP         string
+	UserAgent  string
+	Device     string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+}
@@ -0,0 +1,20 @@
+package entity
+
+import "time"
+
+// Настройка двухфакторной аутентификации по TOTP (RFC 6238).
+// Пока ConfirmedAt не задан, секрет выдан, но пользователь ещё не подтвердил его кодом и 2FA не действует.
+// Коды восстановления хранятся только хэшами, использованный код удаляется.
+// LastStep — последний принятый шаг TOTP: код того же или более раннего шага повторно не принимается.
+type TwoFactor struct {
+	UserID        int
+	Secret        string
+	RecoveryCodes []string
+	LastStep      int64
+	CreatedAt     time.Time
+	ConfirmedAt   time.Time
+}
+
+func (f *TwoFactor) IsEnabled() bool {
+	return !f.ConfirmedAt.IsZero()
+}
@@ -2,11 +2,29 @@ package entity
 
 import "time"
 
+type Role string
+
+const (
+	RoleUser  Role = Role("USER")
+	RoleAdmin Role = Role("ADMIN")
+)
+
 // Пользователь
 type User struct {
 	ID        int
 	Login     string
 	Password  string
+	Role      Role
 	CreatedAt time.Time
 	DeletedAt time.Time
+	// BlockedAt момент блокировки администратором; заблокированный пользователь не может войти
+	BlockedAt time.Time
+}
+
+func (u *User) IsBlocked() bool {
+	return !u.BlockedAt.IsZero()
+}
+
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
 }
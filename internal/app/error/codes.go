@@ -0,0 +1,105 @@
+package error
+
+import "net/http"
+
+// Code стабильный машиночитаемый код ошибки, передаётся клиенту в поле code ответа application/problem+json.
+// Значения кодов — часть API: их можно добавлять, но нельзя менять.
+type Code string
+
+// Общие коды, когда у ошибки нет своего кода из каталога
+const (
+	CodeBadRequest       Code = "bad_request"
+	CodeValidationFailed Code = "validation_failed"
+	CodeUnauthorized     Code = "unauthorized"
+	CodeForbidden        Code = "forbidden"
+	CodeNotFound         Code = "not_found"
+	CodeConflict         Code = "conflict"
+	CodeUnsupportedMedia Code = "unsupported_media_type"
+	CodeRequestTooLarge  Code = "request_too_large"
+	CodeUnprocessable    Code = "unprocessable_entity"
+	CodeTooManyRequests  Code = "too_many_requests"
+	CodeInternal         Code = "internal_error"
+)
+
+// Пользователи, вход и сессии
+const (
+	CodeLoginTaken                Code = "login_taken"
+	CodeInvalidCredentials        Code = "invalid_credentials"
+	CodeUserNotFound              Code = "user_not_found"
+	CodeUserBlocked               Code = "user_blocked"
+	CodeTooManyAttempts           Code = "too_many_attempts"
+	CodeWeakPassword              Code = "weak_password"
+	CodeCurrentPasswordMismatch   Code = "current_password_mismatch"
+	CodePasswordResetTokenInvalid Code = "password_reset_token_invalid"
+	CodeRefreshTokenInvalid       Code = "refresh_token_invalid"
+	CodeRefreshTokenReused        Code = "refresh_token_reused"
+	CodeSessionRevoked            Code = "session_revoked"
+	CodeSessionNotFound           Code = "session_not_found"
+	CodeSessionRequired           Code = "session_required"
+)
+
+// Двухфакторная аутентификация
+const (
+	CodeTwoFactorNotSetUp       Code = "two_factor_not_set_up"
+	CodeTwoFactorAlreadyEnabled Code = "two_factor_already_enabled"
+	CodeTwoFactorRequired       Code = "two_factor_code_required"
+	CodeTwoFactorCodeInvalid    Code = "two_factor_code_invalid"
+	CodeTwoFactorTokenInvalid   Code = "two_factor_token_invalid"
+)
+
+// API-ключи
+const (
+	CodeAPIKeyInvalid     Code = "api_key_invalid"
+	CodeAPIKeyNotFound    Code = "api_key_not_found"
+	CodeUnknownScope      Code = "unknown_scope"
+	CodeInsufficientScope Code = "insufficient_scope"
+)
+
+// Заказы, баланс и списки
+const (
+	CodeOrderNumberInvalid         Code = "order_number_invalid"
+	CodeOrderAlreadyUploaded       Code = "order_already_uploaded"
+	CodeOrderUploadedByAnotherUser Code = "order_uploaded_by_another_user"
+	CodeInsufficientFunds          Code = "insufficient_funds"
+	CodeInvalidAmount              Code = "invalid_amount"
+	CodeInvalidQuery               Code = "invalid_query"
+)
+
+// Идемпотентность и администрирование
+const (
+	CodeIdempotencyKeyInvalid      Code = "idempotency_key_invalid"
+	CodeIdempotencyKeyReused       Code = "idempotency_key_reused"
+	CodeIdempotencyRequestInFlight Code = "idempotency_request_in_progress"
+	CodeCannotBlockSelf            Code = "cannot_block_self"
+	CodeUnknownRole                Code = "unknown_role"
+)
+
+// CodeForStatus общий код для ответа со статусом status; для успешных ответов кода нет
+func CodeForStatus(status int) Code {
+	switch {
+	case status < http.StatusBadRequest:
+		return ""
+	case status == http.StatusBadRequest:
+		return CodeBadRequest
+	case status == http.StatusUnauthorized:
+		return CodeUnauthorized
+	case status == http.StatusForbidden:
+		return CodeForbidden
+	case status == http.StatusNotFound:
+		return CodeNotFound
+	case status == http.StatusConflict:
+		return CodeConflict
+	case status == http.StatusRequestEntityTooLarge:
+		return CodeRequestTooLarge
+	case status == http.StatusUnsupportedMediaType:
+		return CodeUnsupportedMedia
+	case status == http.StatusUnprocessableEntity:
+		return CodeUnprocessable
+	case status == http.StatusTooManyRequests:
+		return CodeTooManyRequests
+	case status < http.StatusInternalServerError:
+		return CodeBadRequest
+	default:
+		return CodeInternal
+	}
+}
@@ -3,12 +3,25 @@ package error
 type CustomError struct {
 	Err        error
 	HTTPStatus int
+	Code       Code
 }
 
 func (err *CustomError) Error() string {
 	return err.Err.Error()
 }
 
+func (err *CustomError) Unwrap() error {
+	return err.Err
+}
+
+// ErrorCode код ошибки для клиента; если он не задан, берётся общий код по статусу ответа
+func (err *CustomError) ErrorCode() Code {
+	if err.Code != "" {
+		return err.Code
+	}
+	return CodeForStatus(err.HTTPStatus)
+}
+
 func NewError(err error, status int) *CustomError {
 	return &CustomError{
 		Err:        err,
@@ -16,6 +29,14 @@ func NewError(err error, status int) *CustomError {
 	}
 }
 
+func NewCodedError(err error, status int, code Code) *CustomError {
+	return &CustomError{
+		Err:        err,
+		HTTPStatus: status,
+		Code:       code,
+	}
+}
+
 func NewErrorWithoutStatus(err error) *CustomError {
 	return &CustomError{
 		Err: err,
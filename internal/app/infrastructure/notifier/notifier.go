@@ -0,0 +1,79 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	log "github.com/go-kit/log"
+)
+
+// Message уведомление пользователю; адресат — логин
+type Message struct {
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// Notifier доставляет уведомления пользователям. Пока почтовой рассылки нет,
+// сообщения пишутся в лог или в файл.
+type Notifier interface {
+	Send(ctx context.Context, message *Message) error
+}
+
+func NewNotifier(c *config.Config, logger log.Logger) (Notifier, error) {
+	switch c.NotifierType {
+	case "", config.NotifierTypeLog:
+		return NewLogNotifier(logger), nil
+	case config.NotifierTypeFile:
+		return NewFileNotifier(c.NotifierFile)
+	default:
+		return nil, fmt.Errorf("unknown notifier type %q", c.NotifierType)
+	}
+}
+
+type LogNotifier struct {
+	logger log.Logger
+}
+
+func NewLogNotifier(logger log.Logger) *LogNotifier {
+	return &LogNotifier{logger}
+}
+
+func (n *LogNotifier) Send(ctx context.Context, message *Message) error {
+	return n.logger.Log("msg", "notification", "to", message.To, "subject", message.Subject, "body", message.Body)
+}
+
+// FileNotifier дописывает сообщения в файл по одному JSON на строку
+type FileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileNotifier(path string) (*FileNotifier, error) {
+	if path == "" {
+		return nil, errors.New("notifier file is not configured")
+	}
+	return &FileNotifier{path: path}, nil
+}
+
+func (n *FileNotifier) Send(ctx context.Context, message *Message) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	file, err := os.OpenFile(n.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	return errors.Join(err, file.Close())
+}
@@ -0,0 +1,38 @@
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notifications.jsonl")
+	n, err := NewFileNotifier(path)
+	require.NoError(t, err)
+	require.NoError(t, n.Send(context.Background(), &Message{To: "first", Subject: "reset", Body: "token 1"}))
+	require.NoError(t, n.Send(context.Background(), &Message{To: "second", Subject: "reset", Body: "token 2"}))
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var got []Message
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var message Message
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &message))
+		got = append(got, message)
+	}
+	require.Len(t, got, 2)
+	assert.Equal(t, "first", got[0].To)
+	assert.Equal(t, "token 2", got[1].Body)
+
+	_, err = NewFileNotifier("")
+	assert.Error(t, err)
+}
@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/memory"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/postgres"
+)
+
+// AdminRepository изменения, которые делает администратор; каждое сохраняется
+// вместе с записью журнала в одной транзакции
+type AdminRepository interface {
+	FindUsers(ctx context.Context) ([]*entity.User, error)
+	FindUser(ctx context.Context, userID int) (*entity.User, error)
+	SetRole(ctx context.Context, login string, role entity.Role, record *entity.AuditRecord) error
+	SetBlocked(ctx context.Context, userID int, blocked bool, record *entity.AuditRecord) error
+	AdjustBalance(ctx context.Context, posting *entity.Posting, record *entity.AuditRecord) error
+	SaveAuditRecord(ctx context.Context, record *entity.AuditRecord) error
+	FindAuditRecords(ctx context.Context, userID int) ([]*entity.AuditRecord, error)
+}
+
+func NewAdminRepository(ctx context.Context, config *config.Config, storage *Storage) (AdminRepository, error) {
+	if storage.memory != nil {
+		return memory.NewAdminRepository(ctx, storage.memory)
+	}
+	return postgres.NewAdminRepository(ctx, config, storage.Pool)
+}
@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/memory"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/postgres"
+)
+
+type APIKeyRepository interface {
+	Save(ctx context.Context, key *entity.APIKey) error
+	FindByUser(ctx context.Context, userID int) ([]*entity.APIKey, error)
+	Revoke(ctx context.Context, userID int, ID int) error
+	RevokeUser(ctx context.Context, userID int) error
+	Use(ctx context.Context, keyHash string, lastUsedBefore time.Time) (*entity.APIKey, error)
+}
+
+func NewAPIKeyRepository(ctx context.Context, config *config.Config, storage *Storage) (APIKeyRepository, error) {
+	if storage.memory != nil {
+		return memory.NewAPIKeyRepository(ctx, storage.memory)
+	}
+	return postgres.NewAPIKeyRepository(ctx, config, storage.Pool)
+}
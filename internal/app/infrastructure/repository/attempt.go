@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/memory"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/postgres"
+)
+
+type AttemptRepository interface {
+	GetLockout(ctx context.Context, keys []string) (time.Duration, error)
+	RegisterFailure(ctx context.Context, key string, window time.Duration) (int, error)
+	Lock(ctx context.Context, key string, lockout time.Duration) error
+	Reset(ctx context.Context, key string) error
+	SaveLoginAttempt(ctx context.Context, attempt *entity.LoginAttempt) error
+}
+
+func NewAttemptRepository(ctx context.Context, config *config.Config, storage *Storage) (AttemptRepository, error) {
+	if storage.memory != nil {
+		return memory.NewAttemptRepository(ctx, storage.memory)
+	}
+	return postgres.NewAttemptRepository(ctx, config, storage.Pool)
+}
@@ -2,22 +2,28 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/memory"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/postgres"
 )
 
 type BalanceOperationRepository interface {
 	SaveOrder(ctx context.Context, balanceOperation *entity.BalanceOperation) error
-	FindOrdersByUser(ctx context.Context, userID int) ([]*entity.BalanceOperation, error)
-	GetBalanceByUser(ctx context.Context, userID int) (int, int, error)
-	FindWithdrawsByUser(ctx context.Context, userID int) ([]*entity.BalanceOperation, error)
+	FindOrdersByUser(ctx context.Context, filter *entity.BalanceOperationFilter) ([]*entity.BalanceOperation, error)
+	GetBalanceByUser(ctx context.Context, userID int) (*entity.UserBalance, error)
+	FindWithdrawsByUser(ctx context.Context, filter *entity.BalanceOperationFilter) ([]*entity.BalanceOperation, error)
 	SaveWithdraw(ctx context.Context, balanceOperation *entity.BalanceOperation) error
-	FindOrdersToProcess(ctx context.Context) ([]*entity.BalanceOperation, error)
+	FindOrdersToProcess(ctx context.Context, owner string, limit int, lease time.Duration, held []int) ([]*entity.BalanceOperation, error)
 	UpdateOrders(ctx context.Context, balanceOperation []*entity.BalanceOperation) error
+	CountOrdersByStatus(ctx context.Context) (map[entity.ProcessStatus]int, error)
 }
 
-func NewBalanceOperationRepository(ctx context.Context, config *config.Config) (BalanceOperationRepository, error) {
-	return postgres.NewBalanceOperationRepository(ctx, config, config.Pool)
+func NewBalanceOperationRepository(ctx context.Context, config *config.Config, storage *Storage) (BalanceOperationRepository, error) {
+	if storage.memory != nil {
+		return memory.NewBalanceOperationRepository(ctx, storage.memory)
+	}
+	return postgres.NewBalanceOperationRepository(ctx, config, storage.Pool)
 }
@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/memory"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/postgres"
+)
+
+type IdempotencyRepository interface {
+	Reserve(ctx context.Context, record *entity.IdempotencyRecord, ttl time.Duration, lockTTL time.Duration) (*entity.IdempotencyRecord, bool, error)
+	Complete(ctx context.Context, record *entity.IdempotencyRecord) error
+	Release(ctx context.Context, record *entity.IdempotencyRecord) error
+	DeleteExpired(ctx context.Context) error
+}
+
+func NewIdempotencyRepository(ctx context.Context, config *config.Config, storage *Storage) (IdempotencyRepository, error) {
+	if storage.memory != nil {
+		return memory.NewIdempotencyRepository(ctx, storage.memory)
+	}
+	return postgres.NewIdempotencyRepository(ctx, config, storage.Pool)
+}
@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/memory"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/postgres"
+)
+
+type LedgerRepository interface {
+	GetUserBalance(ctx context.Context, userID int) (*entity.UserBalance, error)
+	FindPostingsByUser(ctx context.Context, userID int) ([]*entity.Posting, error)
+	CheckInvariants(ctx context.Context) ([]*entity.LedgerViolation, error)
+}
+
+func NewLedgerRepository(ctx context.Context, config *config.Config, storage *Storage) (LedgerRepository, error) {
+	if storage.memory != nil {
+		return memory.NewLedgerRepository(ctx, storage.memory)
+	}
+	return postgres.NewLedgerRepository(ctx, config, storage.Pool)
+}
@@ -0,0 +1,116 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+)
+
+type AdminRepository struct {
+	*Storage
+}
+
+func NewAdminRepository(ctx context.Context, storage *Storage) (*AdminRepository, error) {
+	return &AdminRepository{storage}, nil
+}
+
+func (r *AdminRepository) FindUsers(ctx context.Context) ([]*entity.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]*entity.User, 0)
+	for _, el := range r.users {
+		if el.DeletedAt.IsZero() {
+			user := *el
+			user.Password = ""
+			result = append(result, &user)
+		}
+	}
+	return result, nil
+}
+
+func (r *AdminRepository) FindUser(ctx context.Context, userID int) (*entity.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user := r.findByID(userID)
+	if user == nil {
+		return nil, customerr.NewCodedError(errors.New("user not found"), http.StatusNotFound, customerr.CodeUserNotFound)
+	}
+	found := *user
+	found.Password = ""
+	return &found, nil
+}
+
+func (r *AdminRepository) SetRole(ctx context.Context, login string, role entity.Role, record *entity.AuditRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user := r.findByLogin(login)
+	if user == nil {
+		return customerr.NewCodedError(errors.New("user not found"), http.StatusNotFound, customerr.CodeUserNotFound)
+	}
+	user.Role = role
+	record.UserID = user.ID
+	r.saveAuditRecord(record)
+	return nil
+}
+
+func (r *AdminRepository) SetBlocked(ctx context.Context, userID int, blocked bool, record *entity.AuditRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user := r.findByID(userID)
+	if user == nil {
+		return customerr.NewCodedError(errors.New("user not found"), http.StatusNotFound, customerr.CodeUserNotFound)
+	}
+	if !blocked {
+		user.BlockedAt = time.Time{}
+	} else if !user.IsBlocked() {
+		user.BlockedAt = time.Now()
+	}
+	record.UserID = user.ID
+	r.saveAuditRecord(record)
+	return nil
+}
+
+func (r *AdminRepository) AdjustBalance(ctx context.Context, posting *entity.Posting, record *entity.AuditRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.findByID(posting.UserID) == nil {
+		return customerr.NewCodedError(errors.New("user not found"), http.StatusNotFound, customerr.CodeUserNotFound)
+	}
+	if posting.CurrentDelta().Neg() > r.userBalance(posting.UserID).Current {
+		return customerr.NewCodedError(errors.New("current balance < adjustment"), http.StatusConflict, customerr.CodeInsufficientFunds)
+	}
+	r.appendPosting(posting)
+	r.saveAuditRecord(record)
+	return nil
+}
+
+func (r *AdminRepository) SaveAuditRecord(ctx context.Context, record *entity.AuditRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.saveAuditRecord(record)
+	return nil
+}
+
+func (r *AdminRepository) FindAuditRecords(ctx context.Context, userID int) ([]*entity.AuditRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]*entity.AuditRecord, 0)
+	for _, el := range r.auditRecords {
+		if userID == 0 || el.UserID == userID {
+			record := *el
+			result = append(result, &record)
+		}
+	}
+	return result, nil
+}
+
+func (s *Storage) saveAuditRecord(record *entity.AuditRecord) {
+	record.ID = int64(len(s.auditRecords) + 1)
+	record.CreatedAt = time.Now()
+	saved := *record
+	s.auditRecords = append(s.auditRecords, &saved)
+}
@@ -0,0 +1,86 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+)
+
+type APIKeyRepository struct {
+	*Storage
+}
+
+func NewAPIKeyRepository(ctx context.Context, storage *Storage) (*APIKeyRepository, error) {
+	return &APIKeyRepository{storage}, nil
+}
+
+func (r *APIKeyRepository) Save(ctx context.Context, key *entity.APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key.ID = len(r.apiKeys) + 1
+	key.CreatedAt = time.Now()
+	r.apiKeys = append(r.apiKeys, copyAPIKey(key))
+	return nil
+}
+
+func (r *APIKeyRepository) FindByUser(ctx context.Context, userID int) ([]*entity.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]*entity.APIKey, 0)
+	for _, el := range r.apiKeys {
+		if el.UserID == userID && !el.IsRevoked() {
+			result = append(result, copyAPIKey(el))
+		}
+	}
+	return result, nil
+}
+
+func (r *APIKeyRepository) Revoke(ctx context.Context, userID int, ID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, el := range r.apiKeys {
+		if el.ID == ID && el.UserID == userID && !el.IsRevoked() {
+			el.RevokedAt = time.Now()
+			return nil
+		}
+	}
+	return customerr.NewCodedError(errors.New("api key not found"), http.StatusNotFound, customerr.CodeAPIKeyNotFound)
+}
+
+func (r *APIKeyRepository) RevokeUser(ctx context.Context, userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, el := range r.apiKeys {
+		if el.UserID == userID && !el.IsRevoked() {
+			el.RevokedAt = now
+		}
+	}
+	return nil
+}
+
+func (r *APIKeyRepository) Use(ctx context.Context, keyHash string, lastUsedBefore time.Time) (*entity.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, el := range r.apiKeys {
+		if el.KeyHash == keyHash && !el.IsRevoked() {
+			result := copyAPIKey(el)
+			if el.LastUsedAt.Before(lastUsedBefore) {
+				el.LastUsedAt = time.Now()
+			}
+			return result, nil
+		}
+	}
+	return nil, customerr.NewCodedError(errors.New("api key not found"), http.StatusUnauthorized, customerr.CodeAPIKeyInvalid)
+}
+
+func copyAPIKey(key *entity.APIKey) *entity.APIKey {
+	result := *key
+	result.Scopes = slices.Clone(key.Scopes)
+	return &result
+}
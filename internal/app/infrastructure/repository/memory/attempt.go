@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+)
+
+type AttemptRepository struct {
+	*Storage
+}
+
+func NewAttemptRepository(ctx context.Context, storage *Storage) (*AttemptRepository, error) {
+	return &AttemptRepository{storage}, nil
+}
+
+func (r *AttemptRepository) GetLockout(ctx context.Context, keys []string) (time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	var lockout time.Duration
+	for _, key := range keys {
+		if counter, ok := r.attemptCounters[key]; ok {
+			lockout = max(lockout, counter.LockedUntil.Sub(now))
+		}
+	}
+	return lockout, nil
+}
+
+func (r *AttemptRepository) RegisterFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	counter, ok := r.attemptCounters[key]
+	if !ok {
+		counter = &entity.AttemptCounter{Key: key}
+		r.attemptCounters[key] = counter
+	}
+	if counter.LastFailure.Before(now.Add(-window)) {
+		counter.Failures = 0
+	}
+	counter.Failures++
+	counter.LastFailure = now
+	return counter.Failures, nil
+}
+
+func (r *AttemptRepository) Lock(ctx context.Context, key string, lockout time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if counter, ok := r.attemptCounters[key]; ok {
+		counter.LockedUntil = time.Now().Add(lockout)
+	}
+	return nil
+}
+
+func (r *AttemptRepository) Reset(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.attemptCounters, key)
+	return nil
+}
+
+func (r *AttemptRepository) SaveLoginAttempt(ctx context.Context, attempt *entity.LoginAttempt) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	saved := *attempt
+	saved.ID = len(r.loginAttempts) + 1
+	saved.CreatedAt = time.Now()
+	r.loginAttempts = append(r.loginAttempts, &saved)
+	attempt.ID = saved.ID
+	attempt.CreatedAt = saved.CreatedAt
+	return nil
+}
@@ -0,0 +1,196 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+)
+
+type BalanceOperationRepository struct {
+	*Storage
+}
+
+func NewBalanceOperationRepository(ctx context.Context, storage *Storage) (*BalanceOperationRepository, error) {
+	return &BalanceOperationRepository{storage}, nil
+}
+
+func (r *BalanceOperationRepository) SaveOrder(ctx context.Context, balanceOperation *entity.BalanceOperation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.saveBalanceOperation(balanceOperation)
+}
+
+func (r *BalanceOperationRepository) FindOrdersByUser(ctx context.Context, filter *entity.BalanceOperationFilter) ([]*entity.BalanceOperation, error) {
+	return r.findPage(filter, func(el *entity.BalanceOperation) bool {
+		return el.Type == entity.ACCRUAL
+	})
+}
+
+func (r *BalanceOperationRepository) GetBalanceByUser(ctx context.Context, userID int) (*entity.UserBalance, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.userBalance(userID), nil
+}
+
+func (r *BalanceOperationRepository) FindWithdrawsByUser(ctx context.Context, filter *entity.BalanceOperationFilter) ([]*entity.BalanceOperation, error) {
+	return r.findPage(filter, func(el *entity.BalanceOperation) bool {
+		return el.Type == entity.WITHDRAW && el.Status == entity.PROCESSED
+	})
+}
+
+// findPage повторяет postgres.BalanceOperationRepository.findPage; kind — условие на вид операции
+func (r *BalanceOperationRepository) findPage(filter *entity.BalanceOperationFilter, kind func(el *entity.BalanceOperation) bool) ([]*entity.BalanceOperation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	compare := func(a *entity.BalanceOperation, createdAt time.Time, ID int) int {
+		result := a.CreatedAt.Compare(createdAt)
+		if result == 0 {
+			result = cmp.Compare(a.ID, ID)
+		}
+		if !filter.Ascending {
+			result = -result
+		}
+		return result
+	}
+	result := make([]*entity.BalanceOperation, 0)
+	for _, el := range r.balanceOperations {
+		if el.UserID != filter.UserID || !el.DeletedAt.IsZero() || !kind(el) {
+			continue
+		}
+		if len(filter.Statuses) > 0 && !slices.Contains(filter.Statuses, el.Status) {
+			continue
+		}
+		if !filter.From.IsZero() && el.CreatedAt.Before(filter.From) || !filter.To.IsZero() && !el.CreatedAt.Before(filter.To) {
+			continue
+		}
+		if filter.After != nil && compare(el, filter.After.CreatedAt, filter.After.ID) <= 0 {
+			continue
+		}
+		balance := *el
+		result = append(result, &balance)
+	}
+	slices.SortFunc(result, func(a, b *entity.BalanceOperation) int {
+		return compare(a, b.CreatedAt, b.ID)
+	})
+	if len(result) > filter.Limit {
+		result = result[:filter.Limit]
+	}
+	if len(result) == 0 {
+		return nil, customerr.NewError(errors.New("no content"), http.StatusNoContent)
+	}
+	return result, nil
+}
+
+func (r *BalanceOperationRepository) SaveWithdraw(ctx context.Context, balanceOperation *entity.BalanceOperation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if balanceOperation.Sum.Neg() > r.userBalance(balanceOperation.UserID).Current {
+		return customerr.NewCodedError(errors.New("current balance < withdraw"), http.StatusPaymentRequired, customerr.CodeInsufficientFunds)
+	}
+	err := r.saveBalanceOperation(balanceOperation)
+	if err != nil {
+		return err
+	}
+	r.appendPosting(entity.NewWithdrawPosting(balanceOperation))
+	return nil
+}
+
+// FindOrdersToProcess захватывает в аренду не больше limit новых заказов или заказов с истёкшей арендой,
+// кроме тех, что экземпляр ещё держит в очереди (held)
+func (r *BalanceOperationRepository) FindOrdersToProcess(ctx context.Context, owner string, limit int, lease time.Duration, held []int) ([]*entity.BalanceOperation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	skip := make(map[int]bool, len(held))
+	for _, id := range held {
+		skip[id] = true
+	}
+	result := make([]*entity.BalanceOperation, 0)
+	for _, el := range r.balanceOperations {
+		if len(result) >= limit {
+			break
+		}
+		if !el.DeletedAt.IsZero() || el.Type != entity.ACCRUAL || skip[el.ID] {
+			continue
+		}
+		if el.Status != entity.NEW && (el.Status != entity.PROCESSING || el.LeaseExpiresAt.After(now)) {
+			continue
+		}
+		el.Status = entity.PROCESSING
+		el.LeaseOwner = owner
+		el.LeaseExpiresAt = now.Add(lease)
+		result = append(result, &entity.BalanceOperation{
+			ID:             el.ID,
+			Order:          el.Order,
+			UserID:         el.UserID,
+			LeaseOwner:     el.LeaseOwner,
+			LeaseExpiresAt: el.LeaseExpiresAt,
+		})
+	}
+	return result, nil
+}
+
+func (r *BalanceOperationRepository) UpdateOrders(ctx context.Context, balanceOperations []*entity.BalanceOperation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, el := range balanceOperations {
+		saved := r.findBalanceOperationByID(el.ID)
+		if saved == nil || saved.Status == entity.PROCESSED || saved.Status == entity.INVALID || saved.LeaseOwner != el.LeaseOwner {
+			continue
+		}
+		saved.Status = el.Status
+		saved.Sum = el.Sum
+		saved.LeaseOwner = ""
+		saved.LeaseExpiresAt = time.Time{}
+		if saved.Status == entity.PROCESSED && saved.Sum.IsPositive() {
+			r.appendPosting(entity.NewAccrualPosting(saved))
+		}
+	}
+	return nil
+}
+
+func (r *BalanceOperationRepository) CountOrdersByStatus(ctx context.Context) (map[entity.ProcessStatus]int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make(map[entity.ProcessStatus]int)
+	for _, el := range r.balanceOperations {
+		if el.DeletedAt.IsZero() && el.Type == entity.ACCRUAL {
+			result[el.Status]++
+		}
+	}
+	return result, nil
+}
+
+// saveBalanceOperation повторяет семантику конфликта по номеру заказа из postgres.saveWithTx:
+// номер уникален в пределах типа операции
+func (s *Storage) saveBalanceOperation(balanceOperation *entity.BalanceOperation) error {
+	for _, el := range s.balanceOperations {
+		if el.Order != balanceOperation.Order || el.Type != balanceOperation.Type || !el.DeletedAt.IsZero() {
+			continue
+		}
+		if el.UserID == balanceOperation.UserID {
+			return customerr.NewCodedError(errors.New("order is already saved"), http.StatusOK, customerr.CodeOrderAlreadyUploaded)
+		}
+		return customerr.NewCodedError(errors.New("order is already saved for another user"), http.StatusConflict, customerr.CodeOrderUploadedByAnotherUser)
+	}
+	saved := *balanceOperation
+	saved.ID = len(s.balanceOperations) + 1
+	saved.CreatedAt = time.Now()
+	s.balanceOperations = append(s.balanceOperations, &saved)
+	balanceOperation.ID = saved.ID
+	balanceOperation.CreatedAt = saved.CreatedAt
+	return nil
+}
+
+func (s *Storage) findBalanceOperationByID(ID int) *entity.BalanceOperation {
+	if ID <= 0 || ID > len(s.balanceOperations) {
+		return nil
+	}
+	return s.balanceOperations[ID-1]
+}
@@ -0,0 +1,82 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+)
+
+type IdempotencyRepository struct {
+	*Storage
+}
+
+func NewIdempotencyRepository(ctx context.Context, storage *Storage) (*IdempotencyRepository, error) {
+	return &IdempotencyRepository{storage}, nil
+}
+
+func (r *IdempotencyRepository) Reserve(ctx context.Context, record *entity.IdempotencyRecord, ttl time.Duration, lockTTL time.Duration) (*entity.IdempotencyRecord, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := idempotencyKey{record.UserID, record.Key}
+	now := time.Now()
+	existing, ok := r.idempotencyKeys[key]
+	abandoned := ok && existing.InProgress() && existing.CreatedAt.Add(lockTTL).Before(now)
+	if ok && existing.ExpiresAt.After(now) && !abandoned {
+		found := *existing
+		return &found, false, nil
+	}
+	saved := *record
+	saved.StatusCode = 0
+	saved.ContentType = ""
+	saved.Body = nil
+	saved.CreatedAt = now
+	saved.ExpiresAt = now.Add(ttl)
+	r.idempotencyKeys[key] = &saved
+	record.CreatedAt = saved.CreatedAt
+	record.ExpiresAt = saved.ExpiresAt
+	return record, true, nil
+}
+
+func (r *IdempotencyRepository) Complete(ctx context.Context, record *entity.IdempotencyRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	saved, ok := r.idempotencyKeys[idempotencyKey{record.UserID, record.Key}]
+	if !ok || !heldBy(saved, record) {
+		return customerr.NewCodedError(errors.New("idempotency key is no longer held by this request"), http.StatusConflict, customerr.CodeIdempotencyRequestInFlight)
+	}
+	saved.StatusCode = record.StatusCode
+	saved.ContentType = record.ContentType
+	saved.Body = append([]byte(nil), record.Body...)
+	return nil
+}
+
+func (r *IdempotencyRepository) Release(ctx context.Context, record *entity.IdempotencyRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k := idempotencyKey{record.UserID, record.Key}
+	if saved, ok := r.idempotencyKeys[k]; ok && heldBy(saved, record) {
+		delete(r.idempotencyKeys, k)
+	}
+	return nil
+}
+
+// heldBy проверяет, что незавершённая запись занята именно этим запросом, а не повтором после lockTTL
+func heldBy(saved *entity.IdempotencyRecord, record *entity.IdempotencyRecord) bool {
+	return saved.InProgress() && saved.Fingerprint == record.Fingerprint && saved.CreatedAt.Equal(record.CreatedAt)
+}
+
+func (r *IdempotencyRepository) DeleteExpired(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for k, saved := range r.idempotencyKeys {
+		if !saved.ExpiresAt.After(now) {
+			delete(r.idempotencyKeys, k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+)
+
+type LedgerRepository struct {
+	*Storage
+}
+
+func NewLedgerRepository(ctx context.Context, storage *Storage) (*LedgerRepository, error) {
+	return &LedgerRepository{storage}, nil
+}
+
+func (r *LedgerRepository) GetUserBalance(ctx context.Context, userID int) (*entity.UserBalance, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.userBalance(userID), nil
+}
+
+func (r *LedgerRepository) FindPostingsByUser(ctx context.Context, userID int) ([]*entity.Posting, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]*entity.Posting, 0)
+	for _, el := range r.postings {
+		if el.UserID == userID {
+			posting := *el
+			result = append(result, &posting)
+		}
+	}
+	return result, nil
+}
+
+func (r *LedgerRepository) CheckInvariants(ctx context.Context) ([]*entity.LedgerViolation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ledger := make(map[int]*entity.UserBalance)
+	posted := make(map[int]bool)
+	for _, el := range r.postings {
+		balance, ok := ledger[el.UserID]
+		if !ok {
+			balance = &entity.UserBalance{UserID: el.UserID}
+			ledger[el.UserID] = balance
+		}
+		balance.Current += el.CurrentDelta()
+		balance.Withdrawn += el.WithdrawnDelta()
+		posted[el.BalanceOperationID] = true
+	}
+	result := make([]*entity.LedgerViolation, 0)
+	for userID := range r.balances {
+		if _, ok := ledger[userID]; !ok {
+			ledger[userID] = &entity.UserBalance{UserID: userID}
+		}
+	}
+	for userID, expected := range ledger {
+		actual := r.userBalance(userID)
+		if actual.Current != expected.Current {
+			result = append(result, &entity.LedgerViolation{Invariant: entity.CurrentMismatch, UserID: userID, Expected: expected.Current, Actual: actual.Current})
+		}
+		if actual.Withdrawn != expected.Withdrawn {
+			result = append(result, &entity.LedgerViolation{Invariant: entity.WithdrawnMismatch, UserID: userID, Expected: expected.Withdrawn, Actual: actual.Withdrawn})
+		}
+		if expected.Current.IsNegative() {
+			result = append(result, &entity.LedgerViolation{Invariant: entity.NegativeBalance, UserID: userID, Actual: expected.Current})
+		}
+	}
+	for _, el := range r.balanceOperations {
+		if el.DeletedAt.IsZero() && el.Status == entity.PROCESSED && el.Sum != 0 && !posted[el.ID] {
+			result = append(result, &entity.LedgerViolation{Invariant: entity.MissingPosting, UserID: el.UserID, BalanceOperationID: el.ID, Expected: el.Sum})
+		}
+	}
+	return result, nil
+}
+
+// appendPosting добавляет проводку и обновляет материализованный баланс, вызывается под блокировкой
+func (s *Storage) appendPosting(posting *entity.Posting) {
+	posting.ID = int64(len(s.postings) + 1)
+	posting.CreatedAt = time.Now()
+	saved := *posting
+	s.postings = append(s.postings, &saved)
+	balance, ok := s.balances[posting.UserID]
+	if !ok {
+		balance = &entity.UserBalance{UserID: posting.UserID}
+		s.balances[posting.UserID] = balance
+	}
+	balance.Current += posting.CurrentDelta()
+	balance.Withdrawn += posting.WithdrawnDelta()
+}
+
+func (s *Storage) userBalance(userID int) *entity.UserBalance {
+	balance, ok := s.balances[userID]
+	if !ok {
+		return &entity.UserBalance{UserID: userID}
+	}
+	result := *balance
+	return &result
+}
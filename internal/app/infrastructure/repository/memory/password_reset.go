@@ -0,0 +1,48 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+)
+
+type PasswordResetRepository struct {
+	*Storage
+}
+
+func NewPasswordResetRepository(ctx context.Context, storage *Storage) (*PasswordResetRepository, error) {
+	return &PasswordResetRepository{storage}, nil
+}
+
+func (r *PasswordResetRepository) Save(ctx context.Context, token *entity.PasswordResetToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	saved := *token
+	saved.ID = len(r.passwordResetTokens) + 1
+	saved.CreatedAt = time.Now()
+	r.passwordResetTokens = append(r.passwordResetTokens, &saved)
+	token.ID = saved.ID
+	token.CreatedAt = saved.CreatedAt
+	return nil
+}
+
+func (r *PasswordResetRepository) Consume(ctx context.Context, tokenHash string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, el := range r.passwordResetTokens {
+		if el.TokenHash != tokenHash {
+			continue
+		}
+		if el.IsUsed() || el.IsExpired(now) || r.findByID(el.UserID) == nil {
+			break
+		}
+		el.UsedAt = now
+		return el.UserID, nil
+	}
+	return 0, customerr.NewCodedError(errors.New("password reset token is invalid"), http.StatusBadRequest, customerr.CodePasswordResetTokenInvalid)
+}
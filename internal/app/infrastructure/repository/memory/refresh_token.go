@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+)
+
+type RefreshTokenRepository struct {
+	*Storage
+}
+
+func NewRefreshTokenRepository(ctx context.Context, storage *Storage) (*RefreshTokenRepository, error) {
+	return &RefreshTokenRepository{storage}, nil
+}
+
+func (r *RefreshTokenRepository) Save(ctx context.Context, token *entity.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.saveRefreshToken(token)
+	return nil
+}
+
+// Rotate повторяет postgres.RefreshTokenRepository.Rotate: повторное предъявление
+// отозванного токена отзывает всё семейство
+func (r *RefreshTokenRepository) Rotate(ctx context.Context, tokenHash string, next *entity.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var current *entity.RefreshToken
+	for _, el := range r.refreshTokens {
+		if el.TokenHash == tokenHash {
+			current = el
+			break
+		}
+	}
+	if current == nil {
+		return customerr.NewCodedError(errors.New("refresh token not found"), http.StatusUnauthorized, customerr.CodeRefreshTokenInvalid)
+	}
+	now := time.Now()
+	if current.IsRevoked() {
+		r.revokeFamily(current.FamilyID, now)
+		return customerr.NewCodedError(errors.New("refresh token reuse"), http.StatusUnauthorized, customerr.CodeRefreshTokenReused)
+	}
+	if current.IsExpired(now) {
+		return customerr.NewCodedError(errors.New("refresh token expired"), http.StatusUnauthorized, customerr.CodeRefreshTokenInvalid)
+	}
+	current.RevokedAt = now
+	next.UserID = current.UserID
+	next.FamilyID = current.FamilyID
+	r.saveRefreshToken(next)
+	return nil
+}
+
+func (r *RefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.revokeFamily(familyID, time.Now())
+	return nil
+}
+
+func (r *RefreshTokenRepository) RevokeUser(ctx context.Context, userID int, exceptFamilyID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, el := range r.refreshTokens {
+		if el.UserID == userID && el.FamilyID != exceptFamilyID && !el.IsRevoked() {
+			el.RevokedAt = now
+		}
+	}
+	return nil
+}
+
+func (r *RefreshTokenRepository) IsFamilyActive(ctx context.Context, familyID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.isFamilyActive(familyID, time.Now()), nil
+}
+
+func (s *Storage) saveRefreshToken(token *entity.RefreshToken) {
+	saved := *token
+	saved.ID = len(s.refreshTokens) + 1
+	saved.CreatedAt = time.Now()
+	s.refreshTokens = append(s.refreshTokens, &saved)
+	token.ID = saved.ID
+	token.CreatedAt = saved.CreatedAt
+}
+
+func (s *Storage) revokeFamily(familyID string, now time.Time) {
+	for _, el := range s.refreshTokens {
+		if el.FamilyID == familyID && !el.IsRevoked() {
+			el.RevokedAt = now
+		}
+	}
+}
+
+func (s *Storage) isFamilyActive(familyID string, now time.Time) bool {
+	for _, el := range s.refreshTokens {
+		if el.FamilyID == familyID && !el.IsRevoked() && !el.IsExpired(now) {
+			return true
+		}
+	}
+	return false
+}
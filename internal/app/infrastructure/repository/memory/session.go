@@ -0,0 +1,76 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+)
+
+type SessionRepository struct {
+	*Storage
+}
+
+func NewSessionRepository(ctx context.Context, storage *Storage) (*SessionRepository, error) {
+	return &SessionRepository{storage}, nil
+}
+
+func (r *SessionRepository) Save(ctx context.Context, session *entity.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session.CreatedAt = time.Now()
+	session.LastSeenAt = session.CreatedAt
+	saved := *session
+	r.sessions = append(r.sessions, &saved)
+	return nil
+}
+
+func (r *SessionRepository) FindByUser(ctx context.Context, userID int) ([]*entity.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	result := make([]*entity.Session, 0)
+	for _, el := range r.sessions {
+		if el.UserID == userID && r.isFamilyActive(el.ID, now) {
+			session := *el
+			result = append(result, &session)
+		}
+	}
+	slices.SortStableFunc(result, func(a, b *entity.Session) int {
+		return b.LastSeenAt.Compare(a.LastSeenAt)
+	})
+	return result, nil
+}
+
+func (r *SessionRepository) Touch(ctx context.Context, ID string, ip string, lastSeenBefore time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, el := range r.sessions {
+		if el.ID == ID && el.LastSeenAt.Before(lastSeenBefore) {
+			el.LastSeenAt = time.Now()
+			el.IP = ip
+		}
+	}
+	return nil
+}
+
+func (r *SessionRepository) End(ctx context.Context, userID int, ID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	ended := false
+	for _, el := range r.refreshTokens {
+		if el.FamilyID == ID && el.UserID == userID && !el.IsRevoked() && !el.IsExpired(now) {
+			el.RevokedAt = now
+			ended = true
+		}
+	}
+	if !ended {
+		return customerr.NewCodedError(errors.New("session not found"), http.StatusNotFound, customerr.CodeSessionNotFound)
+	}
+	return nil
+}
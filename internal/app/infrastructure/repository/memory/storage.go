@@ -0,0 +1,49 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+)
+
+type idempotencyKey struct {
+	userID int
+	key    string
+}
+
+// Storage хранит все данные сервиса в памяти процесса.
+// Один мьютекс на всё хранилище повторяет транзакционную семантику postgres-реализации.
+type Storage struct {
+	mu                  sync.Mutex
+	users               []*entity.User
+	balanceOperations   []*entity.BalanceOperation
+	postings            []*entity.Posting
+	balances            map[int]*entity.UserBalance
+	idempotencyKeys     map[idempotencyKey]*entity.IdempotencyRecord
+	refreshTokens       []*entity.RefreshToken
+	attemptCounters     map[string]*entity.AttemptCounter
+	loginAttempts       []*entity.LoginAttempt
+	passwordResetTokens []*entity.PasswordResetToken
+	auditRecords        []*entity.AuditRecord
+	apiKeys             []*entity.APIKey
+	twoFactors          map[int]*entity.TwoFactor
+	sessions            []*entity.Session
+}
+
+func NewStorage() *Storage {
+	return &Storage{
+		users:               make([]*entity.User, 0),
+		balanceOperations:   make([]*entity.BalanceOperation, 0),
+		postings:            make([]*entity.Posting, 0),
+		balances:            make(map[int]*entity.UserBalance),
+		idempotencyKeys:     make(map[idempotencyKey]*entity.IdempotencyRecord),
+		refreshTokens:       make([]*entity.RefreshToken, 0),
+		attemptCounters:     make(map[string]*entity.AttemptCounter),
+		loginAttempts:       make([]*entity.LoginAttempt, 0),
+		passwordResetTokens: make([]*entity.PasswordResetToken, 0),
+		auditRecords:        make([]*entity.AuditRecord, 0),
+		apiKeys:             make([]*entity.APIKey, 0),
+		twoFactors:          make(map[int]*entity.TwoFactor),
+		sessions:            make([]*entity.Session, 0),
+	}
+}
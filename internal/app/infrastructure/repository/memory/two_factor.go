@@ -0,0 +1,95 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+)
+
+type TwoFactorRepository struct {
+	*Storage
+}
+
+func NewTwoFactorRepository(ctx context.Context, storage *Storage) (*TwoFactorRepository, error) {
+	return &TwoFactorRepository{storage}, nil
+}
+
+// Save заменяет неподтверждённую настройку; подтверждённую сначала нужно отключить
+func (r *TwoFactorRepository) Save(ctx context.Context, f *entity.TwoFactor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if el, ok := r.twoFactors[f.UserID]; ok && el.IsEnabled() {
+		return customerr.NewCodedError(errors.New("two-factor authentication is already enabled"), http.StatusConflict, customerr.CodeTwoFactorAlreadyEnabled)
+	}
+	f.CreatedAt = time.Now()
+	r.twoFactors[f.UserID] = copyTwoFactor(f)
+	return nil
+}
+
+func (r *TwoFactorRepository) FindByUser(ctx context.Context, userID int) (*entity.TwoFactor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	el, ok := r.twoFactors[userID]
+	if !ok {
+		return nil, customerr.NewCodedError(errors.New("two-factor authentication is not set up"), http.StatusNotFound, customerr.CodeTwoFactorNotSetUp)
+	}
+	return copyTwoFactor(el), nil
+}
+
+func (r *TwoFactorRepository) Confirm(ctx context.Context, userID int, step int64, recoveryCodes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	el, ok := r.twoFactors[userID]
+	if !ok || el.IsEnabled() {
+		return customerr.NewCodedError(errors.New("two-factor authentication is not set up or already enabled"), http.StatusConflict, customerr.CodeTwoFactorAlreadyEnabled)
+	}
+	el.ConfirmedAt = time.Now()
+	el.LastStep = step
+	el.RecoveryCodes = slices.Clone(recoveryCodes)
+	return nil
+}
+
+func (r *TwoFactorRepository) UseStep(ctx context.Context, userID int, step int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	el, ok := r.twoFactors[userID]
+	if !ok || el.LastStep >= step {
+		return customerr.NewCodedError(errors.New("two-factor code is already used"), http.StatusUnauthorized, customerr.CodeTwoFactorCodeInvalid)
+	}
+	el.LastStep = step
+	return nil
+}
+
+func (r *TwoFactorRepository) UseRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	el, ok := r.twoFactors[userID]
+	if !ok || !slices.Contains(el.RecoveryCodes, codeHash) {
+		return customerr.NewCodedError(errors.New("recovery code not found"), http.StatusUnauthorized, customerr.CodeTwoFactorCodeInvalid)
+	}
+	el.RecoveryCodes = slices.DeleteFunc(el.RecoveryCodes, func(code string) bool {
+		return code == codeHash
+	})
+	return nil
+}
+
+func (r *TwoFactorRepository) Delete(ctx context.Context, userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.twoFactors[userID]; !ok {
+		return customerr.NewCodedError(errors.New("two-factor authentication is not set up"), http.StatusNotFound, customerr.CodeTwoFactorNotSetUp)
+	}
+	delete(r.twoFactors, userID)
+	return nil
+}
+
+func copyTwoFactor(f *entity.TwoFactor) *entity.TwoFactor {
+	result := *f
+	result.RecoveryCodes = slices.Clone(f.RecoveryCodes)
+	return &result
+}
@@ -0,0 +1,126 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+)
+
+type UserRepository struct {
+	*Storage
+}
+
+func NewUserRepository(ctx context.Context, storage *Storage) (*UserRepository, error) {
+	return &UserRepository{storage}, nil
+}
+
+func (r *UserRepository) Save(ctx context.Context, user *entity.User) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.findByLogin(user.Login) != nil {
+		return 0, customerr.NewCodedError(
+			errors.New("login conflict"),
+			http.StatusConflict,
+			customerr.CodeLoginTaken,
+		)
+	}
+	saved := *user
+	saved.ID = len(r.users) + 1
+	saved.CreatedAt = time.Now()
+	if saved.Role == "" {
+		saved.Role = entity.RoleUser
+	}
+	r.users = append(r.users, &saved)
+	return saved.ID, nil
+}
+
+func (r *UserRepository) FindByLogin(ctx context.Context, login string) (*entity.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user := r.findByLogin(login)
+	if user == nil {
+		return nil, customerr.NewCodedError(
+			errors.New("user not found"),
+			http.StatusUnauthorized,
+			customerr.CodeUserNotFound,
+		)
+	}
+	found := *user
+	return &found, nil
+}
+
+func (r *UserRepository) ExistsByID(ctx context.Context, ID int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user := r.findByID(ID)
+	return user != nil && !user.IsBlocked()
+}
+
+func (r *UserRepository) FindByID(ctx context.Context, ID int) (*entity.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user := r.findByID(ID)
+	if user == nil {
+		return nil, customerr.NewCodedError(
+			errors.New("user not found"),
+			http.StatusUnauthorized,
+			customerr.CodeUserNotFound,
+		)
+	}
+	found := *user
+	return &found, nil
+}
+
+func (r *UserRepository) UpdatePassword(ctx context.Context, ID int, password string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user := r.findByID(ID)
+	if user == nil {
+		return customerr.NewCodedError(
+			errors.New("user not found"),
+			http.StatusUnauthorized,
+			customerr.CodeUserNotFound,
+		)
+	}
+	user.Password = password
+	return nil
+}
+
+func (r *UserRepository) Delete(ctx context.Context, ID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user := r.findByID(ID)
+	if user == nil {
+		return customerr.NewCodedError(
+			errors.New("user not found"),
+			http.StatusUnauthorized,
+			customerr.CodeUserNotFound,
+		)
+	}
+	user.DeletedAt = time.Now()
+	return nil
+}
+
+func (s *Storage) findByLogin(login string) *entity.User {
+	for _, user := range s.users {
+		if user.Login == login && user.DeletedAt.IsZero() {
+			return user
+		}
+	}
+	return nil
+}
+
+func (s *Storage) findByID(ID int) *entity.User {
+	if ID <= 0 || ID > len(s.users) {
+		return nil
+	}
+	user := s.users[ID-1]
+	if !user.DeletedAt.IsZero() {
+		return nil
+	}
+	return user
+}
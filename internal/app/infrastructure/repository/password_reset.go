@@ -0,0 +1,22 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/memory"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/postgres"
+)
+
+type PasswordResetRepository interface {
+	Save(ctx context.Context, token *entity.PasswordResetToken) error
+	Consume(ctx context.Context, tokenHash string) (int, error)
+}
+
+func NewPasswordResetRepository(ctx context.Context, config *config.Config, storage *Storage) (PasswordResetRepository, error) {
+	if storage.memory != nil {
+		return memory.NewPasswordResetRepository(ctx, storage.memory)
+	}
+	return postgres.NewPasswordResetRepository(ctx, config, storage.Pool)
+}
@@ -0,0 +1,185 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AdminRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewAdminRepository(ctx context.Context, config *config.Config, pool *pgxpool.Pool) (*AdminRepository, error) {
+	return &AdminRepository{pool: pool}, nil
+}
+
+func (r *AdminRepository) FindUsers(ctx context.Context) ([]*entity.User, error) {
+	query := `
+		select "id", "login", "role", "created_at", "blocked_at" from "user" where "deleted_at" is null order by "id"
+	`
+	rows, err := r.pool.Query(ctx, query)
+	if err != nil {
+		return nil, customerr.NewError(err, http.StatusInternalServerError)
+	}
+	defer rows.Close()
+	result := make([]*entity.User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows, false)
+		if err != nil {
+			return nil, customerr.NewError(err, http.StatusInternalServerError)
+		}
+		result = append(result, user)
+	}
+	if rows.Err() != nil {
+		return nil, customerr.NewError(rows.Err(), http.StatusInternalServerError)
+	}
+	return result, nil
+}
+
+// FindUser в отличие от UserRepository.FindByID находит и заблокированных пользователей
+func (r *AdminRepository) FindUser(ctx context.Context, userID int) (*entity.User, error) {
+	query := `
+		select "id", "login", "role", "created_at", "blocked_at" from "user" where "id" = $1 and "deleted_at" is null
+	`
+	user, err := scanUser(r.pool.QueryRow(ctx, query, userID), false)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, customerr.NewCodedError(errors.New("user not found"), http.StatusNotFound, customerr.CodeUserNotFound)
+	}
+	if err != nil {
+		return nil, customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return user, nil
+}
+
+func (r *AdminRepository) SetRole(ctx context.Context, login string, role entity.Role, record *entity.AuditRecord) error {
+	query := `
+		update "user" set "role" = $2 where "login" = $1 and "deleted_at" is null returning "id"
+	`
+	return r.updateUserWithAudit(ctx, record, func(tx pgx.Tx) error {
+		return tx.QueryRow(ctx, query, login, string(role)).Scan(&record.UserID)
+	})
+}
+
+// SetBlocked блокирует или разблокирует пользователя; повторная блокировка не сдвигает её время
+func (r *AdminRepository) SetBlocked(ctx context.Context, userID int, blocked bool, record *entity.AuditRecord) error {
+	query := `
+		update "user" set "blocked_at" = case when $2 then coalesce("blocked_at", now()) end
+		where "id" = $1 and "deleted_at" is null returning "id"
+	`
+	return r.updateUserWithAudit(ctx, record, func(tx pgx.Tx) error {
+		return tx.QueryRow(ctx, query, userID, blocked).Scan(&record.UserID)
+	})
+}
+
+func (r *AdminRepository) updateUserWithAudit(ctx context.Context, record *entity.AuditRecord, update func(tx pgx.Tx) error) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	defer tx.Rollback(ctx)
+	err = update(tx)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return customerr.NewCodedError(errors.New("user not found"), http.StatusNotFound, customerr.CodeUserNotFound)
+	}
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	if err = saveAuditRecordWithTx(ctx, tx, record); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// AdjustBalance проводит корректировку через книгу проводок; списать больше текущего баланса нельзя
+func (r *AdminRepository) AdjustBalance(ctx context.Context, posting *entity.Posting, record *entity.AuditRecord) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	defer tx.Rollback(ctx)
+	var exists bool
+	err = tx.QueryRow(ctx, `select exists(select * from "user" where "id" = $1 and "deleted_at" is null)`, posting.UserID).Scan(&exists)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	if !exists {
+		return customerr.NewCodedError(errors.New("user not found"), http.StatusNotFound, customerr.CodeUserNotFound)
+	}
+	balance, err := lockUserBalanceWithTx(ctx, tx, posting.UserID)
+	if err != nil {
+		return err
+	}
+	if posting.CurrentDelta().Neg() > balance.Current {
+		return customerr.NewCodedError(errors.New("current balance < adjustment"), http.StatusConflict, customerr.CodeInsufficientFunds)
+	}
+	err = appendPostingWithTx(ctx, tx, posting)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.ConstraintName == "user_balance_current_check" {
+			return customerr.NewCodedError(errors.New("current balance < adjustment"), http.StatusConflict, customerr.CodeInsufficientFunds)
+		}
+		return err
+	}
+	if err = saveAuditRecordWithTx(ctx, tx, record); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func (r *AdminRepository) SaveAuditRecord(ctx context.Context, record *entity.AuditRecord) error {
+	return saveAuditRecordWithTx(ctx, r.pool, record)
+}
+
+func (r *AdminRepository) FindAuditRecords(ctx context.Context, userID int) ([]*entity.AuditRecord, error) {
+	query := `
+		select "id", coalesce("admin_id", 0), "action", coalesce("user_id", 0), "details", "created_at"
+		from "admin_audit" where $1 = 0 or "user_id" = $1 order by "id"
+	`
+	rows, err := r.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, customerr.NewError(err, http.StatusInternalServerError)
+	}
+	defer rows.Close()
+	result := make([]*entity.AuditRecord, 0)
+	for rows.Next() {
+		record := &entity.AuditRecord{}
+		var action string
+		err = rows.Scan(&record.ID, &record.AdminID, &action, &record.UserID, &record.Details, &record.CreatedAt)
+		if err != nil {
+			return nil, customerr.NewError(err, http.StatusInternalServerError)
+		}
+		record.Action = entity.AuditAction(action)
+		result = append(result, record)
+	}
+	if rows.Err() != nil {
+		return nil, customerr.NewError(rows.Err(), http.StatusInternalServerError)
+	}
+	return result, nil
+}
+
+func saveAuditRecordWithTx(ctx context.Context, q pgxQuerier, record *entity.AuditRecord) error {
+	query := `
+		insert into "admin_audit" ("admin_id", "action", "user_id", "details") values (nullif($1, 0), $2, nullif($3, 0), $4)
+		returning "id", "created_at"
+	`
+	err := q.QueryRow(ctx, query, record.AdminID, string(record.Action), record.UserID, record.Details).Scan(&record.ID, &record.CreatedAt)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type APIKeyRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewAPIKeyRepository(ctx context.Context, config *config.Config, pool *pgxpool.Pool) (*APIKeyRepository, error) {
+	return &APIKeyRepository{pool: pool}, nil
+}
+
+func (r *APIKeyRepository) Save(ctx context.Context, key *entity.APIKey) error {
+	query := `
+		insert into "api_key" ("user_id", "name", "prefix", "key_hash", "scopes") values ($1, $2, $3, $4, $5)
+		returning "id", "created_at"
+	`
+	err := r.pool.QueryRow(ctx, query, key.UserID, key.Name, key.Prefix, key.KeyHash, key.Scopes).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// FindByUser возвращает действующие ключи пользователя
+func (r *APIKeyRepository) FindByUser(ctx context.Context, userID int) ([]*entity.APIKey, error) {
+	query := `
+		select "id", "user_id", "name", "prefix", "scopes", "created_at", "last_used_at"
+		from "api_key" where "user_id" = $1 and "revoked_at" is null order by "id"
+	`
+	rows, err := r.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, customerr.NewError(err, http.StatusInternalServerError)
+	}
+	defer rows.Close()
+	result := make([]*entity.APIKey, 0)
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, customerr.NewError(err, http.StatusInternalServerError)
+		}
+		result = append(result, key)
+	}
+	if rows.Err() != nil {
+		return nil, customerr.NewError(rows.Err(), http.StatusInternalServerError)
+	}
+	return result, nil
+}
+
+func (r *APIKeyRepository) Revoke(ctx context.Context, userID int, ID int) error {
+	query := `
+		update "api_key" set "revoked_at" = now() where "id" = $1 and "user_id" = $2 and "revoked_at" is null
+	`
+	tag, err := r.pool.Exec(ctx, query, ID, userID)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	if tag.RowsAffected() == 0 {
+		return customerr.NewCodedError(errors.New("api key not found"), http.StatusNotFound, customerr.CodeAPIKeyNotFound)
+	}
+	return nil
+}
+
+// RevokeUser отзывает все ключи пользователя
+func (r *APIKeyRepository) RevokeUser(ctx context.Context, userID int) error {
+	query := `
+		update "api_key" set "revoked_at" = now() where "user_id" = $1 and "revoked_at" is null
+	`
+	_, err := r.pool.Exec(ctx, query, userID)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// Use находит действующий ключ по хэшу и отмечает время его использования,
+// если прошлая отметка была раньше lastUsedBefore
+func (r *APIKeyRepository) Use(ctx context.Context, keyHash string, lastUsedBefore time.Time) (*entity.APIKey, error) {
+	query := `
+		with "key" as (
+			select "id", "user_id", "name", "prefix", "scopes", "created_at", "last_used_at"
+			from "api_key" where "key_hash" = $1 and "revoked_at" is null
+		), "touched" as (
+			update "api_key" set "last_used_at" = now()
+			where "id" in (select "id" from "key") and ("last_used_at" is null or "last_used_at" < $2)
+		)
+		select "id", "user_id", "name", "prefix", "scopes", "created_at", "last_used_at" from "key"
+	`
+	key, err := scanAPIKey(r.pool.QueryRow(ctx, query, keyHash, lastUsedBefore))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, customerr.NewCodedError(errors.New("api key not found"), http.StatusUnauthorized, customerr.CodeAPIKeyInvalid)
+	}
+	if err != nil {
+		return nil, customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return key, nil
+}
+
+func scanAPIKey(row pgx.Row) (*entity.APIKey, error) {
+	key := &entity.APIKey{}
+	var lastUsedAt *time.Time
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Scopes, &key.CreatedAt, &lastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastUsedAt != nil {
+		key.LastUsedAt = *lastUsedAt
+	}
+	return key, nil
+}
@@ -0,0 +1,86 @@
+package postgres
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AttemptRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewAttemptRepository(ctx context.Context, config *config.Config, pool *pgxpool.Pool) (*AttemptRepository, error) {
+	return &AttemptRepository{pool: pool}, nil
+}
+
+// GetLockout возвращает, сколько ещё действует самая долгая блокировка среди ключей.
+// Время считается на стороне базы, чтобы не зависеть от часов экземпляров сервиса.
+func (r *AttemptRepository) GetLockout(ctx context.Context, keys []string) (time.Duration, error) {
+	query := `
+		select coalesce(extract(epoch from max("locked_until") - now()), 0)::float8
+		from "attempt_counter" where "key" = any($1) and "locked_until" > now()
+	`
+	var seconds float64
+	err := r.pool.QueryRow(ctx, query, keys).Scan(&seconds)
+	if err != nil {
+		return 0, customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// RegisterFailure увеличивает счётчик неудач; счётчик начинается заново, если предыдущая неудача старше window
+func (r *AttemptRepository) RegisterFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	query := `
+		insert into "attempt_counter" ("key", "failures", "last_failure") values ($1, 1, now())
+		on conflict ("key") do update set
+			"failures" = case when "attempt_counter"."last_failure" < now() - $2::interval then 1 else "attempt_counter"."failures" + 1 end,
+			"last_failure" = now()
+		returning "failures"
+	`
+	var failures int
+	err := r.pool.QueryRow(ctx, query, key, window).Scan(&failures)
+	if err != nil {
+		return 0, customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return failures, nil
+}
+
+func (r *AttemptRepository) Lock(ctx context.Context, key string, lockout time.Duration) error {
+	query := `
+		update "attempt_counter" set "locked_until" = now() + $2::interval where "key" = $1
+	`
+	_, err := r.pool.Exec(ctx, query, key, lockout)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func (r *AttemptRepository) Reset(ctx context.Context, key string) error {
+	query := `
+		delete from "attempt_counter" where "key" = $1
+	`
+	_, err := r.pool.Exec(ctx, query, key)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func (r *AttemptRepository) SaveLoginAttempt(ctx context.Context, attempt *entity.LoginAttempt) error {
+	query := `
+		insert into "login_attempt" ("login", "ip", "reason") values ($1, $2, $3) returning "id", "created_at"
+	`
+	err := r.pool.QueryRow(ctx, query, attempt.Login, attempt.IP, attempt.Reason).Scan(&attempt.ID, &attempt.CreatedAt)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
@@ -4,12 +4,16 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
 	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -26,78 +30,80 @@ func (r *BalanceOperationRepository) SaveOrder(ctx context.Context, balanceOpera
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
 	err = r.saveWithTx(ctx, tx, balanceOperation)
 	if err != nil {
 		return err
 	}
-	tx.Commit(ctx)
-	return nil
+	return tx.Commit(ctx)
 }
 
-func (r *BalanceOperationRepository) FindOrdersByUser(ctx context.Context, userID int) ([]*entity.BalanceOperation, error) {
-	query := `
-		select "id", "order", "status", "sum", "created_at" from "balance_operation" where "user_id" = $1 and "deleted_at" is null 
-	`
-	rows, err := r.pool.Query(ctx, query, userID)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, customerr.NewError(pgx.ErrNoRows, http.StatusNoContent)
-		}
-		return nil, customerr.NewError(err, http.StatusInternalServerError)
+func (r *BalanceOperationRepository) FindOrdersByUser(ctx context.Context, filter *entity.BalanceOperationFilter) ([]*entity.BalanceOperation, error) {
+	return r.findPage(ctx, `"type" = 'ACCRUAL'`, filter)
+}
+
+func (r *BalanceOperationRepository) GetBalanceByUser(ctx context.Context, userID int) (*entity.UserBalance, error) {
+	return getUserBalance(ctx, r.pool, userID)
+}
+
+func (r *BalanceOperationRepository) FindWithdrawsByUser(ctx context.Context, filter *entity.BalanceOperationFilter) ([]*entity.BalanceOperation, error) {
+	return r.findPage(ctx, `"type" = 'WITHDRAW' and "status" = 'PROCESSED'`, filter)
+}
+
+// findPage выбирает страницу операций пользователя; condition — условие на вид операции.
+// Запрос обслуживается индексами balance_operation_user_orders_idx и balance_operation_user_withdraws_idx.
+func (r *BalanceOperationRepository) findPage(ctx context.Context, condition string, filter *entity.BalanceOperationFilter) ([]*entity.BalanceOperation, error) {
+	args := []any{filter.UserID}
+	arg := func(value any) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
 	}
-	result := make([]*entity.BalanceOperation, 0)
-	for rows.Next() {
-		balance := &entity.BalanceOperation{}
-		var status string
-		err = rows.Scan(&balance.ID, &balance.Order, &status, &balance.Sum, &balance.CreatedAt)
-		balance.Status = entity.ProcessStatus(status)
-		if err != nil {
-			return nil, customerr.NewError(err, http.StatusInternalServerError)
+	where := []string{`"user_id" = $1`, `"deleted_at" is null`, condition}
+	if len(filter.Statuses) > 0 {
+		statuses := make([]string, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			statuses[i] = string(status)
 		}
-		result = append(result, balance)
+		where = append(where, `"status" = any(`+arg(statuses)+`::text[])`)
 	}
-	if len(result) == 0 {
-		return nil, customerr.NewError(errors.New("no content"), http.StatusNoContent)
+	if !filter.From.IsZero() {
+		where = append(where, `"created_at" >= `+arg(filter.From.UTC())+`::timestamp`)
 	}
-	return result, nil
-}
-
-func (r *BalanceOperationRepository) GetBalanceByUser(ctx context.Context, userID int) (int, int, error) {
-	query := `
-		select 
-			coalesce((select sum("sum") from "balance_operation" where "user_id" = $1 and "deleted_at" is null and status = 'PROCESSED'), 0) as "current",
-			coalesce((select sum("sum") from "balance_operation" where "user_id" = $1 and "deleted_at" is null and type = 'WITHDRAW' and status = 'PROCESSED'), 0) as "withdrawn"
-	`
-	row := r.pool.QueryRow(ctx, query, userID)
-	var current int
-	var withdrawn int
-	err := row.Scan(&current, &withdrawn)
-	if err != nil {
-		return 0, 0, err
+	if !filter.To.IsZero() {
+		where = append(where, `"created_at" < `+arg(filter.To.UTC())+`::timestamp`)
+	}
+	direction, compare := "desc", "<"
+	if filter.Ascending {
+		direction, compare = "asc", ">"
+	}
+	if filter.After != nil {
+		where = append(where, `("created_at", "id") `+compare+` (`+arg(filter.After.CreatedAt.UTC())+`::timestamp, `+arg(filter.After.ID)+`::integer)`)
 	}
-	return current, withdrawn, nil
-}
-
-func (r *BalanceOperationRepository) FindWithdrawsByUser(ctx context.Context, userID int) ([]*entity.BalanceOperation, error) {
 	query := `
-		select "id", "order", "sum", "created_at" from "balance_operation" where "user_id" = $1 and "deleted_at" is null and type = 'WITHDRAW' and status = 'PROCESSED'
-	`
-	rows, err := r.pool.Query(ctx, query, userID)
+		select "id", "order", "status", "type", "sum", "user_id", "created_at" from "balance_operation"
+		where ` + strings.Join(where, " and ") + `
+		order by "created_at" ` + direction + `, "id" ` + direction + `
+		limit ` + arg(filter.Limit)
+	rows, err := r.pool.Query(ctx, query, args...)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, customerr.NewError(pgx.ErrNoRows, http.StatusNoContent)
-		}
 		return nil, customerr.NewError(err, http.StatusInternalServerError)
 	}
+	defer rows.Close()
 	result := make([]*entity.BalanceOperation, 0)
 	for rows.Next() {
 		balance := &entity.BalanceOperation{}
-		err = rows.Scan(&balance.ID, &balance.Order, &balance.Sum, &balance.CreatedAt)
+		var status, operationType string
+		err = rows.Scan(&balance.ID, &balance.Order, &status, &operationType, &balance.Sum, &balance.UserID, &balance.CreatedAt)
 		if err != nil {
 			return nil, customerr.NewError(err, http.StatusInternalServerError)
 		}
+		balance.Status = entity.ProcessStatus(status)
+		balance.Type = entity.BalanceOperationType(operationType)
 		result = append(result, balance)
 	}
+	if rows.Err() != nil {
+		return nil, customerr.NewError(rows.Err(), http.StatusInternalServerError)
+	}
 	if len(result) == 0 {
 		return nil, customerr.NewError(errors.New("no content"), http.StatusNoContent)
 	}
@@ -109,69 +115,92 @@ func (r *BalanceOperationRepository) SaveWithdraw(ctx context.Context, balanceOp
 	if err != nil {
 		return err
 	}
-	query := `
-	select
-		coalesce((select sum("sum") from "balance_operation" where "user_id" = $1 and "deleted_at" is null and status = 'PROCESSED'), 0) as "current"
-	`
-	row := tx.QueryRow(ctx, query, balanceOperation.UserID)
-	var current int
-	err = row.Scan(&current)
+	defer tx.Rollback(ctx)
+	balance, err := lockUserBalanceWithTx(ctx, tx, balanceOperation.UserID)
 	if err != nil {
-		return customerr.NewError(err, http.StatusInternalServerError)
+		return err
 	}
-	if balanceOperation.Sum*(-1) > current {
-		return customerr.NewError(errors.New("current balance < withdraw"), http.StatusPaymentRequired)
+	if balanceOperation.Sum.Neg() > balance.Current {
+		return customerr.NewCodedError(errors.New("current balance < withdraw"), http.StatusPaymentRequired, customerr.CodeInsufficientFunds)
 	}
 	err = r.saveWithTx(ctx, tx, balanceOperation)
 	if err != nil {
 		return err
 	}
-	tx.Commit(ctx)
+	err = appendPostingWithTx(ctx, tx, entity.NewWithdrawPosting(balanceOperation))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.ConstraintName == "user_balance_current_check" {
+			return customerr.NewCodedError(errors.New("current balance < withdraw"), http.StatusPaymentRequired, customerr.CodeInsufficientFunds)
+		}
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
 	return nil
 }
 
 func (*BalanceOperationRepository) saveWithTx(ctx context.Context, tx pgx.Tx, balanceOperation *entity.BalanceOperation) error {
 	query := `
 		with ins as (
-			insert into "balance_operation" ("order", "status", "type", "user_id", "sum") values($1, $2, $3, $4, $5) on conflict("order") where "deleted_at" is null do nothing returning id
+			insert into "balance_operation" ("order", "status", "type", "user_id", "sum") values($1, $2, $3, $4, $5) on conflict do nothing returning id
 		) select 
+			coalesce((select ins.id from ins), 0) as id,
 			case when (select ins.id from ins) is null
-			then (select "user_id" from "balance_operation" where "order" = $1 and "deleted_at" is null)
+			then (select "user_id" from "balance_operation" where "order" = $1 and "type" = $3 and "deleted_at" is null)
 			else 0 end as userID
 	`
-	row := tx.QueryRow(ctx, query, balanceOperation.Order, string(balanceOperation.Status), string(balanceOperation.Type), balanceOperation.UserID, balanceOperation.Sum)
-	var userID int
-	err := row.Scan(&userID)
+	row := tx.QueryRow(ctx, query, balanceOperation.Order, string(balanceOperation.Status), string(balanceOperation.Type), balanceOperation.UserID, balanceOperation.Sum.Cents())
+	var id, userID int
+	err := row.Scan(&id, &userID)
 	if err != nil {
 		return customerr.NewError(err, http.StatusInternalServerError)
 	}
 	if userID != 0 {
 		if userID == balanceOperation.UserID {
-			return customerr.NewError(errors.New("order is already saved"), http.StatusOK)
+			return customerr.NewCodedError(errors.New("order is already saved"), http.StatusOK, customerr.CodeOrderAlreadyUploaded)
 		}
-		return customerr.NewError(errors.New("order is already saved for another user"), http.StatusConflict)
+		return customerr.NewCodedError(errors.New("order is already saved for another user"), http.StatusConflict, customerr.CodeOrderUploadedByAnotherUser)
 	}
+	balanceOperation.ID = id
 	return nil
 }
 
-func (r *BalanceOperationRepository) FindOrdersToProcess(ctx context.Context) ([]*entity.BalanceOperation, error) {
+// FindOrdersToProcess захватывает в аренду не больше limit заказов: новые и те, чья аренда истекла.
+// Строки, уже захваченные другим экземпляром, пропускаются за счёт SKIP LOCKED. Заказы из held этот экземпляр
+// ещё держит в очереди: их аренда могла истечь за время паузы после 429, но захватывать их повторно нельзя.
+func (r *BalanceOperationRepository) FindOrdersToProcess(ctx context.Context, owner string, limit int, lease time.Duration, held []int) ([]*entity.BalanceOperation, error) {
+	if held == nil {
+		held = []int{}
+	}
 	query := `
-		with upd as (
-			update "balance_operation"
-			set status = 'PROCESSING'
+		with claim as (
+			select "id" from "balance_operation"
 			where "deleted_at" is null
 			and type = 'ACCRUAL'
-			and status = 'NEW' returning "id", "order"
-		) select "id", "order" from upd
+			and (status = 'NEW' or (status = 'PROCESSING' and ("lease_expires_at" is null or "lease_expires_at" < now())))
+			and not ("id" = any($4::integer[]))
+			order by "id"
+			limit $2
+			for update skip locked
+		)
+		update "balance_operation" b
+		set status = 'PROCESSING', "lease_owner" = $1, "lease_expires_at" = now() + $3::interval
+		from claim
+		where b."id" = claim."id"
+		returning b."id", b."order", b."user_id", b."lease_owner", b."lease_expires_at"
 	`
-	rows, err := r.pool.Query(ctx, query)
+	rows, err := r.pool.Query(ctx, query, owner, limit, lease, held)
 	if err != nil {
 		return nil, customerr.NewError(err, http.StatusInternalServerError)
 	}
+	defer rows.Close()
 	result := make([]*entity.BalanceOperation, 0)
 	for rows.Next() {
 		balance := &entity.BalanceOperation{}
-		err = rows.Scan(&balance.ID, &balance.Order)
+		err = rows.Scan(&balance.ID, &balance.Order, &balance.UserID, &balance.LeaseOwner, &balance.LeaseExpiresAt)
 		if err != nil {
 			return nil, customerr.NewError(err, http.StatusInternalServerError)
 		}
@@ -180,22 +209,79 @@ func (r *BalanceOperationRepository) FindOrdersToProcess(ctx context.Context) ([
 	return result, nil
 }
 
+// UpdateOrders сохраняет результаты расчёта начислений и снимает аренду. Окончательные статусы не перезаписываются,
+// результат отбрасывается, если аренду уже перехватил другой экземпляр, а начисление по обработанному
+// заказу проводится через книгу проводок в той же транзакции.
 func (r *BalanceOperationRepository) UpdateOrders(ctx context.Context, balanceOperations []*entity.BalanceOperation) error {
 	query := `
-		update "balance_operation"
-		set 
-			status = $2,
-			sum = $3
-		where id = $1
+		with upd as (
+			update "balance_operation"
+			set 
+				status = $2,
+				sum = $3,
+				"lease_owner" = null,
+				"lease_expires_at" = null
+			where id = $1
+			and status not in ('PROCESSED', 'INVALID')
+			and "lease_owner" is not distinct from nullif($6, '')
+			returning "id", "user_id", status, "sum"
+		), post as (
+			insert into "ledger_posting" ("debit_account", "credit_account", "amount", "user_id", "balance_operation_id")
+			select $4, $5, "sum", "user_id", "id" from upd where status = 'PROCESSED' and "sum" > 0
+			returning "user_id", "amount"
+		)
+		insert into "user_balance" ("user_id", "current")
+		select "user_id", "amount" from post
+		on conflict ("user_id") do update set
+			"current" = "user_balance"."current" + excluded."current",
+			"updated_at" = now()
 	`
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
 	batch := &pgx.Batch{}
 	for _, el := range balanceOperations {
-		batch.Queue(query, el.ID, el.Status, el.Sum)
+		posting := entity.NewAccrualPosting(el)
+		batch.Queue(query, el.ID, string(el.Status), el.Sum.Cents(), string(posting.DebitAccount), string(posting.CreditAccount), el.LeaseOwner)
 	}
-	results := r.pool.SendBatch(ctx, batch)
-	_, err := results.Exec()
-	if err != nil {
+	results := tx.SendBatch(ctx, batch)
+	for range balanceOperations {
+		if _, err = results.Exec(); err != nil {
+			results.Close()
+			return err
+		}
+	}
+	if err = results.Close(); err != nil {
 		return err
 	}
-	return nil
+	return tx.Commit(ctx)
+}
+
+func (r *BalanceOperationRepository) CountOrdersByStatus(ctx context.Context) (map[entity.ProcessStatus]int, error) {
+	query := `
+		select status, count(*) from "balance_operation"
+		where "deleted_at" is null and type = 'ACCRUAL'
+		group by status
+	`
+	rows, err := r.pool.Query(ctx, query)
+	if err != nil {
+		return nil, customerr.NewError(err, http.StatusInternalServerError)
+	}
+	defer rows.Close()
+	result := make(map[entity.ProcessStatus]int)
+	for rows.Next() {
+		var status entity.ProcessStatus
+		var count int
+		err = rows.Scan(&status, &count)
+		if err != nil {
+			return nil, customerr.NewError(err, http.StatusInternalServerError)
+		}
+		result[status] = count
+	}
+	if rows.Err() != nil {
+		return nil, customerr.NewError(rows.Err(), http.StatusInternalServerError)
+	}
+	return result, nil
 }
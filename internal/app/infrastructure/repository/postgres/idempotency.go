@@ -0,0 +1,123 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type IdempotencyRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewIdempotencyRepository(ctx context.Context, config *config.Config, pool *pgxpool.Pool) (*IdempotencyRepository, error) {
+	return &IdempotencyRepository{pool: pool}, nil
+}
+
+// reserveAttempts сколько раз Reserve пробует занять ключ, если запись пропала между вставкой и чтением
+const reserveAttempts = 3
+
+// Reserve занимает ключ под новый запрос. Если ключ уже занят и не истёк,
+// возвращается сохранённая запись и false. Запрос, не завершившийся за lockTTL, считается брошенным.
+func (r *IdempotencyRepository) Reserve(ctx context.Context, record *entity.IdempotencyRecord, ttl time.Duration, lockTTL time.Duration) (*entity.IdempotencyRecord, bool, error) {
+	for attempt := 0; attempt < reserveAttempts; attempt++ {
+		existing, reserved, err := r.reserve(ctx, record, ttl, lockTTL)
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return existing, reserved, err
+		}
+	}
+	return nil, false, customerr.NewCodedError(errors.New("request with this idempotency key is in progress"), http.StatusConflict, customerr.CodeIdempotencyRequestInFlight)
+}
+
+// reserve возвращает pgx.ErrNoRows, если конфликтующую запись удалили до того, как её удалось прочитать
+func (r *IdempotencyRepository) reserve(ctx context.Context, record *entity.IdempotencyRecord, ttl time.Duration, lockTTL time.Duration) (*entity.IdempotencyRecord, bool, error) {
+	query := `
+		insert into "idempotency_key" ("user_id", "key", "fingerprint", "expires_at") values ($1, $2, $3, now() + $4::interval)
+		on conflict ("user_id", "key") do update set
+			"fingerprint" = excluded."fingerprint",
+			"status_code" = 0,
+			"content_type" = '',
+			"body" = null,
+			"created_at" = now(),
+			"expires_at" = excluded."expires_at"
+		where "idempotency_key"."expires_at" < now()
+			or ("idempotency_key"."status_code" = 0 and "idempotency_key"."created_at" < now() - $5::interval)
+		returning "created_at", "expires_at"
+	`
+	err := r.pool.QueryRow(ctx, query, record.UserID, record.Key, record.Fingerprint, ttl, lockTTL).Scan(&record.CreatedAt, &record.ExpiresAt)
+	if err == nil {
+		return record, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, customerr.NewError(err, http.StatusInternalServerError)
+	}
+	query = `
+		select "fingerprint", "status_code", "content_type", "body", "created_at", "expires_at"
+		from "idempotency_key" where "user_id" = $1 and "key" = $2
+	`
+	existing := &entity.IdempotencyRecord{UserID: record.UserID, Key: record.Key}
+	err = r.pool.QueryRow(ctx, query, record.UserID, record.Key).Scan(
+		&existing.Fingerprint,
+		&existing.StatusCode,
+		&existing.ContentType,
+		&existing.Body,
+		&existing.CreatedAt,
+		&existing.ExpiresAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, err
+	}
+	if err != nil {
+		return nil, false, customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return existing, false, nil
+}
+
+// Complete сохраняет ответ, только если ключ всё ещё занят этим запросом: брошенный запрос,
+// чей ключ после lockTTL занял повтор, не перезапишет чужую запись
+func (r *IdempotencyRepository) Complete(ctx context.Context, record *entity.IdempotencyRecord) error {
+	query := `
+		update "idempotency_key" set "status_code" = $3, "content_type" = $4, "body" = $5
+		where "user_id" = $1 and "key" = $2 and "status_code" = 0 and "fingerprint" = $6 and "created_at" = $7
+	`
+	tag, err := r.pool.Exec(ctx, query, record.UserID, record.Key, record.StatusCode, record.ContentType, record.Body, record.Fingerprint, record.CreatedAt)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	if tag.RowsAffected() == 0 {
+		return customerr.NewCodedError(errors.New("idempotency key is no longer held by this request"), http.StatusConflict, customerr.CodeIdempotencyRequestInFlight)
+	}
+	return nil
+}
+
+// Release освобождает ключ, только если он всё ещё занят этим запросом
+func (r *IdempotencyRepository) Release(ctx context.Context, record *entity.IdempotencyRecord) error {
+	query := `
+		delete from "idempotency_key"
+		where "user_id" = $1 and "key" = $2 and "status_code" = 0 and "fingerprint" = $3 and "created_at" = $4
+	`
+	_, err := r.pool.Exec(ctx, query, record.UserID, record.Key, record.Fingerprint, record.CreatedAt)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func (r *IdempotencyRepository) DeleteExpired(ctx context.Context) error {
+	query := `
+		delete from "idempotency_key" where "expires_at" < now()
+	`
+	_, err := r.pool.Exec(ctx, query)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
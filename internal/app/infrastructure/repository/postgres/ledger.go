@@ -0,0 +1,190 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type LedgerRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewLedgerRepository(ctx context.Context, config *config.Config, pool *pgxpool.Pool) (*LedgerRepository, error) {
+	return &LedgerRepository{pool: pool}, nil
+}
+
+// appendPostingWithTx добавляет проводку и в той же транзакции обновляет материализованный баланс
+func appendPostingWithTx(ctx context.Context, tx pgx.Tx, posting *entity.Posting) error {
+	query := `
+		insert into "ledger_posting" ("debit_account", "credit_account", "amount", "user_id", "balance_operation_id")
+		values ($1, $2, $3, $4, nullif($5, 0)) returning "id", "created_at"
+	`
+	err := tx.QueryRow(ctx, query,
+		string(posting.DebitAccount),
+		string(posting.CreditAccount),
+		posting.Amount.Cents(),
+		posting.UserID,
+		posting.BalanceOperationID,
+	).Scan(&posting.ID, &posting.CreatedAt)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	query = `
+		insert into "user_balance" ("user_id", "current", "withdrawn") values ($1, $2, $3)
+		on conflict ("user_id") do update set
+			"current" = "user_balance"."current" + excluded."current",
+			"withdrawn" = "user_balance"."withdrawn" + excluded."withdrawn",
+			"updated_at" = now()
+	`
+	_, err = tx.Exec(ctx, query, posting.UserID, posting.CurrentDelta().Cents(), posting.WithdrawnDelta().Cents())
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func getUserBalance(ctx context.Context, q pgxQuerier, userID int) (*entity.UserBalance, error) {
+	query := `
+		select "current", "withdrawn" from "user_balance" where "user_id" = $1
+	`
+	balance := &entity.UserBalance{UserID: userID}
+	err := q.QueryRow(ctx, query, userID).Scan(&balance.Current, &balance.Withdrawn)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return balance, nil
+}
+
+// lockUserBalanceWithTx блокирует строку баланса пользователя до конца транзакции,
+// поэтому параллельные списания одного пользователя проверяют остаток строго по очереди
+func lockUserBalanceWithTx(ctx context.Context, tx pgx.Tx, userID int) (*entity.UserBalance, error) {
+	query := `
+		insert into "user_balance" ("user_id") values ($1) on conflict ("user_id") do nothing
+	`
+	_, err := tx.Exec(ctx, query, userID)
+	if err != nil {
+		return nil, customerr.NewError(err, http.StatusInternalServerError)
+	}
+	query = `
+		select "current", "withdrawn" from "user_balance" where "user_id" = $1 for update
+	`
+	balance := &entity.UserBalance{UserID: userID}
+	err = tx.QueryRow(ctx, query, userID).Scan(&balance.Current, &balance.Withdrawn)
+	if err != nil {
+		return nil, customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return balance, nil
+}
+
+type pgxQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func (r *LedgerRepository) GetUserBalance(ctx context.Context, userID int) (*entity.UserBalance, error) {
+	return getUserBalance(ctx, r.pool, userID)
+}
+
+func (r *LedgerRepository) FindPostingsByUser(ctx context.Context, userID int) ([]*entity.Posting, error) {
+	query := `
+		select "id", "debit_account", "credit_account", "amount", "user_id", coalesce("balance_operation_id", 0), "created_at"
+		from "ledger_posting" where "user_id" = $1 order by "id"
+	`
+	rows, err := r.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, customerr.NewError(err, http.StatusInternalServerError)
+	}
+	defer rows.Close()
+	result := make([]*entity.Posting, 0)
+	for rows.Next() {
+		posting := &entity.Posting{}
+		var debit, credit string
+		err = rows.Scan(&posting.ID, &debit, &credit, &posting.Amount, &posting.UserID, &posting.BalanceOperationID, &posting.CreatedAt)
+		if err != nil {
+			return nil, customerr.NewError(err, http.StatusInternalServerError)
+		}
+		posting.DebitAccount = entity.LedgerAccount(debit)
+		posting.CreditAccount = entity.LedgerAccount(credit)
+		result = append(result, posting)
+	}
+	if rows.Err() != nil {
+		return nil, customerr.NewError(rows.Err(), http.StatusInternalServerError)
+	}
+	return result, nil
+}
+
+func (r *LedgerRepository) CheckInvariants(ctx context.Context) ([]*entity.LedgerViolation, error) {
+	query := `
+		with "wallet" as (
+			select "user_id",
+				sum(case when "credit_account" = $1::text || "user_id" then "amount" else 0 end)
+					- sum(case when "debit_account" = $1::text || "user_id" then "amount" else 0 end) as "current",
+				sum(case when "debit_account" = $1::text || "user_id" and "credit_account" = $2 then "amount" else 0 end) as "withdrawn"
+			from "ledger_posting" group by "user_id"
+		)
+		select
+			coalesce(b."user_id", w."user_id"),
+			coalesce(w."current", 0), coalesce(b."current", 0),
+			coalesce(w."withdrawn", 0), coalesce(b."withdrawn", 0)
+		from "user_balance" b full join "wallet" w on w."user_id" = b."user_id"
+		where coalesce(b."current", 0) <> coalesce(w."current", 0)
+			or coalesce(b."withdrawn", 0) <> coalesce(w."withdrawn", 0)
+			or coalesce(w."current", 0) < 0
+	`
+	rows, err := r.pool.Query(ctx, query, entity.UserWalletPrefix, string(entity.WithdrawalSink))
+	if err != nil {
+		return nil, customerr.NewError(err, http.StatusInternalServerError)
+	}
+	result := make([]*entity.LedgerViolation, 0)
+	for rows.Next() {
+		var userID int
+		var ledgerCurrent, current, ledgerWithdrawn, withdrawn entity.Money
+		err = rows.Scan(&userID, &ledgerCurrent, &current, &ledgerWithdrawn, &withdrawn)
+		if err != nil {
+			rows.Close()
+			return nil, customerr.NewError(err, http.StatusInternalServerError)
+		}
+		if current != ledgerCurrent {
+			result = append(result, &entity.LedgerViolation{Invariant: entity.CurrentMismatch, UserID: userID, Expected: ledgerCurrent, Actual: current})
+		}
+		if withdrawn != ledgerWithdrawn {
+			result = append(result, &entity.LedgerViolation{Invariant: entity.WithdrawnMismatch, UserID: userID, Expected: ledgerWithdrawn, Actual: withdrawn})
+		}
+		if ledgerCurrent.IsNegative() {
+			result = append(result, &entity.LedgerViolation{Invariant: entity.NegativeBalance, UserID: userID, Actual: ledgerCurrent})
+		}
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return nil, customerr.NewError(rows.Err(), http.StatusInternalServerError)
+	}
+	query = `
+		select o."user_id", o."id", o."sum" from "balance_operation" o
+		left join "ledger_posting" p on p."balance_operation_id" = o."id"
+		where o."deleted_at" is null and o.status = 'PROCESSED' and o."sum" <> 0 and p."id" is null
+	`
+	rows, err = r.pool.Query(ctx, query)
+	if err != nil {
+		return nil, customerr.NewError(err, http.StatusInternalServerError)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		violation := &entity.LedgerViolation{Invariant: entity.MissingPosting}
+		err = rows.Scan(&violation.UserID, &violation.BalanceOperationID, &violation.Expected)
+		if err != nil {
+			return nil, customerr.NewError(err, http.StatusInternalServerError)
+		}
+		result = append(result, violation)
+	}
+	if rows.Err() != nil {
+		return nil, customerr.NewError(rows.Err(), http.StatusInternalServerError)
+	}
+	return result, nil
+}
@@ -0,0 +1,55 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PasswordResetRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewPasswordResetRepository(ctx context.Context, config *config.Config, pool *pgxpool.Pool) (*PasswordResetRepository, error) {
+	return &PasswordResetRepository{pool: pool}, nil
+}
+
+func (r *PasswordResetRepository) Save(ctx context.Context, token *entity.PasswordResetToken) error {
+	query := `
+		insert into "password_reset_token" ("user_id", "token_hash", "expires_at") values ($1, $2, $3)
+		returning "id", "created_at"
+	`
+	err := r.pool.QueryRow(ctx, query, token.UserID, token.TokenHash, token.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// Consume помечает токен использованным и возвращает id пользователя.
+// Использованный, просроченный токен или токен удалённого пользователя не принимается.
+func (r *PasswordResetRepository) Consume(ctx context.Context, tokenHash string) (int, error) {
+	query := `
+		update "password_reset_token" t set "used_at" = now()
+		from "user" u
+		where t."token_hash" = $1 and t."used_at" is null and t."expires_at" > now()
+			and u."id" = t."user_id" and u."deleted_at" is null
+		returning t."user_id"
+	`
+	var userID int
+	err := r.pool.QueryRow(ctx, query, tokenHash).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, customerr.NewCodedError(errors.New("password reset token is invalid"), http.StatusBadRequest, customerr.CodePasswordResetTokenInvalid)
+	}
+	if err != nil {
+		return 0, customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return userID, nil
+}
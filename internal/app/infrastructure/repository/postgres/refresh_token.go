@@ -0,0 +1,138 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type RefreshTokenRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewRefreshTokenRepository(ctx context.Context, config *config.Config, pool *pgxpool.Pool) (*RefreshTokenRepository, error) {
+	return &RefreshTokenRepository{pool: pool}, nil
+}
+
+func (r *RefreshTokenRepository) Save(ctx context.Context, token *entity.RefreshToken) error {
+	query := `
+		insert into "refresh_token" ("user_id", "family_id", "token_hash", "expires_at") values ($1, $2, $3, $4)
+		returning "id", "created_at"
+	`
+	err := r.pool.QueryRow(ctx, query, token.UserID, token.FamilyID, token.TokenHash, token.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// Rotate отзывает предъявленный токен и сохраняет next в том же семействе.
+// Повторное предъявление уже отозванного токена означает, что он украден,
+// поэтому отзывается всё семейство.
+func (r *RefreshTokenRepository) Rotate(ctx context.Context, tokenHash string, next *entity.RefreshToken) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	defer tx.Rollback(ctx)
+	query := `
+		select "id", "user_id", "family_id", "revoked_at" is not null, "expires_at" <= now()
+		from "refresh_token" where "token_hash" = $1 for update
+	`
+	var id int
+	var revoked, expired bool
+	err = tx.QueryRow(ctx, query, tokenHash).Scan(&id, &next.UserID, &next.FamilyID, &revoked, &expired)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return customerr.NewCodedError(errors.New("refresh token not found"), http.StatusUnauthorized, customerr.CodeRefreshTokenInvalid)
+	}
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	if revoked {
+		if err = revokeFamilyWithTx(ctx, tx, next.FamilyID); err != nil {
+			return err
+		}
+		if err = tx.Commit(ctx); err != nil {
+			return customerr.NewError(err, http.StatusInternalServerError)
+		}
+		return customerr.NewCodedError(errors.New("refresh token reuse"), http.StatusUnauthorized, customerr.CodeRefreshTokenReused)
+	}
+	if expired {
+		return customerr.NewCodedError(errors.New("refresh token expired"), http.StatusUnauthorized, customerr.CodeRefreshTokenInvalid)
+	}
+	_, err = tx.Exec(ctx, `update "refresh_token" set "revoked_at" = now() where "id" = $1`, id)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	query = `
+		insert into "refresh_token" ("user_id", "family_id", "token_hash", "expires_at") values ($1, $2, $3, $4)
+		returning "id", "created_at"
+	`
+	err = tx.QueryRow(ctx, query, next.UserID, next.FamilyID, next.TokenHash, next.ExpiresAt).Scan(&next.ID, &next.CreatedAt)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func (r *RefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	defer tx.Rollback(ctx)
+	if err = revokeFamilyWithTx(ctx, tx, familyID); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// RevokeUser завершает все сессии пользователя, кроме exceptFamilyID
+func (r *RefreshTokenRepository) RevokeUser(ctx context.Context, userID int, exceptFamilyID string) error {
+	query := `
+		update "refresh_token" set "revoked_at" = now()
+		where "user_id" = $1 and "family_id" <> $2 and "revoked_at" is null
+	`
+	_, err := r.pool.Exec(ctx, query, userID, exceptFamilyID)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// IsFamilyActive сообщает, есть ли в семействе действующий токен, то есть не завершена ли сессия
+func (r *RefreshTokenRepository) IsFamilyActive(ctx context.Context, familyID string) (bool, error) {
+	query := `
+		select exists(select * from "refresh_token" where "family_id" = $1 and "revoked_at" is null and "expires_at" > now())
+	`
+	var active bool
+	err := r.pool.QueryRow(ctx, query, familyID).Scan(&active)
+	if err != nil {
+		return false, customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return active, nil
+}
+
+func revokeFamilyWithTx(ctx context.Context, tx pgx.Tx, familyID string) error {
+	query := `
+		update "refresh_token" set "revoked_at" = now() where "family_id" = $1 and "revoked_at" is null
+	`
+	_, err := tx.Exec(ctx, query, familyID)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
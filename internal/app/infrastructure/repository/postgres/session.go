@@ -0,0 +1,92 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type SessionRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewSessionRepository(ctx context.Context, config *config.Config, pool *pgxpool.Pool) (*SessionRepository, error) {
+	return &SessionRepository{pool: pool}, nil
+}
+
+func (r *SessionRepository) Save(ctx context.Context, session *entity.Session) error {
+	query := `
+		insert into "session" ("id", "user_id", "ip", "user_agent", "device") values ($1, $2, $3, $4, $5)
+		returning "created_at", "last_seen_at"
+	`
+	err := r.pool.QueryRow(ctx, query, session.ID, session.UserID, session.IP, session.UserAgent, session.Device).Scan(&session.CreatedAt, &session.LastSeenAt)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// FindByUser возвращает незавершённые сессии пользователя, последние активные первыми
+func (r *SessionRepository) FindByUser(ctx context.Context, userID int) ([]*entity.Session, error) {
+	query := `
+		select s."id", s."user_id", s."ip", s."user_agent", s."device", s."created_at", s."last_seen_at"
+		from "session" s
+		where s."user_id" = $1 and exists(
+			select * from "refresh_token" t where t."family_id" = s."id" and t."revoked_at" is null and t."expires_at" > now()
+		)
+		order by s."last_seen_at" desc
+	`
+	rows, err := r.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, customerr.NewError(err, http.StatusInternalServerError)
+	}
+	defer rows.Close()
+	result := make([]*entity.Session, 0)
+	for rows.Next() {
+		session := &entity.Session{}
+		err = rows.Scan(&session.ID, &session.UserID, &session.IP, &session.UserAgent, &session.Device, &session.CreatedAt, &session.LastSeenAt)
+		if err != nil {
+			return nil, customerr.NewError(err, http.StatusInternalServerError)
+		}
+		result = append(result, session)
+	}
+	if rows.Err() != nil {
+		return nil, customerr.NewError(rows.Err(), http.StatusInternalServerError)
+	}
+	return result, nil
+}
+
+// Touch обновляет время и адрес последнего запроса, если прошлое обновление было раньше lastSeenBefore
+func (r *SessionRepository) Touch(ctx context.Context, ID string, ip string, lastSeenBefore time.Time) error {
+	query := `
+		update "session" set "last_seen_at" = now(), "ip" = $2 where "id" = $1 and "last_seen_at" < $3
+	`
+	_, err := r.pool.Exec(ctx, query, ID, ip, lastSeenBefore)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// End завершает сессию пользователя, отзывая refresh-токены её семейства
+func (r *SessionRepository) End(ctx context.Context, userID int, ID string) error {
+	query := `
+		update "refresh_token" set "revoked_at" = now()
+		where "family_id" = $1 and "user_id" = $2 and "revoked_at" is null and "expires_at" > now()
+	`
+	tag, err := r.pool.Exec(ctx, query, ID, userID)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	if tag.RowsAffected() == 0 {
+		return customerr.NewCodedError(errors.New("session not found"), http.StatusNotFound, customerr.CodeSessionNotFound)
+	}
+	return nil
+}
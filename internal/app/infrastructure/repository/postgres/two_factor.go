@@ -0,0 +1,117 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type TwoFactorRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewTwoFactorRepository(ctx context.Context, config *config.Config, pool *pgxpool.Pool) (*TwoFactorRepository, error) {
+	return &TwoFactorRepository{pool: pool}, nil
+}
+
+// Save заменяет неподтверждённую настройку; подтверждённую сначала нужно отключить
+func (r *TwoFactorRepository) Save(ctx context.Context, f *entity.TwoFactor) error {
+	query := `
+		insert into "two_factor" ("user_id", "secret") values ($1, $2)
+		on conflict ("user_id") do update set "secret" = excluded."secret", "created_at" = now()
+		where "two_factor"."confirmed_at" is null
+		returning "created_at"
+	`
+	err := r.pool.QueryRow(ctx, query, f.UserID, f.Secret).Scan(&f.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return customerr.NewCodedError(errors.New("two-factor authentication is already enabled"), http.StatusConflict, customerr.CodeTwoFactorAlreadyEnabled)
+	}
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func (r *TwoFactorRepository) FindByUser(ctx context.Context, userID int) (*entity.TwoFactor, error) {
+	query := `
+		select "user_id", "secret", "recovery_codes", "last_step", "created_at", "confirmed_at"
+		from "two_factor" where "user_id" = $1
+	`
+	f := &entity.TwoFactor{}
+	var confirmedAt *time.Time
+	err := r.pool.QueryRow(ctx, query, userID).Scan(&f.UserID, &f.Secret, &f.RecoveryCodes, &f.LastStep, &f.CreatedAt, &confirmedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, customerr.NewCodedError(errors.New("two-factor authentication is not set up"), http.StatusNotFound, customerr.CodeTwoFactorNotSetUp)
+	}
+	if err != nil {
+		return nil, customerr.NewError(err, http.StatusInternalServerError)
+	}
+	if confirmedAt != nil {
+		f.ConfirmedAt = *confirmedAt
+	}
+	return f, nil
+}
+
+func (r *TwoFactorRepository) Confirm(ctx context.Context, userID int, step int64, recoveryCodes []string) error {
+	query := `
+		update "two_factor" set "confirmed_at" = now(), "last_step" = $2, "recovery_codes" = $3
+		where "user_id" = $1 and "confirmed_at" is null
+	`
+	tag, err := r.pool.Exec(ctx, query, userID, step, recoveryCodes)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	if tag.RowsAffected() == 0 {
+		return customerr.NewCodedError(errors.New("two-factor authentication is not set up or already enabled"), http.StatusConflict, customerr.CodeTwoFactorAlreadyEnabled)
+	}
+	return nil
+}
+
+// UseStep принимает шаг TOTP только если он новее последнего принятого, условие проверяется атомарно
+func (r *TwoFactorRepository) UseStep(ctx context.Context, userID int, step int64) error {
+	query := `
+		update "two_factor" set "last_step" = $2 where "user_id" = $1 and "last_step" < $2
+	`
+	tag, err := r.pool.Exec(ctx, query, userID, step)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	if tag.RowsAffected() == 0 {
+		return customerr.NewCodedError(errors.New("two-factor code is already used"), http.StatusUnauthorized, customerr.CodeTwoFactorCodeInvalid)
+	}
+	return nil
+}
+
+func (r *TwoFactorRepository) UseRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	query := `
+		update "two_factor" set "recovery_codes" = array_remove("recovery_codes", $2)
+		where "user_id" = $1 and $2 = any("recovery_codes")
+	`
+	tag, err := r.pool.Exec(ctx, query, userID, codeHash)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	if tag.RowsAffected() == 0 {
+		return customerr.NewCodedError(errors.New("recovery code not found"), http.StatusUnauthorized, customerr.CodeTwoFactorCodeInvalid)
+	}
+	return nil
+}
+
+func (r *TwoFactorRepository) Delete(ctx context.Context, userID int) error {
+	tag, err := r.pool.Exec(ctx, `delete from "two_factor" where "user_id" = $1`, userID)
+	if err != nil {
+		return customerr.NewError(err, http.StatusInternalServerError)
+	}
+	if tag.RowsAffected() == 0 {
+		return customerr.NewCodedError(errors.New("two-factor authentication is not set up"), http.StatusNotFound, customerr.CodeTwoFactorNotSetUp)
+	}
+	return nil
+}
@@ -4,12 +4,14 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"time"
 
 	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
 
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -46,9 +48,10 @@ func (r *UserRepository) Save(ctx context.Context, user *entity.User) (int, erro
 	}
 	if id == 0 {
 		tx.Rollback(ctx)
-		return 0, customerr.NewError(
+		return 0, customerr.NewCodedError(
 			errors.New("login conflict"),
 			http.StatusConflict,
+			customerr.CodeLoginTaken,
 		)
 	}
 	err = tx.Commit(ctx)
@@ -63,12 +66,29 @@ func (r *UserRepository) Save(ctx context.Context, user *entity.User) (int, erro
 
 func (r *UserRepository) FindByLogin(ctx context.Context, login string) (*entity.User, error) {
 	query := `
-		select "id", "password" from "user" where "login" = $1 and deleted_at is null
+		select "id", "login", "password", "role", "created_at", "blocked_at" from "user" where "login" = $1 and deleted_at is null
 	`
-	user := &entity.User{}
-	err := r.pool.QueryRow(ctx, query, login).Scan(&user.ID, &user.Password)
+	return r.findUser(ctx, query, login)
+}
+
+func (r *UserRepository) FindByID(ctx context.Context, ID int) (*entity.User, error) {
+	query := `
+		select "id", "login", "password", "role", "created_at", "blocked_at" from "user" where "id" = $1 and deleted_at is null
+	`
+	return r.findUser(ctx, query, ID)
+}
+
+func (r *UserRepository) findUser(ctx context.Context, query string, arg any) (*entity.User, error) {
+	user, err := scanUser(r.pool.QueryRow(ctx, query, arg), true)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, customerr.NewCodedError(
+			errors.New("user not found"),
+			http.StatusUnauthorized,
+			customerr.CodeUserNotFound,
+		)
+	}
 	if err != nil {
-		customerr.NewError(
+		return nil, customerr.NewError(
 			err,
 			http.StatusInternalServerError,
 		)
@@ -76,9 +96,44 @@ func (r *UserRepository) FindByLogin(ctx context.Context, login string) (*entity
 	return user, nil
 }
 
+func (r *UserRepository) UpdatePassword(ctx context.Context, ID int, password string) error {
+	query := `
+		update "user" set "password" = $2 where "id" = $1 and deleted_at is null
+	`
+	return r.updateUser(ctx, query, ID, password)
+}
+
+// Delete удаляет пользователя мягко: логин освобождается для новой регистрации,
+// а операции по балансу остаются в истории
+func (r *UserRepository) Delete(ctx context.Context, ID int) error {
+	query := `
+		update "user" set "deleted_at" = now() where "id" = $1 and deleted_at is null
+	`
+	return r.updateUser(ctx, query, ID)
+}
+
+func (r *UserRepository) updateUser(ctx context.Context, query string, args ...any) error {
+	tag, err := r.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return customerr.NewError(
+			err,
+			http.StatusInternalServerError,
+		)
+	}
+	if tag.RowsAffected() == 0 {
+		return customerr.NewCodedError(
+			errors.New("user not found"),
+			http.StatusUnauthorized,
+			customerr.CodeUserNotFound,
+		)
+	}
+	return nil
+}
+
+// ExistsByID сообщает, может ли пользователь работать с сервисом: он не удалён и не заблокирован
 func (r *UserRepository) ExistsByID(ctx context.Context, ID int) bool {
 	query := `
-		select exists(select * from "user" where "id" = $1 and deleted_at is null) as res
+		select exists(select * from "user" where "id" = $1 and deleted_at is null and blocked_at is null) as res
 	`
 	var res bool
 	err := r.pool.QueryRow(ctx, query, ID).Scan(&res)
@@ -87,3 +142,23 @@ func (r *UserRepository) ExistsByID(ctx context.Context, ID int) bool {
 	}
 	return res
 }
+
+func scanUser(row pgx.Row, withPassword bool) (*entity.User, error) {
+	user := &entity.User{}
+	var role string
+	var blockedAt *time.Time
+	var err error
+	if withPassword {
+		err = row.Scan(&user.ID, &user.Login, &user.Password, &role, &user.CreatedAt, &blockedAt)
+	} else {
+		err = row.Scan(&user.ID, &user.Login, &role, &user.CreatedAt, &blockedAt)
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.Role = entity.Role(role)
+	if blockedAt != nil {
+		user.BlockedAt = *blockedAt
+	}
+	return user, nil
+}
@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/memory"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/postgres"
+)
+
+type RefreshTokenRepository interface {
+	Save(ctx context.Context, token *entity.RefreshToken) error
+	Rotate(ctx context.Context, tokenHash string, next *entity.RefreshToken) error
+	RevokeFamily(ctx context.Context, familyID string) error
+	RevokeUser(ctx context.Context, userID int, exceptFamilyID string) error
+	IsFamilyActive(ctx context.Context, familyID string) (bool, error)
+}
+
+func NewRefreshTokenRepository(ctx context.Context, config *config.Config, storage *Storage) (RefreshTokenRepository, error) {
+	if storage.memory != nil {
+		return memory.NewRefreshTokenRepository(ctx, storage.memory)
+	}
+	return postgres.NewRefreshTokenRepository(ctx, config, storage.Pool)
+}
@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/memory"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/postgres"
+)
+
+type SessionRepository interface {
+	Save(ctx context.Context, session *entity.Session) error
+	FindByUser(ctx context.Context, userID int) ([]*entity.Session, error)
+	Touch(ctx context.Context, ID string, ip string, lastSeenBefore time.Time) error
+	End(ctx context.Context, userID int, ID string) error
+}
+
+func NewSessionRepository(ctx context.Context, config *config.Config, storage *Storage) (SessionRepository, error) {
+	if storage.memory != nil {
+		return memory.NewSessionRepository(ctx, storage.memory)
+	}
+	return postgres.NewSessionRepository(ctx, config, storage.Pool)
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/memory"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/tracing"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Storage хранилище, на котором работают репозитории: пул соединений Postgres или данные в памяти
+type Storage struct {
+	Pool   *pgxpool.Pool
+	memory *memory.Storage
+}
+
+// NewStorage подключается к базе данных по DatabaseURI, а при STORAGE_TYPE=memory создаёт пустое хранилище в памяти
+func NewStorage(ctx context.Context, config *config.Config) (*Storage, error) {
+	if config.UseMemoryStorage() {
+		return NewMemoryStorage(), nil
+	}
+	poolConfig, err := pgxpool.ParseConfig(config.DatabaseURI)
+	if err != nil {
+		return nil, err
+	}
+	poolConfig.ConnConfig.Tracer = tracing.PgxTracer{}
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, err
+	}
+	return NewPostgresStorage(pool), nil
+}
+
+func NewPostgresStorage(pool *pgxpool.Pool) *Storage {
+	return &Storage{Pool: pool}
+}
+
+func NewMemoryStorage() *Storage {
+	return &Storage{memory: memory.NewStorage()}
+}
+
+// Close освобождает соединения с базой данных
+func (s *Storage) Close() {
+	if s.Pool != nil {
+		s.Pool.Close()
+	}
+}
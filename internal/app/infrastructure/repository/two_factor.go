@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/memory"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/postgres"
+)
+
+type TwoFactorRepository interface {
+	Save(ctx context.Context, f *entity.TwoFactor) error
+	FindByUser(ctx context.Context, userID int) (*entity.TwoFactor, error)
+	Confirm(ctx context.Context, userID int, step int64, recoveryCodes []string) error
+	UseStep(ctx context.Context, userID int, step int64) error
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string) error
+	Delete(ctx context.Context, userID int) error
+}
+
+func NewTwoFactorRepository(ctx context.Context, config *config.Config, storage *Storage) (TwoFactorRepository, error) {
+	if storage.memory != nil {
+		return memory.NewTwoFactorRepository(ctx, storage.memory)
+	}
+	return postgres.NewTwoFactorRepository(ctx, config, storage.Pool)
+}
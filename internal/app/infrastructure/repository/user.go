@@ -5,6 +5,7 @@ import (
 
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/memory"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/postgres"
 )
 
@@ -12,8 +13,14 @@ type UserRepository interface {
 	Save(ctx context.Context, user *entity.User) (int, error)
 	FindByLogin(ctx context.Context, login string) (*entity.User, error)
 	ExistsByID(ctx context.Context, ID int) bool
+	FindByID(ctx context.Context, ID int) (*entity.User, error)
+	UpdatePassword(ctx context.Context, ID int, password string) error
+	Delete(ctx context.Context, ID int) error
 }
 
-func NewUserRepository(ctx context.Context, config *config.Config) (UserRepository, error) {
-	return postgres.NewUserRepository(ctx, config, config.Pool)
+func NewUserRepository(ctx context.Context, config *config.Config, storage *Storage) (UserRepository, error) {
+	if storage.memory != nil {
+		return memory.NewUserRepository(ctx, storage.memory)
+	}
+	return postgres.NewUserRepository(ctx, config, storage.Pool)
 }
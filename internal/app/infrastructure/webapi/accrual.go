@@ -1,40 +1,117 @@
 package webapi
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/metrics"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/tracing"
 
 	"github.com/hashicorp/go-retryablehttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	errNoContent           = errors.New("no content")
+	errInternalServerError = errors.New("internal server error")
+	errRegistered          = errors.New("REGISTERED status")
+	errProcessing          = errors.New("PROCESSING status")
 )
 
 type AccrualWebAPI struct {
-	c *config.Config
+	c        *config.Config
+	client   *retryablehttp.Client
+	throttle *throttle
+	metrics  *metrics.Metrics
+}
+
+func NewAccrualWebAPI(config *config.Config, m *metrics.Metrics) *AccrualWebAPI {
+	client := retryablehttp.NewClient()
+	client.CheckRetry = checkRetry
+	throttle := &throttle{}
+	if config.AccrualRateLimit > 0 {
+		throttle.interval = time.Minute / time.Duration(config.AccrualRateLimit)
+	}
+	return &AccrualWebAPI{config, client, throttle, m}
 }
 
-func NewAccrualWebAPI(config *config.Config) *AccrualWebAPI {
-	return &AccrualWebAPI{config}
+// checkRetry не повторяет 429: паузу по Retry-After выдерживает общий throttle
+func checkRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		return false, nil
+	}
+	return retryablehttp.DefaultRetryPolicy(ctx, resp, err)
 }
 
-func (webAPI *AccrualWebAPI) GetAccrualRequest(order string) (*entity.AccrualResponse, error) {
-	req, err := retryablehttp.NewRequest(http.MethodGet, webAPI.c.AcrualSystemAddress+"/api/orders/"+order, nil)
+// GetAccrualRequest запрашивает расчёт начислений по заказу. Пока сервис отвечает 429,
+// запрос повторяется после глобальной паузы, а не возвращает ошибку.
+// В метрики попадает каждый ответ, включая 429; ожидание паузы в задержку не входит.
+func (webAPI *AccrualWebAPI) GetAccrualRequest(ctx context.Context, order string) (*entity.AccrualResponse, error) {
+	for {
+		if err := webAPI.throttle.Wait(ctx); err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		res, err := webAPI.do(ctx, order)
+		if err != nil {
+			webAPI.metrics.ObserveAccrualRequest(metrics.AccrualOutcomeError, time.Since(start))
+			return nil, err
+		}
+		if res.StatusCode != http.StatusTooManyRequests {
+			response, err := readAccrualResponse(res)
+			webAPI.metrics.ObserveAccrualRequest(accrualOutcome(err), time.Since(start))
+			return response, err
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		webAPI.metrics.ObserveAccrualRequest(metrics.AccrualOutcomeRateLimited, time.Since(start))
+		webAPI.throttle.Pause(parseRetryAfter(res.Header.Get("Retry-After")), parseRequestsPerMinute(body))
+	}
+}
+
+// do выполняет запрос (с повторами retryablehttp) в клиентском спане; контекст трассировки
+// уходит в сервис начислений заголовком traceparent
+func (webAPI *AccrualWebAPI) do(ctx context.Context, order string) (*http.Response, error) {
+	url := webAPI.c.AcrualSystemAddress + "/api/orders/" + order
+	ctx, span := tracing.Tracer().Start(ctx, "GET /api/orders/{number}",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPMethod(http.MethodGet), semconv.HTTPURL(url)),
+	)
+	defer span.End()
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
-	retryClient := retryablehttp.NewClient()
-	res, err := retryClient.Do(req)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	res, err := webAPI.client.Do(req)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return nil, err
 	}
+	span.SetAttributes(semconv.HTTPStatusCode(res.StatusCode))
+	if res.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(res.StatusCode))
+	}
+	return res, nil
+}
+
+func readAccrualResponse(res *http.Response) (*entity.AccrualResponse, error) {
 	defer res.Body.Close()
 	if res.StatusCode == http.StatusNoContent {
-		return nil, errors.New("no content")
+		return nil, errNoContent
 	} else if res.StatusCode == http.StatusInternalServerError {
-		return nil, errors.New("internal server error")
+		return nil, errInternalServerError
 	}
 	data, err := io.ReadAll(io.Reader(res.Body))
 	if err != nil {
@@ -46,10 +123,26 @@ func (webAPI *AccrualWebAPI) GetAccrualRequest(order string) (*entity.AccrualRes
 		return nil, err
 	}
 	if response.Status == "REGISTERED" {
-		return nil, errors.New("REGISTERED status")
+		return nil, errRegistered
 	}
 	if response.Status == "PROCESSING" {
-		return nil, errors.New("PROCESSING status")
+		return nil, errProcessing
 	}
 	return response, nil
 }
+
+// accrualOutcome исход запроса для метрик по результату readAccrualResponse
+func accrualOutcome(err error) string {
+	switch {
+	case err == nil:
+		return metrics.AccrualOutcomeOK
+	case errors.Is(err, errNoContent):
+		return metrics.AccrualOutcomeNotFound
+	case errors.Is(err, errRegistered), errors.Is(err, errProcessing):
+		return metrics.AccrualOutcomePending
+	case errors.Is(err, errInternalServerError):
+		return metrics.AccrualOutcomeServerError
+	default:
+		return metrics.AccrualOutcomeError
+	}
+}
@@ -0,0 +1,109 @@
+package webapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/metrics"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/tracing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestGetAccrualRequestTooManyRequests(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte("No more than 120 requests per minute allowed"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"order": "12345678903", "status": "PROCESSED", "accrual": 500.5}`))
+	}))
+	defer server.Close()
+
+	m := metrics.New()
+	webAPI := NewAccrualWebAPI(&config.Config{AcrualSystemAddress: server.URL}, m)
+	start := time.Now()
+	response, err := webAPI.GetAccrualRequest(context.Background(), "12345678903")
+	require.NoError(t, err)
+	assert.Equal(t, entity.NewMoney(500, 50), response.Accrual)
+	assert.EqualValues(t, 2, calls.Load())
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	assert.Equal(t, 500*time.Millisecond, webAPI.throttle.interval)
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, w.Body.String(), `gophermart_accrual_requests_total{outcome="rate_limited"} 1`)
+	assert.Contains(t, w.Body.String(), `gophermart_accrual_requests_total{outcome="ok"} 1`)
+}
+
+func TestGetAccrualRequestCanceledDuringPause(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	webAPI := NewAccrualWebAPI(&config.Config{AcrualSystemAddress: server.URL}, metrics.New())
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err := webAPI.GetAccrualRequest(ctx, "12345678903")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestGetAccrualRequestPropagatesTrace(t *testing.T) {
+	var traceparent atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent.Store(r.Header.Get("traceparent"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	_, err := tracing.Setup(context.Background(), config.TracesExporterNone, "", nil)
+	require.NoError(t, err)
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	require.NoError(t, err)
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	require.NoError(t, err)
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	webAPI := NewAccrualWebAPI(&config.Config{AcrualSystemAddress: server.URL}, metrics.New())
+	_, err = webAPI.GetAccrualRequest(ctx, "12345678903")
+	require.Error(t, err)
+	// даже без экспортёра трассировка продолжается в accrual
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", traceparent.Load())
+}
+
+func TestThrottleMinimumPause(t *testing.T) {
+	throttle := &throttle{}
+	throttle.Pause(parseRetryAfter("0"), parseRequestsPerMinute([]byte("slow down")))
+	assert.GreaterOrEqual(t, time.Until(throttle.pausedUntil), MinRetryAfter-100*time.Millisecond)
+	assert.Equal(t, time.Duration(0), throttle.interval)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, 60*time.Second, parseRetryAfter("60"))
+	assert.Equal(t, DefaultRetryAfter, parseRetryAfter(""))
+	assert.Equal(t, DefaultRetryAfter, parseRetryAfter("soon"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)))
+}
+
+func TestParseRequestsPerMinute(t *testing.T) {
+	assert.Equal(t, 60, parseRequestsPerMinute([]byte("No more than 60 requests per minute allowed")))
+	assert.Equal(t, 0, parseRequestsPerMinute([]byte("slow down")))
+}
@@ -0,0 +1,98 @@
+package webapi
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultRetryAfter используется, если сервис начислений ответил 429 без корректного Retry-After
+const DefaultRetryAfter = time.Minute
+
+// MinRetryAfter минимальная пауза после 429: с Retry-After: 0 и без лимита запросы шли бы подряд без остановки
+const MinRetryAfter = time.Second
+
+var requestsPerMinuteRegexp = regexp.MustCompile(`No more than (\d+) requests per minute allowed`)
+
+// throttle общий для всех запросов к сервису начислений: держит глобальную паузу
+// после 429 и равномерно распределяет запросы под объявленный сервисом лимит
+type throttle struct {
+	mu          sync.Mutex
+	interval    time.Duration
+	next        time.Time
+	pausedUntil time.Time
+}
+
+// Wait блокирует до момента, когда можно отправить следующий запрос
+func (t *throttle) Wait(ctx context.Context) error {
+	t.mu.Lock()
+	now := time.Now()
+	slot := now
+	if t.pausedUntil.After(slot) {
+		slot = t.pausedUntil
+	}
+	if t.next.After(slot) {
+		slot = t.next
+	}
+	t.next = slot.Add(t.interval)
+	t.mu.Unlock()
+	delay := slot.Sub(now)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Pause приостанавливает все запросы на d, но не меньше MinRetryAfter,
+// и, если известен лимит, подстраивает под него темп
+func (t *throttle) Pause(d time.Duration, requestsPerMinute int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if d < MinRetryAfter {
+		d = MinRetryAfter
+	}
+	until := time.Now().Add(d)
+	if until.After(t.pausedUntil) {
+		t.pausedUntil = until
+	}
+	if requestsPerMinute > 0 {
+		t.interval = time.Minute / time.Duration(requestsPerMinute)
+	}
+}
+
+// parseRetryAfter разбирает Retry-After в секундах или в формате HTTP-даты
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return DefaultRetryAfter
+}
+
+// parseRequestsPerMinute достаёт N из тела ответа "No more than N requests per minute allowed"
+func parseRequestsPerMinute(body []byte) int {
+	match := requestsPerMinuteRegexp.FindSubmatch(body)
+	if match == nil {
+		return 0
+	}
+	n, err := strconv.Atoi(string(match[1]))
+	if err != nil {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type poolMetric struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	value     func(stat *pgxpool.Stat) float64
+}
+
+// poolCollector снимает pgxpool.Stat в момент сбора
+type poolCollector struct {
+	pool    *pgxpool.Pool
+	metrics []poolMetric
+}
+
+func newPoolMetric(name string, help string, valueType prometheus.ValueType, value func(stat *pgxpool.Stat) float64) poolMetric {
+	return poolMetric{
+		desc:      prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", name), help, nil, nil),
+		valueType: valueType,
+		value:     value,
+	}
+}
+
+// RegisterPool публикует статистику пула соединений с базой
+func (m *Metrics) RegisterPool(pool *pgxpool.Pool) {
+	m.registry.MustRegister(&poolCollector{pool: pool, metrics: []poolMetric{
+		newPoolMetric("acquire_total", "Количество захватов соединения из пула.", prometheus.CounterValue,
+			func(stat *pgxpool.Stat) float64 { return float64(stat.AcquireCount()) }),
+		newPoolMetric("acquire_duration_seconds_total", "Суммарное время захвата соединений.", prometheus.CounterValue,
+			func(stat *pgxpool.Stat) float64 { return stat.AcquireDuration().Seconds() }),
+		newPoolMetric("empty_acquire_total", "Захваты, ждавшие освобождения соединения.", prometheus.CounterValue,
+			func(stat *pgxpool.Stat) float64 { return float64(stat.EmptyAcquireCount()) }),
+		newPoolMetric("canceled_acquire_total", "Захваты, отменённые контекстом.", prometheus.CounterValue,
+			func(stat *pgxpool.Stat) float64 { return float64(stat.CanceledAcquireCount()) }),
+		newPoolMetric("new_connections_total", "Открытые пулом соединения.", prometheus.CounterValue,
+			func(stat *pgxpool.Stat) float64 { return float64(stat.NewConnsCount()) }),
+		newPoolMetric("max_lifetime_destroy_total", "Соединения, закрытые по MaxConnLifetime.", prometheus.CounterValue,
+			func(stat *pgxpool.Stat) float64 { return float64(stat.MaxLifetimeDestroyCount()) }),
+		newPoolMetric("max_idle_destroy_total", "Соединения, закрытые по MaxConnIdleTime.", prometheus.CounterValue,
+			func(stat *pgxpool.Stat) float64 { return float64(stat.MaxIdleDestroyCount()) }),
+		newPoolMetric("acquired_connections", "Соединения, занятые запросами.", prometheus.GaugeValue,
+			func(stat *pgxpool.Stat) float64 { return float64(stat.AcquiredConns()) }),
+		newPoolMetric("idle_connections", "Свободные соединения.", prometheus.GaugeValue,
+			func(stat *pgxpool.Stat) float64 { return float64(stat.IdleConns()) }),
+		newPoolMetric("constructing_connections", "Соединения, которые открываются.", prometheus.GaugeValue,
+			func(stat *pgxpool.Stat) float64 { return float64(stat.ConstructingConns()) }),
+		newPoolMetric("total_connections", "Все соединения пула.", prometheus.GaugeValue,
+			func(stat *pgxpool.Stat) float64 { return float64(stat.TotalConns()) }),
+		newPoolMetric("max_connections", "Наибольший размер пула.", prometheus.GaugeValue,
+			func(stat *pgxpool.Stat) float64 { return float64(stat.MaxConns()) }),
+	}})
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, metric := range c.metrics {
+		ch <- metric.desc
+	}
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	for _, metric := range c.metrics {
+		ch <- prometheus.MustNewConstMetric(metric.desc, metric.valueType, metric.value(stat))
+	}
+}
@@ -0,0 +1,137 @@
+// Package metrics собирает метрики сервиса в собственный реестр Prometheus и отдаёт их на /metrics
+// отдельного сервера, а не публичного роутера.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gophermart"
+
+// Исходы запроса к системе начислений
+const (
+	AccrualOutcomeOK          = "ok"
+	AccrualOutcomeNotFound    = "not_registered"
+	AccrualOutcomePending     = "pending"
+	AccrualOutcomeRateLimited = "rate_limited"
+	AccrualOutcomeServerError = "server_error"
+	AccrualOutcomeError       = "error"
+)
+
+// UnmatchedRoute метка для запросов, не попавших ни в один маршрут: путь не пишется, чтобы не плодить серии
+const UnmatchedRoute = "unmatched"
+
+// Metrics реестр и метрики сервиса. Реестр свой, а не глобальный: в тестах роутер собирается многократно.
+type Metrics struct {
+	registry         *prometheus.Registry
+	httpRequests     *prometheus.CounterVec
+	httpDuration     *prometheus.HistogramVec
+	accrualRequests  *prometheus.CounterVec
+	accrualDuration  *prometheus.HistogramVec
+	accrualBatchSize prometheus.Histogram
+	orders           *prometheus.GaugeVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Количество обработанных HTTP-запросов.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Время обработки HTTP-запросов.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		accrualRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "accrual",
+			Name:      "requests_total",
+			Help:      "Количество запросов к системе начислений по исходу.",
+		}, []string{"outcome"}),
+		accrualDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "accrual",
+			Name:      "request_duration_seconds",
+			Help:      "Время запроса к системе начислений, включая повторы.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"outcome"}),
+		accrualBatchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "accrual",
+			Name:      "update_batch_size",
+			Help:      "Размер пачек заказов, сохранённых UpdateOrders.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 6),
+		}),
+		orders: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "orders",
+			Help:      "Заказы по статусу обработки.",
+		}, []string{"status"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.accrualRequests,
+		m.accrualDuration,
+		m.accrualBatchSize,
+		m.orders,
+	)
+	return m
+}
+
+// Handler отдаёт метрики реестра в текстовом формате Prometheus; ошибка одного сборщика не мешает отдать остальные
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError})
+}
+
+// ObserveHTTPRequest учитывает запрос; route — шаблон маршрута, а не путь запроса
+func (m *Metrics) ObserveHTTPRequest(method string, route string, status int, duration time.Duration) {
+	code := strconv.Itoa(status)
+	m.httpRequests.WithLabelValues(method, route, code).Inc()
+	m.httpDuration.WithLabelValues(method, route, code).Observe(duration.Seconds())
+}
+
+// ObserveAccrualRequest учитывает запрос к системе начислений с исходом из AccrualOutcome*
+func (m *Metrics) ObserveAccrualRequest(outcome string, duration time.Duration) {
+	m.accrualRequests.WithLabelValues(outcome).Inc()
+	m.accrualDuration.WithLabelValues(outcome).Observe(duration.Seconds())
+}
+
+// ObserveAccrualBatch учитывает пачку, сохранённую UpdateOrders
+func (m *Metrics) ObserveAccrualBatch(size int) {
+	m.accrualBatchSize.Observe(float64(size))
+}
+
+// SetOrders публикует число заказов по статусам. Статусы без заказов отдаются нулями, чтобы серии не пропадали.
+func (m *Metrics) SetOrders(counts map[entity.ProcessStatus]int) {
+	for _, status := range []entity.ProcessStatus{entity.NEW, entity.PROCESSING, entity.INVALID, entity.PROCESSED} {
+		m.orders.WithLabelValues(string(status)).Set(float64(counts[status]))
+	}
+}
+
+// RegisterAccrualQueue публикует глубину очереди заказов к воркерам опроса; значение читается при сборе
+func (m *Metrics) RegisterAccrualQueue(length func() int) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "queue_length",
+		Help:      "Заказы, ожидающие воркера опроса системы начислений.",
+	}, func() float64 {
+		return float64(length())
+	}))
+}
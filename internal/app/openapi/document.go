@@ -0,0 +1,221 @@
+// Package openapi проверяет запросы и ответы по спецификации OpenAPI 3.0 сервиса.
+// Поддерживается то подмножество спецификации и JSON Schema, которое используется в api/openapi.json.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+	routes     []*Route
+}
+
+type Components struct {
+	Schemas    map[string]*Schema    `json:"schemas"`
+	Parameters map[string]*Parameter `json:"parameters"`
+	Responses  map[string]*Response  `json:"responses"`
+}
+
+type Operation struct {
+	Parameters  []*Parameter         `json:"parameters"`
+	RequestBody *RequestBody         `json:"requestBody"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Ref     string                `json:"$ref"`
+	Content map[string]*MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Route операция спецификации с разобранным шаблоном пути
+type Route struct {
+	Method    string
+	Path      string
+	Operation *Operation
+	segments  []string
+}
+
+// Load разбирает спецификацию и разрешает ссылки на компоненты
+func Load(data []byte) (*Document, error) {
+	doc := &Document{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.0") {
+		return nil, fmt.Errorf("unsupported openapi version %q", doc.OpenAPI)
+	}
+	for _, schema := range doc.Components.Schemas {
+		if err := doc.resolveSchema(schema); err != nil {
+			return nil, err
+		}
+	}
+	for path, operations := range doc.Paths {
+		for method, operation := range operations {
+			if err := doc.resolveOperation(operation); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", strings.ToUpper(method), path, err)
+			}
+			doc.routes = append(doc.routes, &Route{
+				Method:    strings.ToUpper(method),
+				Path:      path,
+				Operation: operation,
+				segments:  strings.Split(strings.Trim(path, "/"), "/"),
+			})
+		}
+	}
+	return doc, nil
+}
+
+// Routes все операции спецификации
+func (doc *Document) Routes() []*Route {
+	return doc.routes
+}
+
+// FindRoute ищет операцию по методу и пути запроса и возвращает значения параметров пути.
+// Если путь подходит под несколько шаблонов, выбирается шаблон с меньшим числом параметров.
+func (doc *Document) FindRoute(method string, path string) (*Route, map[string]string, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	var found *Route
+	var foundParams map[string]string
+	for _, route := range doc.routes {
+		if route.Method != method {
+			continue
+		}
+		params, ok := route.match(segments)
+		if ok && (found == nil || len(params) < len(foundParams)) {
+			found, foundParams = route, params
+		}
+	}
+	return found, foundParams, found != nil
+}
+
+func (route *Route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(route.segments) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, segment := range route.segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[segment[1:len(segment)-1]] = segments[i]
+			continue
+		}
+		if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func (doc *Document) resolveOperation(operation *Operation) error {
+	for i, param := range operation.Parameters {
+		if param.Ref != "" {
+			resolved, ok := doc.Components.Parameters[strings.TrimPrefix(param.Ref, "#/components/parameters/")]
+			if !ok {
+				return fmt.Errorf("unknown parameter %s", param.Ref)
+			}
+			operation.Parameters[i] = resolved
+			param = resolved
+		}
+		if err := doc.resolveSchema(param.Schema); err != nil {
+			return err
+		}
+	}
+	if operation.RequestBody != nil {
+		for _, media := range operation.RequestBody.Content {
+			if err := doc.resolveSchema(media.Schema); err != nil {
+				return err
+			}
+		}
+	}
+	for status, response := range operation.Responses {
+		if response.Ref != "" {
+			resolved, ok := doc.Components.Responses[strings.TrimPrefix(response.Ref, "#/components/responses/")]
+			if !ok {
+				return fmt.Errorf("unknown response %s", response.Ref)
+			}
+			operation.Responses[status] = resolved
+			response = resolved
+		}
+		for _, media := range response.Content {
+			if err := doc.resolveSchema(media.Schema); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveSchema связывает $ref со схемами из components и компилирует pattern.
+// Схемы компонентов разрешаются на месте, поэтому повторный вызов для них ничего не делает.
+func (doc *Document) resolveSchema(schema *Schema) error {
+	if schema == nil || schema.resolved {
+		return nil
+	}
+	schema.resolved = true
+	if schema.Ref != "" {
+		target, ok := doc.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+		if !ok {
+			return fmt.Errorf("unknown schema %s", schema.Ref)
+		}
+		schema.target = target
+		return doc.resolveSchema(target)
+	}
+	if schema.Pattern != "" {
+		pattern, err := regexp.Compile(schema.Pattern)
+		if err != nil {
+			return err
+		}
+		schema.pattern = pattern
+	}
+	for _, property := range schema.Properties {
+		if err := doc.resolveSchema(property); err != nil {
+			return err
+		}
+	}
+	for _, variant := range schema.OneOf {
+		if err := doc.resolveSchema(variant); err != nil {
+			return err
+		}
+	}
+	return doc.resolveSchema(schema.Items)
+}
+
+// responseFor описание ответа для статуса: точное, по классу (2XX) или default
+func (operation *Operation) responseFor(status int) (*Response, bool) {
+	for _, key := range []string{fmt.Sprint(status), fmt.Sprintf("%dXX", status/100), "default"} {
+		if response, ok := operation.Responses[key]; ok {
+			return response, true
+		}
+	}
+	return nil, false
+}
+
+// isJSON сообщает, разбирается ли тело с этим типом как JSON (application/json, application/problem+json)
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
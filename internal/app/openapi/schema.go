@@ -0,0 +1,193 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema подмножество JSON Schema из OpenAPI 3.0
+type Schema struct {
+	Ref        string             `json:"$ref"`
+	Type       string             `json:"type"`
+	Format     string             `json:"format"`
+	Enum       []any              `json:"enum"`
+	Nullable   bool               `json:"nullable"`
+	Properties map[string]*Schema `json:"properties"`
+	Required   []string           `json:"required"`
+	Items      *Schema            `json:"items"`
+	OneOf      []*Schema          `json:"oneOf"`
+	Minimum    *float64           `json:"minimum"`
+	Maximum    *float64           `json:"maximum"`
+	MinLength  *int               `json:"minLength"`
+	MaxLength  *int               `json:"maxLength"`
+	MinItems   *int               `json:"minItems"`
+	Pattern    string             `json:"pattern"`
+	target     *Schema
+	pattern    *regexp.Regexp
+	resolved   bool
+}
+
+// Validate проверяет значение, разобранное json.Decoder с UseNumber; path попадает в текст ошибки
+func (s *Schema) Validate(value any, path string) error {
+	if s.target != nil {
+		return s.target.Validate(value, path)
+	}
+	if value == nil {
+		if s.Nullable || s.Type == "" && len(s.OneOf) == 0 {
+			return nil
+		}
+		return fmt.Errorf("%s: must not be null", path)
+	}
+	if len(s.OneOf) > 0 {
+		matched := 0
+		for _, variant := range s.OneOf {
+			if variant.Validate(value, path) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fmt.Errorf("%s: must match exactly one of %d schemas", path, len(s.OneOf))
+		}
+	}
+	if err := s.validateType(value, path); err != nil {
+		return err
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(allowed any) bool { return fmt.Sprint(allowed) == fmt.Sprint(value) }) {
+		return fmt.Errorf("%s: must be one of %v", path, s.Enum)
+	}
+	return nil
+}
+
+func (s *Schema) validateType(value any, path string) error {
+	switch s.Type {
+	case "":
+		return nil
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: must be an object", path)
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s: property %q is required", path, name)
+			}
+		}
+		for name, property := range s.Properties {
+			if val, ok := object[name]; ok {
+				if err := property.Validate(val, path+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: must be an array", path)
+		}
+		if s.MinItems != nil && len(array) < *s.MinItems {
+			return fmt.Errorf("%s: must have at least %d items", path, *s.MinItems)
+		}
+		if s.Items == nil {
+			return nil
+		}
+		for i, item := range array {
+			if err := s.Items.Validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: must be a string", path)
+		}
+		return s.validateString(str, path)
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: must be a %s", path, s.Type)
+		}
+		return s.validateNumber(number, path)
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: must be a boolean", path)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%s: unsupported schema type %q", path, s.Type)
+	}
+}
+
+func (s *Schema) validateString(str string, path string) error {
+	length := len([]rune(str))
+	if s.MinLength != nil && length < *s.MinLength {
+		return fmt.Errorf("%s: must be at least %d characters long", path, *s.MinLength)
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		return fmt.Errorf("%s: must be at most %d characters long", path, *s.MaxLength)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		return fmt.Errorf("%s: must match %s", path, s.Pattern)
+	}
+	if s.Format == "date-time" {
+		if _, err := time.Parse(time.RFC3339, str); err != nil {
+			return fmt.Errorf("%s: must be an RFC 3339 date-time", path)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateNumber(number json.Number, path string) error {
+	if s.Type == "integer" {
+		if _, err := number.Int64(); err != nil {
+			return fmt.Errorf("%s: must be an integer", path)
+		}
+	}
+	value, err := number.Float64()
+	if err != nil {
+		return fmt.Errorf("%s: must be a number", path)
+	}
+	if s.Minimum != nil && value < *s.Minimum {
+		return fmt.Errorf("%s: must be at least %v", path, *s.Minimum)
+	}
+	if s.Maximum != nil && value > *s.Maximum {
+		return fmt.Errorf("%s: must be at most %v", path, *s.Maximum)
+	}
+	return nil
+}
+
+// parseParameter приводит строковое значение параметра к типу его схемы
+func (s *Schema) parseParameter(raw string) any {
+	if s.target != nil {
+		return s.target.parseParameter(raw)
+	}
+	switch s.Type {
+	case "integer", "number":
+		return json.Number(raw)
+	case "boolean":
+		if value, err := strconv.ParseBool(raw); err == nil {
+			return value
+		}
+	}
+	return raw
+}
+
+// decodeJSON разбирает тело так, чтобы числа остались json.Number
+func decodeJSON(body []byte) (any, error) {
+	decoder := json.NewDecoder(strings.NewReader(string(body)))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return value, nil
+}
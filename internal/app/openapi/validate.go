@@ -0,0 +1,149 @@
+package openapi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+)
+
+// ValidateRequest проверяет параметры и тело запроса. Тело читается целиком и возвращается в r.Body,
+// поэтому обработчик получает его без изменений; размер тела ограничивает вызывающий (http.MaxBytesReader). Ошибки несут код ответа и код из каталога customerr.
+func (route *Route) ValidateRequest(r *http.Request, pathParams map[string]string) error {
+	for _, param := range route.Operation.Parameters {
+		if err := validateParameter(r, param, pathParams); err != nil {
+			code := customerr.CodeBadRequest
+			if param.In == "query" {
+				code = customerr.CodeInvalidQuery
+			}
+			return customerr.NewCodedError(err, http.StatusBadRequest, code)
+		}
+	}
+	body := route.Operation.RequestBody
+	if body == nil || r.Body == nil {
+		return nil
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		maxBytesErr := &http.MaxBytesError{}
+		if errors.As(err, &maxBytesErr) {
+			return customerr.NewCodedError(err, http.StatusRequestEntityTooLarge, customerr.CodeRequestTooLarge)
+		}
+		return customerr.NewCodedError(err, http.StatusBadRequest, customerr.CodeBadRequest)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	if len(data) == 0 {
+		if body.Required {
+			return customerr.NewCodedError(errors.New("request body is required"), http.StatusBadRequest, customerr.CodeValidationFailed)
+		}
+		return nil
+	}
+	mediaType, media, err := body.mediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return customerr.NewCodedError(err, http.StatusUnsupportedMediaType, customerr.CodeUnsupportedMedia)
+	}
+	if !isJSON(mediaType) || media.Schema == nil {
+		return nil
+	}
+	value, err := decodeJSON(data)
+	if err != nil {
+		return customerr.NewCodedError(err, http.StatusBadRequest, customerr.CodeBadRequest)
+	}
+	if err = media.Schema.Validate(value, "body"); err != nil {
+		return customerr.NewCodedError(err, http.StatusBadRequest, customerr.CodeValidationFailed)
+	}
+	return nil
+}
+
+// ValidateResponse проверяет, что статус ответа описан в спецификации, а тело соответствует схеме
+func (route *Route) ValidateResponse(status int, header http.Header, body []byte) error {
+	response, ok := route.Operation.responseFor(status)
+	if !ok {
+		return fmt.Errorf("%s %s: status %d is not documented", route.Method, route.Path, status)
+	}
+	if len(response.Content) == 0 {
+		if len(body) > 0 {
+			return fmt.Errorf("%s %s: status %d must not have a body", route.Method, route.Path, status)
+		}
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("%s %s: status %d: %w", route.Method, route.Path, status, err)
+	}
+	media, ok := response.Content[mediaType]
+	if !ok {
+		return fmt.Errorf("%s %s: status %d: content type %s is not documented", route.Method, route.Path, status, mediaType)
+	}
+	if !isJSON(mediaType) || media.Schema == nil {
+		return nil
+	}
+	value, err := decodeJSON(body)
+	if err != nil {
+		return fmt.Errorf("%s %s: status %d: %w", route.Method, route.Path, status, err)
+	}
+	if err = media.Schema.Validate(value, "body"); err != nil {
+		return fmt.Errorf("%s %s: status %d: %w", route.Method, route.Path, status, err)
+	}
+	return nil
+}
+
+func validateParameter(r *http.Request, param *Parameter, pathParams map[string]string) error {
+	var raw string
+	var present bool
+	switch param.In {
+	case "path":
+		raw, present = pathParams[param.Name]
+	case "query":
+		values, ok := r.URL.Query()[param.Name]
+		if ok && len(values) > 0 {
+			raw, present = values[0], true
+		}
+	case "header":
+		raw = r.Header.Get(param.Name)
+		present = raw != ""
+	default:
+		return nil
+	}
+	if !present {
+		if param.Required {
+			return fmt.Errorf("%s parameter %q is required", param.In, param.Name)
+		}
+		return nil
+	}
+	if param.Schema == nil {
+		return nil
+	}
+	return param.Schema.Validate(param.Schema.parseParameter(raw), param.Name)
+}
+
+// mediaType выбирает описание тела по Content-Type запроса. Без заголовка подходит
+// единственный описанный тип: клиенты исходного API не всегда его передают.
+func (body *RequestBody) mediaType(contentType string) (string, *MediaType, error) {
+	if contentType == "" {
+		if len(body.Content) == 1 {
+			for mediaType, media := range body.Content {
+				return mediaType, media, nil
+			}
+		}
+		return "", nil, errors.New("content type is required")
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", nil, err
+	}
+	media, ok := body.Content[mediaType]
+	if !ok {
+		supported := make([]string, 0, len(body.Content))
+		for mediaType := range body.Content {
+			supported = append(supported, mediaType)
+		}
+		return "", nil, fmt.Errorf("content type %s is not supported, use %s", mediaType, strings.Join(supported, " or "))
+	}
+	return mediaType, media, nil
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/usecase"
+)
+
+// RunAdminCommand выполняет подкоманду `gophermart admin grant <login> | revoke <login>`:
+// так назначается первый администратор, дальше роли видны в журнале admin_audit
+func RunAdminCommand(ctx context.Context, config *config.Config, storage *repository.Storage, args []string, out io.Writer) error {
+	if config.UseMemoryStorage() {
+		return errors.New("admin commands are not supported for memory storage")
+	}
+	if len(args) != 2 {
+		return errors.New("usage: gophermart admin grant <login> | revoke <login>")
+	}
+	var role entity.Role
+	switch args[0] {
+	case "grant":
+		role = entity.RoleAdmin
+	case "revoke":
+		role = entity.RoleUser
+	default:
+		return fmt.Errorf("unknown admin command %q", args[0])
+	}
+	err := migrateUp(ctx, storage.Pool)
+	if err != nil {
+		return err
+	}
+	adminRepo, err := repository.NewAdminRepository(ctx, config, storage)
+	if err != nil {
+		return err
+	}
+	err = usecase.NewAdminService(config, adminRepo, nil, nil).SetRole(ctx, args[1], role)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s: %s\n", args[1], role)
+	return nil
+}
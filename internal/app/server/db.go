@@ -2,35 +2,133 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
 
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/migrations"
+
+	"github.com/golang-migrate/migrate/v4"
+	pgxmigrate "github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 )
 
-func initTables(ctx context.Context, pool *pgxpool.Pool) error {
-	query := `
-		create table if not exists "user" (
-			"id" serial not null,
-			"login" varchar(255) not null,
-			"password" varchar(255) not null,
-			"created_at" timestamp default now(),
-			"deleted_at" timestamp,
-			constraint "user_pk" primary key ("id")
-		);
-		create table if not exists "balance_operation" (
-			"id" serial not null,
-			"sum" integer default 0,
-			"order" varchar(255) not null,
-			"status" varchar(255),
-			"type" varchar(255) not null,
-			"user_id" integer not null,
-			"created_at" timestamp default now(),
-			"deleted_at" timestamp,
-			constraint "balance_operation_pk" primary key ("id")
-		);
-		ALTER TABLE "balance_operation" ADD CONSTRAINT "balance_operation_fk" FOREIGN KEY ("user_id") REFERENCES "user"("id");
-		CREATE UNIQUE INDEX "order_idx" ON "balance_operation"("order") where "deleted_at" is null;
-		CREATE UNIQUE INDEX "login_idx" ON "user"("login") where "deleted_at" is null;
-	`
-	_, err := pool.Exec(ctx, query)
-	return err
+var ErrDirtySchema = errors.New("database schema is dirty")
+
+func newMigrate(pool *pgxpool.Pool) (*migrate.Migrate, error) {
+	source, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		return nil, err
+	}
+	driver, err := pgxmigrate.WithInstance(stdlib.OpenDBFromPool(pool), &pgxmigrate.Config{})
+	if err != nil {
+		return nil, err
+	}
+	return migrate.NewWithInstance("iofs", source, "pgx5", driver)
+}
+
+// migrateUp применяет недостающие миграции и отказывается работать со схемой,
+// на которой предыдущая миграция упала посередине
+func migrateUp(ctx context.Context, pool *pgxpool.Pool) error {
+	m, err := newMigrate(pool)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		err = adoptLegacySchema(ctx, pool, m)
+	}
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("%w at version %d, repair it and run `gophermart migrate force <version>`", ErrDirtySchema, version)
+	}
+	err = m.Up()
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
+
+// adoptLegacySchema отмечает применённой первую миграцию в базах, которые создавал initTables до появления миграций:
+// таблицы в них есть, а версии нет, и повторный 001 упал бы на уже существующих таблицах
+func adoptLegacySchema(ctx context.Context, pool *pgxpool.Pool, m *migrate.Migrate) error {
+	var legacy bool
+	err := pool.QueryRow(ctx, `select to_regclass('"user"') is not null`).Scan(&legacy)
+	if err != nil || !legacy {
+		return err
+	}
+	return m.Force(1)
+}
+
+// RunMigrateCommand выполняет подкоманду `gophermart migrate up [N] | down [N] | status | force <version>`
+func RunMigrateCommand(ctx context.Context, config *config.Config, storage *repository.Storage, args []string, out io.Writer) error {
+	if config.UseMemoryStorage() {
+		return errors.New("migrations are not supported for memory storage")
+	}
+	if len(args) == 0 {
+		return errors.New("usage: gophermart migrate up [N] | down [N] | status | force <version>")
+	}
+	m, err := newMigrate(storage.Pool)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	steps := 0
+	if len(args) > 1 {
+		steps, err = strconv.Atoi(args[1])
+		if err != nil || steps < 0 {
+			return fmt.Errorf("invalid migration steps %q", args[1])
+		}
+	}
+	switch args[0] {
+	case "up":
+		if steps > 0 {
+			err = m.Steps(steps)
+		} else {
+			err = m.Up()
+		}
+	case "down":
+		if steps == 0 {
+			steps = 1
+		}
+		err = m.Steps(-steps)
+	case "force":
+		if len(args) < 2 {
+			return errors.New("usage: gophermart migrate force <version>")
+		}
+		err = m.Force(steps)
+	case "status":
+		return printVersion(m, out)
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+	if errors.Is(err, migrate.ErrNoChange) {
+		fmt.Fprintln(out, "no change")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return printVersion(m, out)
+}
+
+func printVersion(m *migrate.Migrate, out io.Writer) error {
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		fmt.Fprintln(out, "version: none")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "version: %d\ndirty: %t\n", version, dirty)
+	return nil
 }
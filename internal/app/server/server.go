@@ -2,14 +2,23 @@ package server
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"os"
+	"sync"
+	"time"
 
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/api"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
 	handlers "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/controller/http"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/controller/http/middleware"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/notifier"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/webapi"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/metrics"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/openapi"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/tracing"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/usecase"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/usecase/job"
 	log "github.com/go-kit/log"
@@ -28,12 +37,50 @@ type BalanceOperationHandler interface {
 type UserHandler interface {
 	RegisterHandler(w http.ResponseWriter, r *http.Request)
 	LoginHandler(w http.ResponseWriter, r *http.Request)
+	RefreshHandler(w http.ResponseWriter, r *http.Request)
+	LogoutHandler(w http.ResponseWriter, r *http.Request)
+	ChangePasswordHandler(w http.ResponseWriter, r *http.Request)
+	PasswordResetHandler(w http.ResponseWriter, r *http.Request)
+	PasswordResetConfirmHandler(w http.ResponseWriter, r *http.Request)
+	DeleteUserHandler(w http.ResponseWriter, r *http.Request)
+	LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request)
+	SetupTwoFactorHandler(w http.ResponseWriter, r *http.Request)
+	ConfirmTwoFactorHandler(w http.ResponseWriter, r *http.Request)
+	DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request)
+	ListSessionsHandler(w http.ResponseWriter, r *http.Request)
+	EndSessionHandler(w http.ResponseWriter, r *http.Request)
+}
+
+type AdminHandler interface {
+	ListUsersHandler(w http.ResponseWriter, r *http.Request)
+	GetUserOrdersHandler(w http.ResponseWriter, r *http.Request)
+	GetUserBalanceHandler(w http.ResponseWriter, r *http.Request)
+	BlockUserHandler(w http.ResponseWriter, r *http.Request)
+	UnblockUserHandler(w http.ResponseWriter, r *http.Request)
+	AdjustBalanceHandler(w http.ResponseWriter, r *http.Request)
+	GetAuditRecordsHandler(w http.ResponseWriter, r *http.Request)
+}
+
+type APIKeyHandler interface {
+	CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request)
+	ListAPIKeysHandler(w http.ResponseWriter, r *http.Request)
+	RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request)
 }
 
 type SecurityMiddleware interface {
 	SecurityMiddleware(h http.Handler) http.Handler
 }
 
+type AuthorizationMiddleware interface {
+	RequireRole(role entity.Role) func(h http.Handler) http.Handler
+	RequireScope(scope string) func(h http.Handler) http.Handler
+	RequireSession(h http.Handler) http.Handler
+}
+
+type IdempotencyMiddleware interface {
+	IdempotencyMiddleware(h http.Handler) http.Handler
+}
+
 type LoggingMiddleware interface {
 	LoggingMiddleware(h http.Handler) http.Handler
 }
@@ -42,62 +89,302 @@ type CompressionMiddleware interface {
 	CompressionMiddleware(h http.Handler) http.Handler
 }
 
-func Start(ctx context.Context, config *config.Config) error {
-	err := initTables(ctx, config.Pool)
+type OpenAPIMiddleware interface {
+	OpenAPIMiddleware(h http.Handler) http.Handler
+}
+
+type TracingMiddleware interface {
+	TracingMiddleware(h http.Handler) http.Handler
+}
+
+type MetricsMiddleware interface {
+	MetricsMiddleware(h http.Handler) http.Handler
+}
+
+type OpenAPIHandler interface {
+	SpecHandler(w http.ResponseWriter, r *http.Request)
+	DocsHandler(w http.ResponseWriter, r *http.Request)
+}
+
+// routerHandlers обработчики, из которых getRouter собирает маршруты
+type routerHandlers struct {
+	user             UserHandler
+	balanceOperation BalanceOperationHandler
+	admin            AdminHandler
+	apiKey           APIKeyHandler
+	openAPI          OpenAPIHandler
+}
+
+// routerMiddlewares промежуточные обработчики роутера
+type routerMiddlewares struct {
+	security      SecurityMiddleware
+	authorization AuthorizationMiddleware
+	idempotency   IdempotencyMiddleware
+	logging       LoggingMiddleware
+	compression   CompressionMiddleware
+	openAPI       OpenAPIMiddleware
+	metrics       MetricsMiddleware
+	tracing       TracingMiddleware
+}
+
+const DefaultShutdownTimeout = 10 * time.Second
+
+// Start запускает HTTP-сервер и фоновые задачи и работает до отмены ctx
+func Start(ctx context.Context, config *config.Config, storage *repository.Storage) error {
+	if !config.UseMemoryStorage() {
+		err := migrateUp(ctx, storage.Pool)
+		if err != nil {
+			return err
+		}
+	}
+
+	var logger log.Logger
+	logger = log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC, "loc", log.DefaultCaller)
+
+	shutdownTracing, err := tracing.Setup(ctx, config.TracesExporter, config.OTLPEndpoint, os.Stdout)
 	if err != nil {
 		return err
 	}
+	// спаны выгружаются последними: после остановки сервера и фоновых задач
+	defer func() {
+		tracingCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), DefaultShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(tracingCtx); err != nil {
+			logger.Log("msg", "failed to flush traces", "err", err)
+		}
+	}()
+	tracingMiddleware := middleware.NewTracingMiddleware()
 
-	userRepo, err := repository.NewUserRepository(ctx, config)
+	appMetrics := metrics.New()
+	if !config.UseMemoryStorage() {
+		appMetrics.RegisterPool(storage.Pool)
+	}
+	metricsMiddleware := middleware.NewMetricsMiddleware(appMetrics)
+
+	userRepo, err := repository.NewUserRepository(ctx, config, storage)
+	if err != nil {
+		return err
+	}
+	refreshTokenRepo, err := repository.NewRefreshTokenRepository(ctx, config, storage)
+	if err != nil {
+		return err
+	}
+	attemptRepo, err := repository.NewAttemptRepository(ctx, config, storage)
+	if err != nil {
+		return err
+	}
+	passwordResetRepo, err := repository.NewPasswordResetRepository(ctx, config, storage)
+	if err != nil {
+		return err
+	}
+	twoFactorRepo, err := repository.NewTwoFactorRepository(ctx, config, storage)
+	if err != nil {
+		return err
+	}
+	sessionRepo, err := repository.NewSessionRepository(ctx, config, storage)
+	if err != nil {
+		return err
+	}
+	apiKeyRepo, err := repository.NewAPIKeyRepository(ctx, config, storage)
+	if err != nil {
+		return err
+	}
+	userNotifier, err := notifier.NewNotifier(config, logger)
 	if err != nil {
 		return err
 	}
-	userService := usecase.NewUserService(config, userRepo)
+	userService := usecase.NewUserService(config, userRepo, refreshTokenRepo, passwordResetRepo, twoFactorRepo, sessionRepo, apiKeyRepo, usecase.NewAttemptLimiter(config, attemptRepo), userNotifier)
 	userHandler := handlers.NewUserHandler(config, userService)
 
-	balanceOperationRepo, err := repository.NewBalanceOperationRepository(ctx, config)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(ctx, config, storage)
 	if err != nil {
 		return err
 	}
 	balanceOperationService := usecase.NewBalanceOperationService(config, balanceOperationRepo)
 	balanceOperationhandler := handlers.NewBalanceOperationHandler(config, balanceOperationService, userService)
 
-	securityMiddleware := middleware.NewSecurityMiddleware(userService)
+	adminRepo, err := repository.NewAdminRepository(ctx, config, storage)
+	if err != nil {
+		return err
+	}
+	adminHandler := handlers.NewAdminHandler(config, usecase.NewAdminService(config, adminRepo, balanceOperationService, refreshTokenRepo))
+
+	apiKeyService := usecase.NewAPIKeyService(config, apiKeyRepo)
+	apiKeyHandler := handlers.NewAPIKeyHandler(config, apiKeyService)
+
+	securityMiddleware := middleware.NewSecurityMiddleware(userService, apiKeyService)
+	authorizationMiddleware := middleware.NewAuthorizationMiddleware(userService)
+
+	idempotencyRepo, err := repository.NewIdempotencyRepository(ctx, config, storage)
+	if err != nil {
+		return err
+	}
+	idempotencyService := usecase.NewIdempotencyService(config, idempotencyRepo)
+	idempotencyMiddleware := middleware.NewIdempotencyMiddleware(idempotencyService, logger)
 
-	var logger log.Logger
-	logger = log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
-	logger = log.With(logger, "ts", log.DefaultTimestampUTC, "loc", log.DefaultCaller)
 	loggingMiddleware := middleware.NewLoggingMiddleware(logger)
 
 	compressionMiddleware := middleware.NewCompressionMiddleware()
 
-	runJobs(ctx, config, balanceOperationRepo)
+	openAPIDoc, err := openapi.Load(api.OpenAPI)
+	if err != nil {
+		return err
+	}
+	openAPIMiddleware := middleware.NewOpenAPIMiddleware(openAPIDoc, config.ValidateResponses, config.MaxRequestBody, logger)
+	openAPIHandler := handlers.NewOpenAPIHandler(api.OpenAPI, api.Docs)
+
+	ledgerRepo, err := repository.NewLedgerRepository(ctx, config, storage)
+	if err != nil {
+		return err
+	}
 
-	r := getRouter(userHandler, securityMiddleware, loggingMiddleware, compressionMiddleware, balanceOperationhandler)
+	// фоновые задачи останавливаются только после того, как HTTP-сервер дообслужит запросы
+	jobsCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelJobs()
+	jobs := &sync.WaitGroup{}
+	runJobs(jobsCtx, jobs, config, balanceOperationRepo, ledgerRepo, idempotencyRepo, logger, appMetrics)
 
-	err = http.ListenAndServe(config.RunAddress, r)
+	r := getRouter(routerHandlers{
+		user:             userHandler,
+		balanceOperation: balanceOperationhandler,
+		admin:            adminHandler,
+		apiKey:           apiKeyHandler,
+		openAPI:          openAPIHandler,
+	}, routerMiddlewares{
+		security:      securityMiddleware,
+		authorization: authorizationMiddleware,
+		idempotency:   idempotencyMiddleware,
+		logging:       loggingMiddleware,
+		compression:   compressionMiddleware,
+		openAPI:       openAPIMiddleware,
+		metrics:       metricsMiddleware,
+		tracing:       tracingMiddleware,
+	})
+
+	srv := &http.Server{Addr: config.RunAddress, Handler: r}
+	servers := []*http.Server{srv}
+	// метрики отдаются на отдельном адресе, недоступном клиентам API; если адрес занят,
+	// сервис продолжает работать без метрик
+	if config.MetricsAddress != "" {
+		metricsRouter := chi.NewRouter()
+		metricsRouter.Method(http.MethodGet, "/metrics", appMetrics.Handler())
+		metricsSrv := &http.Server{Addr: config.MetricsAddress, Handler: metricsRouter}
+		servers = append(servers, metricsSrv)
+		go func() {
+			if err := metricsSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Log("msg", "metrics server stopped", "err", err)
+			}
+		}()
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+	select {
+	case err = <-serveErr:
+		return errors.Join(err, shutdown(ctx, config, servers, cancelJobs, jobs))
+	case <-ctx.Done():
+	}
+	logger.Log("msg", "shutting down")
+	return shutdown(ctx, config, servers, cancelJobs, jobs)
+}
+
+// shutdown дожидается завершения запросов, затем останавливает фоновые задачи
+// и ждёт сохранения накопленных ими результатов; на всё отводится ShutdownTimeout
+func shutdown(ctx context.Context, config *config.Config, servers []*http.Server, cancelJobs context.CancelFunc, jobs *sync.WaitGroup) error {
+	timeout := config.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	var err error
+	for _, srv := range servers {
+		err = errors.Join(err, srv.Shutdown(shutdownCtx))
+	}
+	cancelJobs()
+	done := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		err = errors.Join(err, errors.New("background jobs did not stop in time"))
+	}
 	return err
 }
 
-func runJobs(ctx context.Context, config *config.Config, balanceOperationRepo repository.BalanceOperationRepository) {
-	balanceOperationJob := job.NewBalanceOperationJob(config, balanceOperationRepo, webapi.NewAccrualWebAPI(config))
-	go balanceOperationJob.ConsumeOrder(ctx)
-	go balanceOperationJob.ProduceOrder(ctx)
+func runJobs(ctx context.Context, jobs *sync.WaitGroup, config *config.Config, balanceOperationRepo repository.BalanceOperationRepository, ledgerRepo repository.LedgerRepository, idempotencyRepo repository.IdempotencyRepository, logger log.Logger, m *metrics.Metrics) {
+	balanceOperationJob := job.NewBalanceOperationJob(config, balanceOperationRepo, webapi.NewAccrualWebAPI(config, m), logger, m)
+	m.RegisterAccrualQueue(balanceOperationJob.QueueLength)
+	ledgerJob := job.NewLedgerJob(config, ledgerRepo, logger)
+	idempotencyJob := job.NewIdempotencyJob(config, idempotencyRepo, logger)
+	orderCountJob := job.NewOrderCountJob(config, balanceOperationRepo, logger, m)
+	for _, run := range []func(context.Context){
+		balanceOperationJob.Run,
+		ledgerJob.CheckInvariants,
+		idempotencyJob.DeleteExpired,
+		orderCountJob.Run,
+	} {
+		jobs.Add(1)
+		go func(run func(context.Context)) {
+			defer jobs.Done()
+			run(ctx)
+		}(run)
+	}
 }
 
-func getRouter(userH UserHandler, securityM SecurityMiddleware, loggingM LoggingMiddleware, compressionM CompressionMiddleware, balanceH BalanceOperationHandler) *chi.Mux {
+func getRouter(h routerHandlers, m routerMiddlewares) *chi.Mux {
 	rMain := chi.NewRouter()
-	rMain.Use(compressionM.CompressionMiddleware)
-	rMain.Use(loggingM.LoggingMiddleware)
-	rMain.Post("/api/user/register", userH.RegisterHandler)
-	rMain.Post("/api/user/login", userH.LoginHandler)
+	// первым, чтобы в длительность попало всё время обработки, включая сжатие
+	rMain.Use(m.metrics.MetricsMiddleware)
+	rMain.Use(m.tracing.TracingMiddleware)
+	rMain.Use(m.compression.CompressionMiddleware)
+	rMain.Use(m.logging.LoggingMiddleware)
+	// после распаковки: проверяется исходное тело запроса и несжатый ответ
+	rMain.Use(m.openAPI.OpenAPIMiddleware)
+	rMain.Get("/api/openapi.json", h.openAPI.SpecHandler)
+	rMain.Get("/api/docs", h.openAPI.DocsHandler)
+	rMain.Post("/api/user/register", h.user.RegisterHandler)
+	rMain.Post("/api/user/login", h.user.LoginHandler)
+	rMain.Post("/api/user/login/2fa", h.user.LoginTwoFactorHandler)
+	rMain.Post("/api/user/token/refresh", h.user.RefreshHandler)
+	rMain.Post("/api/user/password/reset", h.user.PasswordResetHandler)
+	rMain.Post("/api/user/password/reset/confirm", h.user.PasswordResetConfirmHandler)
 	rBalanceOperation := chi.NewRouter()
-	rBalanceOperation.Use(securityM.SecurityMiddleware)
-	rBalanceOperation.Get("/api/user/orders", balanceH.GetOrdersHandler)
-	rBalanceOperation.Get("/api/user/balance", balanceH.GetBalanceHandler)
-	rBalanceOperation.Get("/api/user/withdrawals", balanceH.GetWithdrawalsHandler)
-	rBalanceOperation.Post("/api/user/orders", balanceH.CreateOrderHandler)
-	rBalanceOperation.Post("/api/user/balance/withdraw", balanceH.WithdrawHandler)
+	rBalanceOperation.Use(m.security.SecurityMiddleware)
+	rBalanceOperation.With(m.authorization.RequireScope(entity.ScopeOrdersRead)).Get("/api/user/orders", h.balanceOperation.GetOrdersHandler)
+	rBalanceOperation.With(m.authorization.RequireScope(entity.ScopeBalanceRead)).Get("/api/user/balance", h.balanceOperation.GetBalanceHandler)
+	rBalanceOperation.With(m.authorization.RequireScope(entity.ScopeBalanceRead)).Get("/api/user/withdrawals", h.balanceOperation.GetWithdrawalsHandler)
+	rBalanceOperation.With(m.authorization.RequireScope(entity.ScopeOrdersWrite), m.idempotency.IdempotencyMiddleware).Post("/api/user/orders", h.balanceOperation.CreateOrderHandler)
+	// списание, управление аккаунтом, ключами и администрирование доступны только из сессии пользователя:
+	// утёкший API-ключ не должен тратить баллы
+	rSession := rBalanceOperation.With(m.authorization.RequireSession)
+	rSession.With(m.idempotency.IdempotencyMiddleware).Post("/api/user/balance/withdraw", h.balanceOperation.WithdrawHandler)
+	rSession.Post("/api/user/logout", h.user.LogoutHandler)
+	rSession.Put("/api/user/password", h.user.ChangePasswordHandler)
+	rSession.Delete("/api/user", h.user.DeleteUserHandler)
+	rSession.Get("/api/user/sessions", h.user.ListSessionsHandler)
+	rSession.Delete("/api/user/sessions/{id}", h.user.EndSessionHandler)
+	rSession.Post("/api/user/2fa", h.user.SetupTwoFactorHandler)
+	rSession.Post("/api/user/2fa/confirm", h.user.ConfirmTwoFactorHandler)
+	rSession.Delete("/api/user/2fa", h.user.DisableTwoFactorHandler)
+	rSession.Post("/api/user/api-keys", h.apiKey.CreateAPIKeyHandler)
+	rSession.Get("/api/user/api-keys", h.apiKey.ListAPIKeysHandler)
+	rSession.Delete("/api/user/api-keys/{id}", h.apiKey.RevokeAPIKeyHandler)
+	rSession.Route("/api/admin", func(rAdmin chi.Router) {
+		rAdmin.Use(m.authorization.RequireRole(entity.RoleAdmin))
+		rAdmin.Get("/users", h.admin.ListUsersHandler)
+		rAdmin.Get("/users/{id}/orders", h.admin.GetUserOrdersHandler)
+		rAdmin.Get("/users/{id}/balance", h.admin.GetUserBalanceHandler)
+		rAdmin.Post("/users/{id}/block", h.admin.BlockUserHandler)
+		rAdmin.Post("/users/{id}/unblock", h.admin.UnblockUserHandler)
+		rAdmin.Post("/users/{id}/balance/adjust", h.admin.AdjustBalanceHandler)
+		rAdmin.Get("/audit", h.admin.GetAuditRecordsHandler)
+	})
 	rMain.Mount("/", rBalanceOperation)
 	return rMain
 }
@@ -3,57 +3,194 @@ package server
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/api"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
 	handlers "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/controller/http"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/controller/http/middleware"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/notifier"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/metrics"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/openapi"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/tracing"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/usecase"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/usecase/job"
+	"github.com/go-chi/chi"
+	kitlog "github.com/go-kit/log"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/bcrypt"
 )
 
-var c *config.Config
+var (
+	c       *config.Config
+	storage *repository.Storage
+)
 
 type AccrualWebAPIForTest struct{}
 
-func (webAPI *AccrualWebAPIForTest) GetAccrualRequest(order string) (*entity.AccrualResponse, error) {
+func (webAPI *AccrualWebAPIForTest) GetAccrualRequest(ctx context.Context, order string) (*entity.AccrualResponse, error) {
 	return &entity.AccrualResponse{
 		Order:   order,
 		Status:  entity.PROCESSED,
-		Accrual: 200.00,
+		Accrual: entity.NewMoney(200, 0),
 	}, nil
 }
 
+// TestMain поднимает postgres в контейнере; без Docker или при TEST_STORAGE=memory
+// тесты выполняются на хранилище в памяти
 func TestMain(m *testing.M) {
 	ctx := context.Background()
-	testDB := SetupTestDatabase()
 	conf := &config.Config{}
-	conf.Pool = testDB.DBInstance
-	err := initTables(ctx, conf.Pool)
-	if err != nil {
-		return
+	var testDB *TestDatabase
+	var err error
+	if os.Getenv("TEST_STORAGE") != config.StorageMemory {
+		testDB, err = SetupTestDatabase()
+		if err != nil {
+			log.Print("postgres is unavailable, falling back to memory storage: ", err)
+		}
+	}
+	if testDB != nil {
+		conf.StorageType = config.StoragePostgres
+		storage = repository.NewPostgresStorage(testDB.DBInstance)
+		err = migrateUp(ctx, storage.Pool)
+		if err != nil {
+			log.Print(err)
+			testDB.TearDown()
+			os.Exit(1)
+		}
+	} else {
+		conf.StorageType = config.StorageMemory
+		storage = repository.NewMemoryStorage()
 	}
+	conf.JWTKeys = []config.JWTKey{{ID: "test", Algorithm: config.JWTAlgorithmHS256, Secret: []byte("test-secret-test-secret-test-secret")}}
+	conf.JWTSigningKeyID = "test"
+	conf.BcryptCost = bcrypt.MinCost
+	conf.ValidateResponses = true
 	c = conf
-	defer testDB.TearDown()
-	os.Exit(m.Run())
+	code := m.Run()
+	if testDB != nil {
+		testDB.TearDown()
+	}
+	os.Exit(code)
+}
+
+func newUserService(t *testing.T, conf *config.Config, userRepo repository.UserRepository) *usecase.UserService {
+	refreshTokenRepo, err := repository.NewRefreshTokenRepository(context.Background(), conf, storage)
+	require.NoError(t, err)
+	attemptRepo, err := repository.NewAttemptRepository(context.Background(), conf, storage)
+	require.NoError(t, err)
+	passwordResetRepo, err := repository.NewPasswordResetRepository(context.Background(), conf, storage)
+	require.NoError(t, err)
+	twoFactorRepo, err := repository.NewTwoFactorRepository(context.Background(), conf, storage)
+	require.NoError(t, err)
+	sessionRepo, err := repository.NewSessionRepository(context.Background(), conf, storage)
+	require.NoError(t, err)
+	apiKeyRepo, err := repository.NewAPIKeyRepository(context.Background(), conf, storage)
+	require.NoError(t, err)
+	return usecase.NewUserService(conf, userRepo, refreshTokenRepo, passwordResetRepo, twoFactorRepo, sessionRepo, apiKeyRepo, usecase.NewAttemptLimiter(conf, attemptRepo), notifications)
+}
+
+func newAPIKeyService(t *testing.T, conf *config.Config) *usecase.APIKeyService {
+	apiKeyRepo, err := repository.NewAPIKeyRepository(context.Background(), conf, storage)
+	require.NoError(t, err)
+	return usecase.NewAPIKeyService(conf, apiKeyRepo)
+}
+
+// newRouter собирает полный роутер приложения так же, как Start; ответы вне спецификации OpenAPI валят тест
+func newRouter(t *testing.T, conf *config.Config, userService *usecase.UserService, balanceOperationService *usecase.BalanceOperationService, adminService *usecase.AdminService) *chi.Mux {
+	return newRouterWithMetrics(t, conf, userService, balanceOperationService, adminService, metrics.New())
+}
+
+func newRouterWithMetrics(t *testing.T, conf *config.Config, userService *usecase.UserService, balanceOperationService *usecase.BalanceOperationService, adminService *usecase.AdminService, m *metrics.Metrics) *chi.Mux {
+	idempotencyRepo, err := repository.NewIdempotencyRepository(context.Background(), conf, storage)
+	require.NoError(t, err)
+	apiKeyService := newAPIKeyService(t, conf)
+	doc, err := openapi.Load(api.OpenAPI)
+	require.NoError(t, err)
+	specLogger := kitlog.LoggerFunc(func(keyvals ...any) error {
+		t.Error(keyvals...)
+		return nil
+	})
+	return getRouter(routerHandlers{
+		user:             handlers.NewUserHandler(conf, userService),
+		balanceOperation: handlers.NewBalanceOperationHandler(conf, balanceOperationService, userService),
+		admin:            handlers.NewAdminHandler(conf, adminService),
+		apiKey:           handlers.NewAPIKeyHandler(conf, apiKeyService),
+		openAPI:          handlers.NewOpenAPIHandler(api.OpenAPI, api.Docs),
+	}, routerMiddlewares{
+		security:      middleware.NewSecurityMiddleware(userService, apiKeyService),
+		authorization: middleware.NewAuthorizationMiddleware(userService),
+		idempotency:   middleware.NewIdempotencyMiddleware(usecase.NewIdempotencyService(conf, idempotencyRepo), kitlog.NewNopLogger()),
+		logging:       middleware.NewLoggingMiddleware(kitlog.NewNopLogger()),
+		compression:   middleware.NewCompressionMiddleware(),
+		openAPI:       middleware.NewOpenAPIMiddleware(doc, conf.ValidateResponses, conf.MaxRequestBody, specLogger),
+		metrics:       middleware.NewMetricsMiddleware(m),
+		tracing:       middleware.NewTracingMiddleware(),
+	})
+}
+
+// recordingNotifier запоминает отправленные уведомления вместо доставки
+type recordingNotifier struct {
+	mu       sync.Mutex
+	messages []*notifier.Message
+}
+
+var notifications = &recordingNotifier{}
+
+func (n *recordingNotifier) Send(ctx context.Context, message *notifier.Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.messages = append(n.messages, message)
+	return nil
+}
+
+func (n *recordingNotifier) last(to string) *notifier.Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i := len(n.messages) - 1; i >= 0; i-- {
+		if n.messages[i].To == to {
+			return n.messages[i]
+		}
+	}
+	return nil
 }
 
 func TestRegisterHandler(t *testing.T) {
 	ctx := context.Background()
-	userRepo, err := repository.NewUserRepository(ctx, c)
+	userRepo, err := repository.NewUserRepository(ctx, c, storage)
 	require.NoError(t, err)
-	userService := usecase.NewUserService(c, userRepo)
+	userService := newUserService(t, c, userRepo)
 	userHandler := handlers.NewUserHandler(c, userService)
 	tests := []struct {
 		name           string
@@ -117,9 +254,9 @@ func TestRegisterHandler(t *testing.T) {
 
 func TestLoginHandler(t *testing.T) {
 	ctx := context.Background()
-	userRepo, err := repository.NewUserRepository(ctx, c)
+	userRepo, err := repository.NewUserRepository(ctx, c, storage)
 	require.NoError(t, err)
-	userService := usecase.NewUserService(c, userRepo)
+	userService := newUserService(t, c, userRepo)
 	userHandler := handlers.NewUserHandler(c, userService)
 	tests := []struct {
 		name           string
@@ -213,15 +350,15 @@ func generateTokenToCheat(t *testing.T) string {
 
 func TestCreateOrderHandler(t *testing.T) {
 	cxt := context.Background()
-	userRepo, err := repository.NewUserRepository(cxt, c)
+	userRepo, err := repository.NewUserRepository(cxt, c, storage)
 	require.NoError(t, err)
-	userService := usecase.NewUserService(c, userRepo)
+	userService := newUserService(t, c, userRepo)
 	userHandler := handlers.NewUserHandler(c, userService)
-	balanceOperationRepo, err := repository.NewBalanceOperationRepository(cxt, c)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(cxt, c, storage)
 	require.NoError(t, err)
 	balanceOperationService := usecase.NewBalanceOperationService(c, balanceOperationRepo)
 	balanceOperationhandler := handlers.NewBalanceOperationHandler(c, balanceOperationService, userService)
-	securityMiddleware := middleware.NewSecurityMiddleware(userService)
+	securityMiddleware := middleware.NewSecurityMiddleware(userService, newAPIKeyService(t, c))
 	handler := securityMiddleware.SecurityMiddleware(http.HandlerFunc(balanceOperationhandler.CreateOrderHandler))
 	tests := []struct {
 		name           string
@@ -286,15 +423,15 @@ func TestCreateOrderHandler(t *testing.T) {
 
 func TestGetOrdersHandler(t *testing.T) {
 	cxt := context.Background()
-	userRepo, err := repository.NewUserRepository(cxt, c)
+	userRepo, err := repository.NewUserRepository(cxt, c, storage)
 	require.NoError(t, err)
-	userService := usecase.NewUserService(c, userRepo)
+	userService := newUserService(t, c, userRepo)
 	userHandler := handlers.NewUserHandler(c, userService)
-	balanceOperationRepo, err := repository.NewBalanceOperationRepository(cxt, c)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(cxt, c, storage)
 	require.NoError(t, err)
 	balanceOperationService := usecase.NewBalanceOperationService(c, balanceOperationRepo)
 	balanceOperationhandler := handlers.NewBalanceOperationHandler(c, balanceOperationService, userService)
-	securityMiddleware := middleware.NewSecurityMiddleware(userService)
+	securityMiddleware := middleware.NewSecurityMiddleware(userService, newAPIKeyService(t, c))
 	handler := securityMiddleware.SecurityMiddleware(http.HandlerFunc(balanceOperationhandler.GetOrdersHandler))
 	tests := []struct {
 		name           string
@@ -341,15 +478,15 @@ func TestGetOrdersHandler(t *testing.T) {
 
 func TestGetBalanceHandler(t *testing.T) {
 	cxt := context.Background()
-	userRepo, err := repository.NewUserRepository(cxt, c)
+	userRepo, err := repository.NewUserRepository(cxt, c, storage)
 	require.NoError(t, err)
-	userService := usecase.NewUserService(c, userRepo)
+	userService := newUserService(t, c, userRepo)
 	userHandler := handlers.NewUserHandler(c, userService)
-	balanceOperationRepo, err := repository.NewBalanceOperationRepository(cxt, c)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(cxt, c, storage)
 	require.NoError(t, err)
 	balanceOperationService := usecase.NewBalanceOperationService(c, balanceOperationRepo)
 	balanceOperationhandler := handlers.NewBalanceOperationHandler(c, balanceOperationService, userService)
-	securityMiddleware := middleware.NewSecurityMiddleware(userService)
+	securityMiddleware := middleware.NewSecurityMiddleware(userService, newAPIKeyService(t, c))
 	handler := securityMiddleware.SecurityMiddleware(http.HandlerFunc(balanceOperationhandler.GetBalanceHandler))
 	tests := []struct {
 		name           string
@@ -394,31 +531,28 @@ func TestGetBalanceHandler(t *testing.T) {
 	}
 }
 
-func prepareData(context context.Context, config *config.Config) {
-	query := `
-		update "balance_operation"
-		set 
-			status = 'PROCESSED',
-			sum = 20000
-		where "deleted_at" is null
-		and type = 'ACCRUAL'
-		and status = 'NEW';
-	`
-	config.Pool.Exec(context, query)
+func prepareData(ctx context.Context, t *testing.T, balanceOperationRepo repository.BalanceOperationRepository) {
+	orders, err := balanceOperationRepo.FindOrdersToProcess(ctx, "test", 1000, time.Minute, nil)
+	require.NoError(t, err)
+	for _, order := range orders {
+		order.Status = entity.PROCESSED
+		order.Sum = entity.NewMoney(200, 0)
+	}
+	require.NoError(t, balanceOperationRepo.UpdateOrders(ctx, orders))
 }
 
 func TestWithdrawHandler(t *testing.T) {
 	cxt := context.Background()
-	prepareData(cxt, c)
-	userRepo, err := repository.NewUserRepository(cxt, c)
+	userRepo, err := repository.NewUserRepository(cxt, c, storage)
 	require.NoError(t, err)
-	userService := usecase.NewUserService(c, userRepo)
+	userService := newUserService(t, c, userRepo)
 	userHandler := handlers.NewUserHandler(c, userService)
-	balanceOperationRepo, err := repository.NewBalanceOperationRepository(cxt, c)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(cxt, c, storage)
 	require.NoError(t, err)
+	prepareData(cxt, t, balanceOperationRepo)
 	balanceOperationService := usecase.NewBalanceOperationService(c, balanceOperationRepo)
 	balanceOperationhandler := handlers.NewBalanceOperationHandler(c, balanceOperationService, userService)
-	securityMiddleware := middleware.NewSecurityMiddleware(userService)
+	securityMiddleware := middleware.NewSecurityMiddleware(userService, newAPIKeyService(t, c))
 	handler := securityMiddleware.SecurityMiddleware(http.HandlerFunc(balanceOperationhandler.WithdrawHandler))
 	tests := []struct {
 		name           string
@@ -470,6 +604,28 @@ func TestWithdrawHandler(t *testing.T) {
 			`,
 			expectedStatus: 422,
 		},
+		{
+			name:  "test#5",
+			token: login("test", "test", userHandler),
+			body: `
+			{
+				"order": "1000000008",
+				"sum": 29.98
+			}
+			`,
+			expectedStatus: 200,
+		},
+		{
+			name:  "test#6",
+			token: login("test", "test", userHandler),
+			body: `
+			{
+				"order": "1000000016",
+				"sum": -1
+			}
+			`,
+			expectedStatus: 400,
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -489,17 +645,53 @@ func TestWithdrawHandler(t *testing.T) {
 	}
 }
 
+func TestBalanceAfterWithdrawHandler(t *testing.T) {
+	cxt := context.Background()
+	userRepo, err := repository.NewUserRepository(cxt, c, storage)
+	require.NoError(t, err)
+	userService := newUserService(t, c, userRepo)
+	userHandler := handlers.NewUserHandler(c, userService)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(cxt, c, storage)
+	require.NoError(t, err)
+	balanceOperationService := usecase.NewBalanceOperationService(c, balanceOperationRepo)
+	balanceOperationhandler := handlers.NewBalanceOperationHandler(c, balanceOperationService, userService)
+	securityMiddleware := middleware.NewSecurityMiddleware(userService, newAPIKeyService(t, c))
+	handler := securityMiddleware.SecurityMiddleware(http.HandlerFunc(balanceOperationhandler.GetBalanceHandler))
+	request := httptest.NewRequest(http.MethodGet, "/api/user/balance", nil)
+	request.AddCookie(&http.Cookie{
+		Name:  "USER_ID",
+		Value: login("test", "test", userHandler),
+	})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+	res := w.Result()
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.JSONEq(t, `{"current": 70.02, "withdrawn": 129.98}`, string(data))
+}
+
+func TestLedgerInvariants(t *testing.T) {
+	ctx := context.Background()
+	ledgerRepo, err := repository.NewLedgerRepository(ctx, c, storage)
+	require.NoError(t, err)
+	violations, err := ledgerRepo.CheckInvariants(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, violations)
+}
+
 func TestGetWithdrawalsHandler(t *testing.T) {
 	cxt := context.Background()
-	userRepo, err := repository.NewUserRepository(cxt, c)
+	userRepo, err := repository.NewUserRepository(cxt, c, storage)
 	require.NoError(t, err)
-	userService := usecase.NewUserService(c, userRepo)
+	userService := newUserService(t, c, userRepo)
 	userHandler := handlers.NewUserHandler(c, userService)
-	balanceOperationRepo, err := repository.NewBalanceOperationRepository(cxt, c)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(cxt, c, storage)
 	require.NoError(t, err)
 	balanceOperationService := usecase.NewBalanceOperationService(c, balanceOperationRepo)
 	balanceOperationhandler := handlers.NewBalanceOperationHandler(c, balanceOperationService, userService)
-	securityMiddleware := middleware.NewSecurityMiddleware(userService)
+	securityMiddleware := middleware.NewSecurityMiddleware(userService, newAPIKeyService(t, c))
 	handler := securityMiddleware.SecurityMiddleware(http.HandlerFunc(balanceOperationhandler.GetWithdrawalsHandler))
 	tests := []struct {
 		name           string
@@ -546,3 +738,1486 @@ func TestGetWithdrawalsHandler(t *testing.T) {
 		})
 	}
 }
+
+// luhnNumber дописывает к префиксу контрольную цифру по алгоритму Луна
+func luhnNumber(prefix int) string {
+	number := strconv.Itoa(prefix)
+	sum := 0
+	for i := len(number) - 1; i >= 0; i-- {
+		num := int(number[i] - '0')
+		if (len(number)-1-i)%2 == 0 {
+			num *= 2
+			if num > 9 {
+				num -= 9
+			}
+		}
+		sum += num
+	}
+	return number + strconv.Itoa((10-sum%10)%10)
+}
+
+func TestConcurrentWithdrawHandler(t *testing.T) {
+	ctx := context.Background()
+	userRepo, err := repository.NewUserRepository(ctx, c, storage)
+	require.NoError(t, err)
+	userService := newUserService(t, c, userRepo)
+	userHandler := handlers.NewUserHandler(c, userService)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(ctx, c, storage)
+	require.NoError(t, err)
+	balanceOperationService := usecase.NewBalanceOperationService(c, balanceOperationRepo)
+	balanceOperationhandler := handlers.NewBalanceOperationHandler(c, balanceOperationService, userService)
+	securityMiddleware := middleware.NewSecurityMiddleware(userService, newAPIKeyService(t, c))
+	handler := securityMiddleware.SecurityMiddleware(http.HandlerFunc(balanceOperationhandler.WithdrawHandler))
+
+	request := httptest.NewRequest(http.MethodPost, "/api/user/register", bytes.NewReader([]byte(`{"login": "concurrent", "password": "concurrent"}`)))
+	w := httptest.NewRecorder()
+	userHandler.RegisterHandler(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+	token := login("concurrent", "concurrent", userHandler)
+	userID, err := userService.GetUserIDFromToken(token)
+	require.NoError(t, err)
+	err = balanceOperationService.CreateNewOrder(ctx, &handlers.CreateOrderRequest{Order: luhnNumber(700000000), UserID: userID})
+	require.NoError(t, err)
+	// по 200 баллов на каждый необработанный заказ
+	prepareData(ctx, t, balanceOperationRepo)
+
+	const requests = 40
+	var wg sync.WaitGroup
+	statuses := make(chan int, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"order": "%s", "sum": 10}`, luhnNumber(800000000+i))
+			request := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", bytes.NewReader([]byte(body)))
+			request.Header.Set("Content-Type", "application/json")
+			request.AddCookie(&http.Cookie{
+				Name:  "USER_ID",
+				Value: token,
+			})
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+			statuses <- w.Code
+		}(i)
+	}
+	wg.Wait()
+	close(statuses)
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	assert.Equal(t, 20, counts[http.StatusOK])
+	assert.Equal(t, 20, counts[http.StatusPaymentRequired])
+
+	balance, err := balanceOperationRepo.GetBalanceByUser(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, entity.Money(0), balance.Current)
+	assert.Equal(t, entity.NewMoney(200, 0), balance.Withdrawn)
+}
+
+func TestIdempotentWithdrawHandler(t *testing.T) {
+	ctx := context.Background()
+	userRepo, err := repository.NewUserRepository(ctx, c, storage)
+	require.NoError(t, err)
+	userService := newUserService(t, c, userRepo)
+	userHandler := handlers.NewUserHandler(c, userService)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(ctx, c, storage)
+	require.NoError(t, err)
+	balanceOperationService := usecase.NewBalanceOperationService(c, balanceOperationRepo)
+	balanceOperationhandler := handlers.NewBalanceOperationHandler(c, balanceOperationService, userService)
+	idempotencyRepo, err := repository.NewIdempotencyRepository(ctx, c, storage)
+	require.NoError(t, err)
+	idempotencyMiddleware := middleware.NewIdempotencyMiddleware(usecase.NewIdempotencyService(c, idempotencyRepo), kitlog.NewNopLogger())
+	securityMiddleware := middleware.NewSecurityMiddleware(userService, newAPIKeyService(t, c))
+	handler := securityMiddleware.SecurityMiddleware(idempotencyMiddleware.IdempotencyMiddleware(http.HandlerFunc(balanceOperationhandler.WithdrawHandler)))
+	token := login("test", "test", userHandler)
+	userID, err := userService.GetUserIDFromToken(token)
+	require.NoError(t, err)
+	before, err := balanceOperationRepo.GetBalanceByUser(ctx, userID)
+	require.NoError(t, err)
+	tests := []struct {
+		name           string
+		key            string
+		body           string
+		expectedStatus int
+		replayed       bool
+	}{
+		{
+			name:           "test#1",
+			key:            "withdraw-1",
+			body:           `{"order": "1000000024", "sum": 10}`,
+			expectedStatus: 200,
+		},
+		{
+			name:           "test#2",
+			key:            "withdraw-1",
+			body:           `{"order": "1000000024", "sum": 10}`,
+			expectedStatus: 200,
+			replayed:       true,
+		},
+		{
+			name:           "test#3",
+			key:            "withdraw-1",
+			body:           `{"order": "1000000032", "sum": 10}`,
+			expectedStatus: 422,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", bytes.NewReader([]byte(test.body)))
+			request.Header.Set("Content-Type", "application/json")
+			request.Header.Set(middleware.IdempotencyKeyHeader, test.key)
+			request.AddCookie(&http.Cookie{
+				Name:  "USER_ID",
+				Value: token,
+			})
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, test.expectedStatus, res.StatusCode)
+			assert.Equal(t, test.replayed, res.Header.Get("Idempotent-Replayed") == "true")
+		})
+	}
+	after, err := balanceOperationRepo.GetBalanceByUser(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, before.Current-entity.NewMoney(10, 0), after.Current)
+}
+
+func TestIdempotencyAbandonedRequest(t *testing.T) {
+	ctx := context.Background()
+	conf := *c
+	conf.IdempotencyLockTTL = 50 * time.Millisecond
+	userRepo, err := repository.NewUserRepository(ctx, &conf, storage)
+	require.NoError(t, err)
+	tokens, err := newUserService(t, &conf, userRepo).RegisterUser(ctx, &handlers.RegisterRequest{Login: "idempotency-abandoned", Password: "idempotency-pass"})
+	require.NoError(t, err)
+	userID, err := newUserService(t, &conf, userRepo).GetUserIDFromToken(tokens.AccessToken)
+	require.NoError(t, err)
+	idempotencyRepo, err := repository.NewIdempotencyRepository(ctx, &conf, storage)
+	require.NoError(t, err)
+	idempotencyService := usecase.NewIdempotencyService(&conf, idempotencyRepo)
+	body := []byte(`{"order": "1000000024", "sum": 10}`)
+
+	// запрос занял ключ, но ответ так и не сохранился (например, процесс упал)
+	stale, reserved, err := idempotencyService.BeginRequest(ctx, userID, "abandoned", http.MethodPost, "/api/user/balance/withdraw", body)
+	require.NoError(t, err)
+	require.True(t, reserved)
+	_, _, err = idempotencyService.BeginRequest(ctx, userID, "abandoned", http.MethodPost, "/api/user/balance/withdraw", body)
+	customErr := &customerr.CustomError{}
+	require.ErrorAs(t, err, &customErr)
+	assert.Equal(t, customerr.CodeIdempotencyRequestInFlight, customErr.Code)
+
+	time.Sleep(2 * conf.IdempotencyLockTTL)
+	retry, reserved, err := idempotencyService.BeginRequest(ctx, userID, "abandoned", http.MethodPost, "/api/user/balance/withdraw", body)
+	require.NoError(t, err)
+	require.True(t, reserved)
+
+	// брошенный запрос проснулся: он не должен ни освободить, ни перезаписать ключ повтора
+	assert.NoError(t, idempotencyService.FinishRequest(ctx, stale, http.StatusInternalServerError, "", nil))
+	assert.Error(t, idempotencyService.FinishRequest(ctx, stale, http.StatusOK, "text/plain", []byte("stale")))
+	_, _, err = idempotencyService.BeginRequest(ctx, userID, "abandoned", http.MethodPost, "/api/user/balance/withdraw", body)
+	require.ErrorAs(t, err, &customErr)
+	assert.Equal(t, customerr.CodeIdempotencyRequestInFlight, customErr.Code)
+
+	require.NoError(t, idempotencyService.FinishRequest(ctx, retry, http.StatusOK, "text/plain", []byte("retry")))
+	saved, reserved, err := idempotencyService.BeginRequest(ctx, userID, "abandoned", http.MethodPost, "/api/user/balance/withdraw", body)
+	require.NoError(t, err)
+	assert.False(t, reserved)
+	assert.Equal(t, []byte("retry"), saved.Body)
+}
+
+func TestStartGracefulShutdown(t *testing.T) {
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer accrual.Close()
+	freeAddress := func() string {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+		return listener.Addr().String()
+	}
+	address := freeAddress()
+	metricsAddress := freeAddress()
+
+	conf := *c
+	conf.RunAddress = address
+	conf.MetricsAddress = metricsAddress
+	conf.AcrualSystemAddress = accrual.URL
+	conf.ShutdownTimeout = 5 * time.Second
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- Start(ctx, &conf, storage)
+	}()
+	require.Eventually(t, func() bool {
+		res, err := http.Get("http://" + address + "/api/user/balance")
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+		return res.StatusCode == http.StatusUnauthorized
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// метрики только на своём адресе, публичный роутер их не отдаёт
+	res, err := http.Get("http://" + address + "/metrics")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	require.Eventually(t, func() bool {
+		res, err := http.Get("http://" + metricsAddress + "/metrics")
+		if err != nil {
+			return false
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		return err == nil && res.StatusCode == http.StatusOK && strings.Contains(string(body), `gophermart_orders{status="NEW"}`)
+	}, 5*time.Second, 50*time.Millisecond)
+
+	cancel()
+	select {
+	case err = <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+	_, err = http.Get("http://" + address + "/api/user/balance")
+	assert.Error(t, err)
+	_, err = http.Get("http://" + metricsAddress + "/metrics")
+	assert.Error(t, err)
+}
+
+func TestStartMetricsAddressInUse(t *testing.T) {
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer accrual.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	// адрес метрик занят, например, вторым экземпляром на том же хосте
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer busy.Close()
+
+	conf := *c
+	conf.RunAddress = address
+	conf.MetricsAddress = busy.Addr().String()
+	conf.AcrualSystemAddress = accrual.URL
+	conf.ShutdownTimeout = 5 * time.Second
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- Start(ctx, &conf, storage)
+	}()
+	require.Eventually(t, func() bool {
+		res, err := http.Get("http://" + address + "/api/user/balance")
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+		return res.StatusCode == http.StatusUnauthorized
+	}, 5*time.Second, 50*time.Millisecond)
+
+	cancel()
+	select {
+	case err = <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	userRepo, err := repository.NewUserRepository(ctx, c, storage)
+	require.NoError(t, err)
+	userHandler := handlers.NewUserHandler(c, newUserService(t, c, userRepo))
+	request := httptest.NewRequest(http.MethodPost, "/api/user/register", bytes.NewReader([]byte(`{"login": "rotation", "password": "rotation"}`)))
+	userHandler.RegisterHandler(httptest.NewRecorder(), request)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	keys := []config.JWTKey{
+		{ID: "old", Algorithm: config.JWTAlgorithmHS256, Secret: []byte("old-secret-old-secret-old-secret!")},
+		{ID: "new", Algorithm: config.JWTAlgorithmEdDSA, PrivateKey: edPrivate, PublicKey: edPublic},
+		{ID: "rsa", Algorithm: config.JWTAlgorithmRS256, PrivateKey: rsaKey, PublicKey: &rsaKey.PublicKey},
+	}
+	serviceWithKeys := func(signingKeyID string, keys []config.JWTKey) *usecase.UserService {
+		conf := *c
+		conf.JWTKeys = keys
+		conf.JWTSigningKeyID = signingKeyID
+		return newUserService(t, &conf, userRepo)
+	}
+	loginWith := func(s *usecase.UserService) string {
+		tokens, err := s.LoginUser(ctx, &handlers.LoginRequest{Login: "rotation", Password: "rotation"})
+		require.NoError(t, err)
+		return tokens.AccessToken
+	}
+
+	oldToken := loginWith(serviceWithKeys("old", keys))
+	rotated := serviceWithKeys("new", keys)
+	newToken := loginWith(rotated)
+	rsaToken := loginWith(serviceWithKeys("rsa", keys))
+	for _, token := range []string{oldToken, newToken, rsaToken} {
+		userID, err := rotated.GetUserIDFromToken(token)
+		require.NoError(t, err)
+		assert.NotZero(t, userID)
+	}
+
+	// после удаления старого ключа выпущенные им токены перестают приниматься
+	retired := serviceWithKeys("new", keys[1:])
+	_, err = retired.GetUserIDFromToken(oldToken)
+	assert.Error(t, err)
+	_, err = retired.GetUserIDFromToken(newToken)
+	assert.NoError(t, err)
+
+	// токен HS256 с kid асимметричного ключа не принимается
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{UserID: 1})
+	forged.Header["kid"] = "rsa"
+	forgedString, err := forged.SignedString([]byte("old-secret-old-secret-old-secret!"))
+	require.NoError(t, err)
+	_, err = rotated.GetUserIDFromToken(forgedString)
+	assert.Error(t, err)
+}
+
+func TestRefreshAndLogoutHandler(t *testing.T) {
+	ctx := context.Background()
+	userRepo, err := repository.NewUserRepository(ctx, c, storage)
+	require.NoError(t, err)
+	userService := newUserService(t, c, userRepo)
+	userHandler := handlers.NewUserHandler(c, userService)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(ctx, c, storage)
+	require.NoError(t, err)
+	balanceOperationhandler := handlers.NewBalanceOperationHandler(c, usecase.NewBalanceOperationService(c, balanceOperationRepo), userService)
+	securityMiddleware := middleware.NewSecurityMiddleware(userService, newAPIKeyService(t, c))
+	balanceHandler := securityMiddleware.SecurityMiddleware(http.HandlerFunc(balanceOperationhandler.GetBalanceHandler))
+	logoutHandler := securityMiddleware.SecurityMiddleware(http.HandlerFunc(userHandler.LogoutHandler))
+
+	request := httptest.NewRequest(http.MethodPost, "/api/user/register", bytes.NewReader([]byte(`{"login": "session", "password": "session"}`)))
+	w := httptest.NewRecorder()
+	userHandler.RegisterHandler(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	cookies := func(w *httptest.ResponseRecorder) (string, string) {
+		var access, refresh string
+		for _, cookie := range w.Result().Cookies() {
+			switch cookie.Name {
+			case handlers.AccessTokenCookie:
+				access = cookie.Value
+			case handlers.RefreshTokenCookie:
+				refresh = cookie.Value
+			}
+		}
+		return access, refresh
+	}
+	call := func(h http.Handler, method string, path string, access string, refresh string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, nil)
+		if access != "" {
+			request.AddCookie(&http.Cookie{Name: handlers.AccessTokenCookie, Value: access})
+		}
+		if refresh != "" {
+			request.AddCookie(&http.Cookie{Name: handlers.RefreshTokenCookie, Value: refresh})
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request)
+		return w
+	}
+	refreshHandler := http.HandlerFunc(userHandler.RefreshHandler)
+
+	access, refresh := cookies(w)
+	require.NotEmpty(t, access)
+	require.NotEmpty(t, refresh)
+	assert.Equal(t, http.StatusOK, call(balanceHandler, http.MethodGet, "/api/user/balance", access, "").Code)
+
+	w = call(refreshHandler, http.MethodPost, "/api/user/token/refresh", "", refresh)
+	require.Equal(t, http.StatusOK, w.Code)
+	rotatedAccess, rotatedRefresh := cookies(w)
+	assert.NotEqual(t, refresh, rotatedRefresh)
+	assert.Equal(t, http.StatusOK, call(balanceHandler, http.MethodGet, "/api/user/balance", rotatedAccess, "").Code)
+
+	// повторное использование отозванного refresh-токена завершает всю сессию
+	assert.Equal(t, http.StatusUnauthorized, call(refreshHandler, http.MethodPost, "/api/user/token/refresh", "", refresh).Code)
+	assert.Equal(t, http.StatusUnauthorized, call(balanceHandler, http.MethodGet, "/api/user/balance", rotatedAccess, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, call(refreshHandler, http.MethodPost, "/api/user/token/refresh", "", rotatedRefresh).Code)
+
+	tokens, err := userService.LoginUser(ctx, &handlers.LoginRequest{Login: "session", Password: "session"})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, call(logoutHandler, http.MethodPost, "/api/user/logout", tokens.AccessToken, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, call(balanceHandler, http.MethodGet, "/api/user/balance", tokens.AccessToken, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, call(refreshHandler, http.MethodPost, "/api/user/token/refresh", "", tokens.RefreshToken).Code)
+	assert.Equal(t, http.StatusUnauthorized, call(refreshHandler, http.MethodPost, "/api/user/token/refresh", "", "").Code)
+
+	// API-клиент передаёт refresh-токен в теле запроса и получает новую пару токенов в теле ответа
+	tokens, err = userService.LoginUser(ctx, &handlers.LoginRequest{Login: "session", Password: "session"})
+	require.NoError(t, err)
+	request = httptest.NewRequest(http.MethodPost, "/api/user/token/refresh", bytes.NewReader([]byte(`{"refresh_token": "`+tokens.RefreshToken+`"}`)))
+	w = httptest.NewRecorder()
+	refreshHandler.ServeHTTP(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+	var refreshed handlers.RefreshResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &refreshed))
+	assert.NotEqual(t, tokens.RefreshToken, refreshed.RefreshToken)
+	assert.NotEmpty(t, refreshed.RefreshTokenExpiresAt)
+	bodyAccess, bodyRefresh := cookies(w)
+	assert.Equal(t, refreshed.AccessToken, bodyAccess)
+	assert.Equal(t, refreshed.RefreshToken, bodyRefresh)
+	assert.Equal(t, http.StatusOK, call(balanceHandler, http.MethodGet, "/api/user/balance", refreshed.AccessToken, "").Code)
+	request = httptest.NewRequest(http.MethodPost, "/api/user/token/refresh", bytes.NewReader([]byte(`{"refresh_token": 1}`)))
+	w = httptest.NewRecorder()
+	refreshHandler.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSessionCookieAndBearerToken(t *testing.T) {
+	ctx := context.Background()
+	conf := *c
+	conf.CookieSecure = true
+	conf.CookieSameSite = config.SameSiteStrict
+	conf.AccessTokenTTL = 10 * time.Minute
+	userRepo, err := repository.NewUserRepository(ctx, &conf, storage)
+	require.NoError(t, err)
+	userService := newUserService(t, &conf, userRepo)
+	userHandler := handlers.NewUserHandler(&conf, userService)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(ctx, &conf, storage)
+	require.NoError(t, err)
+	balanceOperationhandler := handlers.NewBalanceOperationHandler(&conf, usecase.NewBalanceOperationService(&conf, balanceOperationRepo), userService)
+	handler := middleware.NewSecurityMiddleware(userService, newAPIKeyService(t, c)).SecurityMiddleware(http.HandlerFunc(balanceOperationhandler.GetBalanceHandler))
+
+	request := httptest.NewRequest(http.MethodPost, "/api/user/register", bytes.NewReader([]byte(`{"login": "bearer", "password": "bearer"}`)))
+	w := httptest.NewRecorder()
+	userHandler.RegisterHandler(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+	res := w.Result()
+	defer res.Body.Close()
+
+	var access *http.Cookie
+	for _, cookie := range res.Cookies() {
+		assert.True(t, cookie.HttpOnly, cookie.Name)
+		assert.True(t, cookie.Secure, cookie.Name)
+		assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite, cookie.Name)
+		if cookie.Name == handlers.AccessTokenCookie {
+			access = cookie
+		}
+	}
+	require.NotNil(t, access)
+	assert.Equal(t, "/", access.Path)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), access.Expires, time.Minute)
+	assert.Equal(t, "Bearer "+access.Value, res.Header.Get("Authorization"))
+
+	tests := []struct {
+		name           string
+		authorization  string
+		expectedStatus int
+	}{
+		{name: "bearer", authorization: "Bearer " + access.Value, expectedStatus: http.StatusOK},
+		{name: "lowercase scheme", authorization: "bearer " + access.Value, expectedStatus: http.StatusOK},
+		{name: "basic scheme", authorization: "Basic " + access.Value, expectedStatus: http.StatusUnauthorized},
+		{name: "forged token", authorization: "Bearer " + generateTokenToCheat(t), expectedStatus: http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/api/user/balance", nil)
+			request.Header.Set("Authorization", test.authorization)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+			assert.Equal(t, test.expectedStatus, w.Code)
+		})
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	ctx := context.Background()
+	conf := *c
+	conf.LoginMaxFailures = 3
+	conf.LoginIPMaxFailures = 10
+	conf.LoginLockout = time.Minute
+	userRepo, err := repository.NewUserRepository(ctx, &conf, storage)
+	require.NoError(t, err)
+	userHandler := handlers.NewUserHandler(&conf, newUserService(t, &conf, userRepo))
+	login := func(ip string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/api/user/login", bytes.NewReader([]byte(body)))
+		request.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		userHandler.LoginHandler(w, request)
+		return w
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/api/user/register", bytes.NewReader([]byte(`{"login": "lockout", "password": "lockout"}`)))
+	w := httptest.NewRecorder()
+	userHandler.RegisterHandler(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// успешный вход сбрасывает счётчик логина
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusUnauthorized, login("198.51.100.1", `{"login": "lockout", "password": "wrong"}`).Code)
+	}
+	assert.Equal(t, http.StatusOK, login("198.51.100.1", `{"login": "lockout", "password": "lockout"}`).Code)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusUnauthorized, login("198.51.100.1", `{"login": "lockout", "password": "wrong"}`).Code)
+	}
+	// блокировка проверяется до пароля, поэтому верный пароль тоже получает 429
+	w = login("198.51.100.2", `{"login": "lockout", "password": "lockout"}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	// перебор логинов с одного адреса блокирует сам адрес
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, http.StatusUnauthorized, login("198.51.100.3", fmt.Sprintf(`{"login": "ghost%d", "password": "ghost"}`, i)).Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, login("198.51.100.3", `{"login": "ghost", "password": "ghost"}`).Code)
+	assert.Equal(t, http.StatusUnauthorized, login("198.51.100.4", `{"login": "ghost", "password": "ghost"}`).Code)
+}
+
+func TestChangePasswordAndDeleteUserHandler(t *testing.T) {
+	ctx := context.Background()
+	userRepo, err := repository.NewUserRepository(ctx, c, storage)
+	require.NoError(t, err)
+	userService := newUserService(t, c, userRepo)
+	userHandler := handlers.NewUserHandler(c, userService)
+	securityMiddleware := middleware.NewSecurityMiddleware(userService, newAPIKeyService(t, c))
+	changePasswordHandler := securityMiddleware.SecurityMiddleware(http.HandlerFunc(userHandler.ChangePasswordHandler))
+	deleteUserHandler := securityMiddleware.SecurityMiddleware(http.HandlerFunc(userHandler.DeleteUserHandler))
+	call := func(h http.Handler, method string, access string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, "/api/user/password", bytes.NewReader([]byte(body)))
+		request.Header.Set("Authorization", "Bearer "+access)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request)
+		return w
+	}
+
+	first, err := userService.RegisterUser(ctx, &handlers.RegisterRequest{Login: "change", Password: "change"})
+	require.NoError(t, err)
+	second, err := userService.LoginUser(ctx, &handlers.LoginRequest{Login: "change", Password: "change"})
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusBadRequest, call(changePasswordHandler, http.MethodPut, first.AccessToken, `{"new_password": "changed"}`).Code)
+	assert.Equal(t, http.StatusForbidden, call(changePasswordHandler, http.MethodPut, first.AccessToken, `{"old_password": "wrong", "new_password": "changed"}`).Code)
+	assert.Equal(t, http.StatusOK, call(changePasswordHandler, http.MethodPut, first.AccessToken, `{"old_password": "change", "new_password": "changed"}`).Code)
+
+	// смена пароля завершает остальные сессии, текущая продолжает работать
+	_, _, err = userService.Authenticate(ctx, second.AccessToken)
+	assert.Error(t, err)
+	_, _, err = userService.Authenticate(ctx, first.AccessToken)
+	assert.NoError(t, err)
+	_, err = userService.LoginUser(ctx, &handlers.LoginRequest{Login: "change", Password: "change"})
+	assert.Error(t, err)
+	third, err := userService.LoginUser(ctx, &handlers.LoginRequest{Login: "change", Password: "changed"})
+	require.NoError(t, err)
+
+	w := call(deleteUserHandler, http.MethodDelete, first.AccessToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	for _, cookie := range w.Result().Cookies() {
+		assert.Negative(t, cookie.MaxAge, cookie.Name)
+	}
+	assert.Equal(t, http.StatusUnauthorized, call(deleteUserHandler, http.MethodDelete, first.AccessToken, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, call(changePasswordHandler, http.MethodPut, third.AccessToken, `{"old_password": "changed", "new_password": "again"}`).Code)
+	_, err = userService.LoginUser(ctx, &handlers.LoginRequest{Login: "change", Password: "changed"})
+	assert.Error(t, err)
+	_, err = userService.RefreshTokens(ctx, third.RefreshToken)
+	assert.Error(t, err)
+
+	// после удаления логин снова свободен
+	_, err = userService.RegisterUser(ctx, &handlers.RegisterRequest{Login: "change", Password: "change"})
+	assert.NoError(t, err)
+}
+
+func TestPasswordResetHandler(t *testing.T) {
+	ctx := context.Background()
+	userRepo, err := repository.NewUserRepository(ctx, c, storage)
+	require.NoError(t, err)
+	userService := newUserService(t, c, userRepo)
+	userHandler := handlers.NewUserHandler(c, userService)
+	call := func(h http.HandlerFunc, body string) int {
+		request := httptest.NewRequest(http.MethodPost, "/api/user/password/reset", bytes.NewReader([]byte(body)))
+		w := httptest.NewRecorder()
+		h(w, request)
+		return w.Code
+	}
+
+	session, err := userService.RegisterUser(ctx, &handlers.RegisterRequest{Login: "reset", Password: "reset"})
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusAccepted, call(userHandler.PasswordResetHandler, `{"login": "nobody"}`))
+	assert.Nil(t, notifications.last("nobody"))
+	require.Equal(t, http.StatusAccepted, call(userHandler.PasswordResetHandler, `{"login": "reset"}`))
+	message := notifications.last("reset")
+	require.NotNil(t, message)
+	_, token, ok := strings.Cut(message.Body, "token: ")
+	require.True(t, ok)
+	token, _, _ = strings.Cut(token, ".")
+
+	assert.Equal(t, http.StatusBadRequest, call(userHandler.PasswordResetConfirmHandler, `{"token": "forged", "password": "renewed"}`))
+	assert.Equal(t, http.StatusOK, call(userHandler.PasswordResetConfirmHandler, fmt.Sprintf(`{"token": %q, "password": "renewed"}`, token)))
+	assert.Equal(t, http.StatusBadRequest, call(userHandler.PasswordResetConfirmHandler, fmt.Sprintf(`{"token": %q, "password": "again"}`, token)), "reset token is single-use")
+
+	_, _, err = userService.Authenticate(ctx, session.AccessToken)
+	assert.Error(t, err)
+	_, err = userService.LoginUser(ctx, &handlers.LoginRequest{Login: "reset", Password: "renewed"})
+	assert.NoError(t, err)
+}
+
+func TestPasswordResetRateLimit(t *testing.T) {
+	ctx := context.Background()
+	conf := *c
+	conf.LoginMaxFailures = 2
+	conf.LoginIPMaxFailures = 3
+	userRepo, err := repository.NewUserRepository(ctx, &conf, storage)
+	require.NoError(t, err)
+	userService := newUserService(t, &conf, userRepo)
+	userHandler := handlers.NewUserHandler(&conf, userService)
+	_, err = userService.RegisterUser(ctx, &handlers.RegisterRequest{Login: "reset-flood", Password: "reset-flood"})
+	require.NoError(t, err)
+	reset := func(ip string, login string) int {
+		request := httptest.NewRequest(http.MethodPost, "/api/user/password/reset", strings.NewReader(`{"login": "`+login+`"}`))
+		request.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		userHandler.PasswordResetHandler(w, request)
+		return w.Code
+	}
+
+	// лимит по логину не зависит от адреса
+	assert.Equal(t, http.StatusAccepted, reset("198.51.100.20", "reset-flood"))
+	assert.Equal(t, http.StatusAccepted, reset("198.51.100.21", "reset-flood"))
+	assert.Equal(t, http.StatusTooManyRequests, reset("198.51.100.22", "reset-flood"))
+
+	// лимит по адресу действует и для неизвестных логинов
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusAccepted, reset("198.51.100.30", fmt.Sprintf("reset-nobody-%d", i)))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, reset("198.51.100.30", "reset-nobody-3"))
+}
+
+func TestPasswordPolicyAndRehash(t *testing.T) {
+	ctx := context.Background()
+	conf := *c
+	conf.PasswordMinLength = 10
+	conf.PasswordMinClasses = 3
+	conf.PasswordDenyCommon = true
+	conf.PasswordDenyLogin = true
+	conf.PasswordDenied = []string{"Gophermart2024!"}
+	userRepo, err := repository.NewUserRepository(ctx, &conf, storage)
+	require.NoError(t, err)
+	userHandler := handlers.NewUserHandler(&conf, newUserService(t, &conf, userRepo))
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{name: "too short", body: `{"login": "policy", "password": "Ab1!"}`, expectedStatus: http.StatusBadRequest},
+		{name: "one class", body: `{"login": "policy", "password": "abcdefghijkl"}`, expectedStatus: http.StatusBadRequest},
+		{name: "common", body: `{"login": "policy", "password": "Password123"}`, expectedStatus: http.StatusBadRequest},
+		{name: "deny list", body: `{"login": "policy", "password": "gophermart2024!"}`, expectedStatus: http.StatusBadRequest},
+		{name: "same as login", body: `{"login": "Policy-Check1", "password": "policy-check1"}`, expectedStatus: http.StatusBadRequest},
+		{name: "strong", body: `{"login": "policy", "password": "Correct-Horse-7"}`, expectedStatus: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/api/user/register", bytes.NewReader([]byte(test.body)))
+			w := httptest.NewRecorder()
+			userHandler.RegisterHandler(w, request)
+			assert.Equal(t, test.expectedStatus, w.Code)
+		})
+	}
+
+	// вход с устаревшими параметрами хэша пересчитывает его под текущие
+	user, err := userRepo.FindByLogin(ctx, "policy")
+	require.NoError(t, err)
+	cost, err := bcrypt.Cost([]byte(user.Password))
+	require.NoError(t, err)
+	assert.Equal(t, bcrypt.MinCost, cost)
+
+	conf.BcryptCost = bcrypt.MinCost + 1
+	_, err = newUserService(t, &conf, userRepo).LoginUser(ctx, &handlers.LoginRequest{Login: "policy", Password: "Correct-Horse-7"})
+	require.NoError(t, err)
+	user, err = userRepo.FindByLogin(ctx, "policy")
+	require.NoError(t, err)
+	cost, err = bcrypt.Cost([]byte(user.Password))
+	require.NoError(t, err)
+	assert.Equal(t, bcrypt.MinCost+1, cost)
+
+	conf.PasswordHash = config.PasswordHashArgon2id
+	conf.Argon2Memory = 1024
+	argon2Service := newUserService(t, &conf, userRepo)
+	_, err = argon2Service.LoginUser(ctx, &handlers.LoginRequest{Login: "policy", Password: "Correct-Horse-7"})
+	require.NoError(t, err)
+	user, err = userRepo.FindByLogin(ctx, "policy")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(user.Password, "$argon2id$v=19$m=1024,"), user.Password)
+
+	_, err = argon2Service.LoginUser(ctx, &handlers.LoginRequest{Login: "policy", Password: "Correct-Horse-7"})
+	assert.NoError(t, err)
+	_, err = argon2Service.LoginUser(ctx, &handlers.LoginRequest{Login: "policy", Password: "Correct-Horse-8"})
+	assert.Error(t, err)
+}
+
+func TestAdminAPI(t *testing.T) {
+	ctx := context.Background()
+	userRepo, err := repository.NewUserRepository(ctx, c, storage)
+	require.NoError(t, err)
+	userService := newUserService(t, c, userRepo)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(ctx, c, storage)
+	require.NoError(t, err)
+	balanceOperationService := usecase.NewBalanceOperationService(c, balanceOperationRepo)
+	refreshTokenRepo, err := repository.NewRefreshTokenRepository(ctx, c, storage)
+	require.NoError(t, err)
+	adminRepo, err := repository.NewAdminRepository(ctx, c, storage)
+	require.NoError(t, err)
+	adminService := usecase.NewAdminService(c, adminRepo, balanceOperationService, refreshTokenRepo)
+	router := newRouter(t, c, userService, balanceOperationService, adminService)
+	call := func(method string, path string, token string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		request.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w
+	}
+
+	admin, err := userService.RegisterUser(ctx, &handlers.RegisterRequest{Login: "admin-root", Password: "admin-root"})
+	require.NoError(t, err)
+	customer, err := userService.RegisterUser(ctx, &handlers.RegisterRequest{Login: "customer", Password: "customer"})
+	require.NoError(t, err)
+	customerUser, err := userRepo.FindByLogin(ctx, "customer")
+	require.NoError(t, err)
+	userPath := "/api/admin/users/" + strconv.Itoa(customerUser.ID)
+
+	assert.Equal(t, http.StatusForbidden, call(http.MethodGet, "/api/admin/users", admin.AccessToken, "").Code)
+	require.NoError(t, adminService.SetRole(ctx, "admin-root", entity.RoleAdmin))
+	assert.Equal(t, http.StatusForbidden, call(http.MethodGet, "/api/admin/users", customer.AccessToken, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/api/admin/users", "", "").Code)
+
+	w := call(http.MethodGet, "/api/admin/users", admin.AccessToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"login":"customer","role":"USER","blocked":false`)
+
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, userPath+"/balance/adjust", admin.AccessToken, `{"amount": 100.5, "reason": "goodwill"}`).Code)
+	assert.Equal(t, http.StatusConflict, call(http.MethodPost, userPath+"/balance/adjust", admin.AccessToken, `{"amount": -150, "reason": "chargeback"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, userPath+"/balance/adjust", admin.AccessToken, `{"amount": 10}`).Code)
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, userPath+"/balance/adjust", admin.AccessToken, `{"amount": 0, "reason": "noop"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, userPath+"/balance/adjust", admin.AccessToken, `{"amount": 0.001, "reason": "dust"}`).Code)
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, userPath+"/balance/adjust", admin.AccessToken, `{"amount": -0.5, "reason": "rounding"}`).Code)
+	w = call(http.MethodGet, userPath+"/balance", admin.AccessToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"current": 100, "withdrawn": 0}`, w.Body.String())
+	assert.Equal(t, http.StatusNoContent, call(http.MethodGet, userPath+"/orders", admin.AccessToken, "").Code)
+	assert.Equal(t, http.StatusNotFound, call(http.MethodGet, "/api/admin/users/999999/balance", admin.AccessToken, "").Code)
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodGet, "/api/admin/users/abc/balance", admin.AccessToken, "").Code)
+
+	// блокировка завершает сессии и запрещает вход
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, userPath+"/block", admin.AccessToken, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/api/user/balance", customer.AccessToken, "").Code)
+	_, err = userService.RefreshTokens(ctx, customer.RefreshToken)
+	assert.Error(t, err)
+	request := httptest.NewRequest(http.MethodPost, "/api/user/login", bytes.NewReader([]byte(`{"login": "customer", "password": "customer"}`)))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = call(http.MethodGet, "/api/admin/users", admin.AccessToken, "")
+	assert.Contains(t, w.Body.String(), `"login":"customer","role":"USER","blocked":true`)
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, userPath+"/unblock", admin.AccessToken, "").Code)
+	_, err = userService.LoginUser(ctx, &handlers.LoginRequest{Login: "customer", Password: "customer"})
+	assert.NoError(t, err)
+
+	adminUser, err := userRepo.FindByLogin(ctx, "admin-root")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, call(http.MethodPost, "/api/admin/users/"+strconv.Itoa(adminUser.ID)+"/block", admin.AccessToken, "").Code)
+
+	records, err := adminRepo.FindAuditRecords(ctx, customerUser.ID)
+	require.NoError(t, err)
+	actions := make([]entity.AuditAction, 0, len(records))
+	for _, record := range records {
+		assert.Equal(t, adminUser.ID, record.AdminID)
+		actions = append(actions, record.Action)
+	}
+	assert.Equal(t, []entity.AuditAction{
+		entity.AuditAdjustBalance, entity.AuditAdjustBalance, entity.AuditViewBalance, entity.AuditViewOrders,
+		entity.AuditBlockUser, entity.AuditUnblockUser,
+	}, actions)
+	assert.Equal(t, "100.5: goodwill", records[0].Details)
+	w = call(http.MethodGet, "/api/admin/audit?user_id="+strconv.Itoa(customerUser.ID), admin.AccessToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"action":"BLOCK_USER"`)
+	assert.Contains(t, w.Body.String(), `"action":"VIEW_AUDIT"`)
+	assert.Equal(t, http.StatusNotFound, call(http.MethodGet, "/api/admin/audit?user_id=999999", admin.AccessToken, "").Code)
+	w = call(http.MethodGet, "/api/admin/audit", admin.AccessToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"admin_id":`+strconv.Itoa(adminUser.ID)+`,"action":"VIEW_AUDIT","created_at"`)
+
+	ledgerRepo, err := repository.NewLedgerRepository(ctx, c, storage)
+	require.NoError(t, err)
+	violations, err := ledgerRepo.CheckInvariants(ctx)
+	require.NoError(t, err)
+	for _, violation := range violations {
+		assert.NotEqual(t, customerUser.ID, violation.UserID, violation)
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	userRepo, err := repository.NewUserRepository(ctx, c, storage)
+	require.NoError(t, err)
+	userService := newUserService(t, c, userRepo)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(ctx, c, storage)
+	require.NoError(t, err)
+	balanceOperationService := usecase.NewBalanceOperationService(c, balanceOperationRepo)
+	refreshTokenRepo, err := repository.NewRefreshTokenRepository(ctx, c, storage)
+	require.NoError(t, err)
+	adminRepo, err := repository.NewAdminRepository(ctx, c, storage)
+	require.NoError(t, err)
+	router := newRouter(t, c, userService, balanceOperationService, usecase.NewAdminService(c, adminRepo, balanceOperationService, refreshTokenRepo))
+	call := func(method string, path string, header string, value string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		request.Header.Set(header, value)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w
+	}
+
+	tokens, err := userService.RegisterUser(ctx, &handlers.RegisterRequest{Login: "partner", Password: "partner-pass"})
+	require.NoError(t, err)
+	session := "Bearer " + tokens.AccessToken
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/api/user/api-keys", "Authorization", session, `{"name": "shop", "scopes": ["orders:delete"]}`).Code)
+	// права на списание у ключей нет
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/api/user/api-keys", "Authorization", session, `{"name": "shop", "scopes": ["balance:write"]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/api/user/api-keys", "Authorization", session, `{"name": "shop", "scopes": []}`).Code)
+
+	w := call(http.MethodPost, "/api/user/api-keys", "Authorization", session, `{"name": "shop", "scopes": ["orders:write", "orders:read"]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	created := &handlers.APIKeyResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), created))
+	require.True(t, strings.HasPrefix(created.Prefix, usecase.APIKeyPrefix))
+	require.True(t, strings.HasPrefix(created.Key, created.Prefix+"_"))
+	key := created.Key
+
+	assert.Equal(t, http.StatusAccepted, call(http.MethodPost, "/api/user/orders", middleware.APIKeyHeader, key, luhnNumber(7700)).Code)
+	assert.Equal(t, http.StatusOK, call(http.MethodGet, "/api/user/orders", middleware.APIKeyHeader, key, "").Code)
+	assert.Equal(t, http.StatusForbidden, call(http.MethodGet, "/api/user/balance", middleware.APIKeyHeader, key, "").Code)
+	assert.Equal(t, http.StatusForbidden, call(http.MethodPost, "/api/user/balance/withdraw", middleware.APIKeyHeader, key, `{"order": "2377225624", "sum": 1}`).Code)
+	assert.Equal(t, http.StatusForbidden, call(http.MethodGet, "/api/user/api-keys", middleware.APIKeyHeader, key, "").Code)
+	assert.Equal(t, http.StatusForbidden, call(http.MethodPost, "/api/user/logout", middleware.APIKeyHeader, key, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/api/user/orders", middleware.APIKeyHeader, key+"x", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/api/user/orders", middleware.APIKeyHeader, "not-a-key", "").Code)
+
+	// ключ показывается только при создании
+	w = call(http.MethodGet, "/api/user/api-keys", "Authorization", session, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), key)
+	assert.Contains(t, w.Body.String(), `"prefix":"`+created.Prefix+`"`)
+	assert.Contains(t, w.Body.String(), `"last_used_at"`)
+
+	path := "/api/user/api-keys/" + strconv.Itoa(created.ID)
+	assert.Equal(t, http.StatusOK, call(http.MethodDelete, path, "Authorization", session, "").Code)
+	assert.Equal(t, http.StatusNotFound, call(http.MethodDelete, path, "Authorization", session, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/api/user/orders", middleware.APIKeyHeader, key, "").Code)
+
+	// время использования пишется не чаще SessionTouchInterval
+	apiKeyRepo, err := repository.NewAPIKeyRepository(ctx, c, storage)
+	require.NoError(t, err)
+	w = call(http.MethodPost, "/api/user/api-keys", "Authorization", session, `{"name": "crm", "scopes": ["orders:read"]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), created))
+	partner, err := userRepo.FindByLogin(ctx, "partner")
+	require.NoError(t, err)
+	lastUsedAt := func() time.Time {
+		keys, err := apiKeyRepo.FindByUser(ctx, partner.ID)
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		return keys[0].LastUsedAt
+	}
+	require.Equal(t, http.StatusOK, call(http.MethodGet, "/api/user/orders", middleware.APIKeyHeader, created.Key, "").Code)
+	used := lastUsedAt()
+	require.False(t, used.IsZero())
+	require.Equal(t, http.StatusOK, call(http.MethodGet, "/api/user/orders", middleware.APIKeyHeader, created.Key, "").Code)
+	assert.True(t, used.Equal(lastUsedAt()))
+
+	// смена пароля отзывает ключи
+	assert.Equal(t, http.StatusOK, call(http.MethodPut, "/api/user/password", "Authorization", session, `{"old_password": "partner-pass", "new_password": "partner-pass-2"}`).Code)
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/api/user/orders", middleware.APIKeyHeader, created.Key, "").Code)
+}
+
+// totpAt считает код TOTP так же, как приложение-аутентификатор
+func totpAt(t *testing.T, secret string, at time.Time) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	require.NoError(t, err)
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(at.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+func TestTwoFactor(t *testing.T) {
+	ctx := context.Background()
+	userRepo, err := repository.NewUserRepository(ctx, c, storage)
+	require.NoError(t, err)
+	userService := newUserService(t, c, userRepo)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(ctx, c, storage)
+	require.NoError(t, err)
+	balanceOperationService := usecase.NewBalanceOperationService(c, balanceOperationRepo)
+	refreshTokenRepo, err := repository.NewRefreshTokenRepository(ctx, c, storage)
+	require.NoError(t, err)
+	adminRepo, err := repository.NewAdminRepository(ctx, c, storage)
+	require.NoError(t, err)
+	router := newRouter(t, c, userService, balanceOperationService, usecase.NewAdminService(c, adminRepo, balanceOperationService, refreshTokenRepo))
+	call := func(method string, path string, token string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		request.RemoteAddr = "192.0.2.19:1234"
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w
+	}
+
+	tokens, err := userService.RegisterUser(ctx, &handlers.RegisterRequest{Login: "two-factor", Password: "two-factor-pass"})
+	require.NoError(t, err)
+	user, err := userRepo.FindByLogin(ctx, "two-factor")
+	require.NoError(t, err)
+	require.NoError(t, adminRepo.AdjustBalance(ctx, entity.NewAdjustmentPosting(user.ID, entity.NewMoney(100, 0)), &entity.AuditRecord{AdminID: user.ID, Action: entity.AuditAdjustBalance, UserID: user.ID}))
+
+	// пока 2FA не подтверждена, вход и списание работают как раньше
+	w := call(http.MethodPost, "/api/user/2fa", tokens.AccessToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	setup := &handlers.TwoFactorSetupResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), setup))
+	assert.True(t, strings.HasPrefix(setup.URI, "otpauth://totp/"))
+	assert.Contains(t, setup.URI, "secret="+setup.Secret)
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, "/api/user/login", "", `{"login": "two-factor", "password": "two-factor-pass"}`).Code)
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, "/api/user/balance/withdraw", tokens.AccessToken, `{"order": "`+luhnNumber(1900)+`", "sum": 1}`).Code)
+
+	assert.Equal(t, http.StatusForbidden, call(http.MethodPost, "/api/user/2fa/confirm", tokens.AccessToken, `{"code": "000000"}`).Code)
+	now := time.Now()
+	w = call(http.MethodPost, "/api/user/2fa/confirm", tokens.AccessToken, `{"code": "`+totpAt(t, setup.Secret, now)+`"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	recovery := &handlers.RecoveryCodesResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), recovery))
+	require.Len(t, recovery.RecoveryCodes, usecase.RecoveryCodesCount)
+	assert.Equal(t, http.StatusConflict, call(http.MethodPost, "/api/user/2fa", tokens.AccessToken, "").Code)
+
+	// после пароля выдаётся только токен второго шага, к API он доступа не даёт
+	w = call(http.MethodPost, "/api/user/login", "", `{"login": "two-factor", "password": "two-factor-pass"}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.Empty(t, w.Result().Cookies())
+	challenge := &handlers.TwoFactorChallengeResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), challenge))
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/api/user/balance", challenge.Token, "").Code)
+	loginBody := func(code string) string {
+		return `{"two_factor_token": "` + challenge.Token + `", "code": "` + code + `"}`
+	}
+	// код, которым подтвердили 2FA, повторно не принимается
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodPost, "/api/user/login/2fa", "", loginBody(totpAt(t, setup.Secret, now))).Code)
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodPost, "/api/user/login/2fa", "", `{"two_factor_token": "`+tokens.AccessToken+`", "code": "`+recovery.RecoveryCodes[0]+`"}`).Code)
+	w = call(http.MethodPost, "/api/user/login/2fa", "", loginBody(strings.ToUpper(recovery.RecoveryCodes[0])))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Authorization"))
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodPost, "/api/user/login/2fa", "", loginBody(recovery.RecoveryCodes[0])).Code)
+
+	// списание требует свежий код TOTP, коды восстановления для него не подходят
+	withdraw := func(order int, code string) int {
+		return call(http.MethodPost, "/api/user/balance/withdraw", tokens.AccessToken, `{"order": "`+luhnNumber(order)+`", "sum": 1, "totp_code": "`+code+`"}`).Code
+	}
+	assert.Equal(t, http.StatusForbidden, withdraw(1901, ""))
+	assert.Equal(t, http.StatusForbidden, withdraw(1902, recovery.RecoveryCodes[1]))
+	assert.Equal(t, http.StatusForbidden, withdraw(1903, totpAt(t, setup.Secret, now)))
+	next := totpAt(t, setup.Secret, now.Add(30*time.Second))
+	assert.Equal(t, http.StatusOK, withdraw(1904, next))
+	assert.Equal(t, http.StatusForbidden, withdraw(1905, next))
+
+	assert.Equal(t, http.StatusForbidden, call(http.MethodDelete, "/api/user/2fa", tokens.AccessToken, `{"code": "000000"}`).Code)
+	assert.Equal(t, http.StatusOK, call(http.MethodDelete, "/api/user/2fa", tokens.AccessToken, `{"code": "`+recovery.RecoveryCodes[2]+`"}`).Code)
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, "/api/user/login", "", `{"login": "two-factor", "password": "two-factor-pass"}`).Code)
+}
+
+func TestSessions(t *testing.T) {
+	ctx := context.Background()
+	userRepo, err := repository.NewUserRepository(ctx, c, storage)
+	require.NoError(t, err)
+	userService := newUserService(t, c, userRepo)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(ctx, c, storage)
+	require.NoError(t, err)
+	balanceOperationService := usecase.NewBalanceOperationService(c, balanceOperationRepo)
+	refreshTokenRepo, err := repository.NewRefreshTokenRepository(ctx, c, storage)
+	require.NoError(t, err)
+	adminRepo, err := repository.NewAdminRepository(ctx, c, storage)
+	require.NoError(t, err)
+	router := newRouter(t, c, userService, balanceOperationService, usecase.NewAdminService(c, adminRepo, balanceOperationService, refreshTokenRepo))
+	call := func(method string, path string, token string, userAgent string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		request.RemoteAddr = "198.51.100.20:4321"
+		request.Header.Set("User-Agent", userAgent)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w
+	}
+	accessToken := func(w *httptest.ResponseRecorder) string {
+		require.Equal(t, http.StatusOK, w.Code)
+		return strings.TrimPrefix(w.Header().Get("Authorization"), "Bearer ")
+	}
+	const (
+		desktop = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+		phone   = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"
+	)
+
+	desktopToken := accessToken(call(http.MethodPost, "/api/user/register", "", desktop, `{"login": "sessions", "password": "sessions-pass"}`))
+	phoneToken := accessToken(call(http.MethodPost, "/api/user/login", "", phone, `{"login": "sessions", "password": "sessions-pass"}`))
+	otherToken := accessToken(call(http.MethodPost, "/api/user/register", "", "curl/8.4.0", `{"login": "sessions-other", "password": "sessions-pass"}`))
+
+	w := call(http.MethodGet, "/api/user/sessions", desktopToken, desktop, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var sessions []*handlers.SessionResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sessions))
+	require.Len(t, sessions, 2)
+	devices := map[string]*handlers.SessionResponse{}
+	for _, session := range sessions {
+		devices[session.Device] = session
+		assert.Equal(t, "198.51.100.20", session.IP)
+		assert.NotEmpty(t, session.LastSeenAt)
+	}
+	require.Contains(t, devices, "Chrome on Windows")
+	require.Contains(t, devices, "Safari on iOS")
+	assert.True(t, devices["Chrome on Windows"].Current)
+	assert.False(t, devices["Safari on iOS"].Current)
+	assert.Equal(t, phone, devices["Safari on iOS"].UserAgent)
+
+	phoneSession := "/api/user/sessions/" + devices["Safari on iOS"].ID
+	assert.Equal(t, http.StatusNotFound, call(http.MethodDelete, phoneSession, otherToken, "", "").Code)
+	assert.Equal(t, http.StatusOK, call(http.MethodGet, "/api/user/balance", phoneToken, phone, "").Code)
+	assert.Equal(t, http.StatusOK, call(http.MethodDelete, phoneSession, desktopToken, desktop, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/api/user/balance", phoneToken, phone, "").Code)
+	assert.Equal(t, http.StatusNotFound, call(http.MethodDelete, phoneSession, desktopToken, desktop, "").Code)
+
+	w = call(http.MethodGet, "/api/user/sessions", desktopToken, desktop, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sessions))
+	assert.Len(t, sessions, 1)
+}
+
+func TestOrderAndWithdrawalPagination(t *testing.T) {
+	ctx := context.Background()
+	userRepo, err := repository.NewUserRepository(ctx, c, storage)
+	require.NoError(t, err)
+	userService := newUserService(t, c, userRepo)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(ctx, c, storage)
+	require.NoError(t, err)
+	balanceOperationService := usecase.NewBalanceOperationService(c, balanceOperationRepo)
+	refreshTokenRepo, err := repository.NewRefreshTokenRepository(ctx, c, storage)
+	require.NoError(t, err)
+	adminRepo, err := repository.NewAdminRepository(ctx, c, storage)
+	require.NoError(t, err)
+	router := newRouter(t, c, userService, balanceOperationService, usecase.NewAdminService(c, adminRepo, balanceOperationService, refreshTokenRepo))
+	tokens, err := userService.RegisterUser(ctx, &handlers.RegisterRequest{Login: "pages", Password: "pages-pass"})
+	require.NoError(t, err)
+	user, err := userRepo.FindByLogin(ctx, "pages")
+	require.NoError(t, err)
+	call := func(path string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w
+	}
+	// pages листает список по курсору и возвращает поле key всех записей
+	pages := func(path string, key string) ([]string, int) {
+		result := make([]string, 0)
+		requests := 0
+		for next := path; next != ""; requests++ {
+			w := call(next)
+			require.Equal(t, http.StatusOK, w.Code, next)
+			var items []map[string]any
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &items))
+			for _, item := range items {
+				result = append(result, item[key].(string))
+			}
+			next = ""
+			if cursor := w.Header().Get(handlers.NextCursorHeader); cursor != "" {
+				next = path + "&cursor=" + cursor
+			}
+		}
+		return result, requests
+	}
+
+	orders := make([]string, 5)
+	for i := range orders {
+		orders[i] = luhnNumber(2100 + i)
+		require.NoError(t, balanceOperationService.CreateNewOrder(ctx, &handlers.CreateOrderRequest{Order: orders[i], UserID: user.ID}))
+	}
+	require.NoError(t, adminRepo.AdjustBalance(ctx, entity.NewAdjustmentPosting(user.ID, entity.NewMoney(10, 0)), &entity.AuditRecord{AdminID: user.ID, Action: entity.AuditAdjustBalance, UserID: user.ID}))
+	withdrawals := make([]string, 3)
+	for i := range withdrawals {
+		withdrawals[i] = luhnNumber(2200 + i)
+		require.NoError(t, balanceOperationService.CreateWithdraw(ctx, user.ID, &handlers.WithdrawRequest{Order: withdrawals[i], Sum: entity.NewMoney(1, 0)}))
+	}
+
+	// по умолчанию новые первыми; списания не попадают в список заказов
+	got, requests := pages("/api/user/orders?limit=2", "number")
+	assert.Equal(t, []string{orders[4], orders[3], orders[2], orders[1], orders[0]}, got)
+	assert.Equal(t, 3, requests)
+	got, _ = pages("/api/user/orders?limit=3&sort=asc&status=new,processing", "number")
+	assert.Equal(t, orders, got)
+	got, requests = pages("/api/user/withdrawals?limit=1", "order")
+	assert.Equal(t, []string{withdrawals[2], withdrawals[1], withdrawals[0]}, got)
+	assert.Equal(t, 3, requests)
+
+	assert.Equal(t, http.StatusNoContent, call("/api/user/orders?status=PROCESSED").Code)
+	assert.Equal(t, http.StatusNoContent, call("/api/user/orders?from="+time.Now().Add(time.Hour).UTC().Format(time.RFC3339)).Code)
+	got, _ = pages("/api/user/orders?to="+time.Now().Add(time.Hour).UTC().Format(time.RFC3339), "number")
+	assert.Len(t, got, 5)
+	for _, path := range []string{
+		"/api/user/orders?status=DONE",
+		"/api/user/orders?limit=0",
+		"/api/user/orders?limit=1001",
+		"/api/user/orders?sort=newest",
+		"/api/user/orders?from=yesterday",
+		"/api/user/orders?cursor=not-a-cursor",
+		"/api/user/withdrawals?status=NEW",
+	} {
+		assert.Equal(t, http.StatusBadRequest, call(path).Code, path)
+	}
+}
+
+func TestProblemResponses(t *testing.T) {
+	ctx := context.Background()
+	userRepo, err := repository.NewUserRepository(ctx, c, storage)
+	require.NoError(t, err)
+	userService := newUserService(t, c, userRepo)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(ctx, c, storage)
+	require.NoError(t, err)
+	balanceOperationService := usecase.NewBalanceOperationService(c, balanceOperationRepo)
+	refreshTokenRepo, err := repository.NewRefreshTokenRepository(ctx, c, storage)
+	require.NoError(t, err)
+	adminRepo, err := repository.NewAdminRepository(ctx, c, storage)
+	require.NoError(t, err)
+	router := newRouter(t, c, userService, balanceOperationService, usecase.NewAdminService(c, adminRepo, balanceOperationService, refreshTokenRepo))
+	owner, err := userService.RegisterUser(ctx, &handlers.RegisterRequest{Login: "problem-owner", Password: "problem-pass"})
+	require.NoError(t, err)
+	other, err := userService.RegisterUser(ctx, &handlers.RegisterRequest{Login: "problem-other", Password: "problem-pass"})
+	require.NoError(t, err)
+	call := func(method string, path string, token string, contentType string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("Content-Type", contentType)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w
+	}
+	problem := func(w *httptest.ResponseRecorder, status int, code customerr.Code) {
+		t.Helper()
+		require.Equal(t, status, w.Code)
+		assert.Equal(t, handlers.ProblemContentType, w.Header().Get("Content-Type"))
+		var body handlers.Problem
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, status, body.Status)
+		assert.Equal(t, code, body.Code)
+		assert.Equal(t, http.StatusText(status), body.Title)
+	}
+
+	order := luhnNumber(2300)
+	require.Equal(t, http.StatusAccepted, call(http.MethodPost, "/api/user/orders", owner.AccessToken, "text/plain", order).Code)
+	problem(call(http.MethodPost, "/api/user/orders", owner.AccessToken, "text/plain", "12345678923"), http.StatusUnprocessableEntity, customerr.CodeOrderNumberInvalid)
+	problem(call(http.MethodPost, "/api/user/orders", other.AccessToken, "text/plain", order), http.StatusConflict, customerr.CodeOrderUploadedByAnotherUser)
+	// повторная загрузка своего заказа — успех, тела с ошибкой нет
+	w := call(http.MethodPost, "/api/user/orders", owner.AccessToken, "text/plain", order)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	problem(call(http.MethodPost, "/api/user/balance/withdraw", owner.AccessToken, "application/json", `{"order": "`+luhnNumber(2301)+`", "sum": 1}`), http.StatusPaymentRequired, customerr.CodeInsufficientFunds)
+	problem(call(http.MethodPost, "/api/user/balance/withdraw", owner.AccessToken, "application/json", `{"order": 1`), http.StatusBadRequest, customerr.CodeBadRequest)
+	// дробные копейки не округляются молча
+	problem(call(http.MethodPost, "/api/user/balance/withdraw", owner.AccessToken, "application/json", `{"order": "`+luhnNumber(2301)+`", "sum": 10.005}`), http.StatusBadRequest, customerr.CodeInvalidAmount)
+	problem(call(http.MethodPost, "/api/user/register", "", "application/json", `{"login": "problem-owner", "password": "problem-pass"}`), http.StatusConflict, customerr.CodeLoginTaken)
+	problem(call(http.MethodPost, "/api/user/register", "", "application/json", `{"login": "problem-new"}`), http.StatusBadRequest, customerr.CodeValidationFailed)
+	// неизвестный логин и неверный пароль неотличимы
+	problem(call(http.MethodPost, "/api/user/login", "", "application/json", `{"login": "problem-owner", "password": "wrong-pass"}`), http.StatusUnauthorized, customerr.CodeInvalidCredentials)
+	problem(call(http.MethodPost, "/api/user/login", "", "application/json", `{"login": "problem-nobody", "password": "wrong-pass"}`), http.StatusUnauthorized, customerr.CodeInvalidCredentials)
+	problem(call(http.MethodGet, "/api/user/orders", "", "", ""), http.StatusUnauthorized, customerr.CodeUnauthorized)
+	problem(call(http.MethodGet, "/api/user/orders?sort=newest", owner.AccessToken, "", ""), http.StatusBadRequest, customerr.CodeInvalidQuery)
+	problem(call(http.MethodGet, "/api/admin/users", owner.AccessToken, "", ""), http.StatusForbidden, customerr.CodeForbidden)
+	// слишком большое тело отклоняется до чтения целиком и до аутентификации
+	huge := `{"order": "` + strings.Repeat("1", middleware.DefaultMaxRequestBody) + `", "sum": 1}`
+	problem(call(http.MethodPost, "/api/user/balance/withdraw", "", "application/json", huge), http.StatusRequestEntityTooLarge, customerr.CodeRequestTooLarge)
+}
+
+func TestOpenAPI(t *testing.T) {
+	ctx := context.Background()
+	userRepo, err := repository.NewUserRepository(ctx, c, storage)
+	require.NoError(t, err)
+	userService := newUserService(t, c, userRepo)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(ctx, c, storage)
+	require.NoError(t, err)
+	balanceOperationService := usecase.NewBalanceOperationService(c, balanceOperationRepo)
+	refreshTokenRepo, err := repository.NewRefreshTokenRepository(ctx, c, storage)
+	require.NoError(t, err)
+	adminRepo, err := repository.NewAdminRepository(ctx, c, storage)
+	require.NoError(t, err)
+	router := newRouter(t, c, userService, balanceOperationService, usecase.NewAdminService(c, adminRepo, balanceOperationService, refreshTokenRepo))
+	doc, err := openapi.Load(api.OpenAPI)
+	require.NoError(t, err)
+
+	// каждый маршрут роутера описан в спецификации, и в спецификации нет лишних операций
+	registered := make(map[string]bool)
+	err = chi.Walk(router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		route = strings.TrimSuffix(strings.ReplaceAll(route, "/*/", "/"), "/")
+		registered[method+" "+route] = true
+		return nil
+	})
+	require.NoError(t, err)
+	documented := make(map[string]bool)
+	for _, route := range doc.Routes() {
+		documented[route.Method+" "+route.Path] = true
+	}
+	assert.Equal(t, registered, documented)
+
+	call := func(method string, path string, contentType string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			request.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w
+	}
+	w := call(http.MethodGet, "/api/openapi.json", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, string(api.OpenAPI), w.Body.String())
+	w = call(http.MethodGet, "/api/docs", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+
+	// запросы вне спецификации отклоняются до обработчика
+	for _, test := range []struct {
+		contentType string
+		body        string
+		status      int
+		code        customerr.Code
+	}{
+		{"application/json", `{"login": "openapi", "password": 42}`, http.StatusBadRequest, customerr.CodeValidationFailed},
+		{"application/json", `{"login": "openapi"`, http.StatusBadRequest, customerr.CodeBadRequest},
+		{"application/json", "", http.StatusBadRequest, customerr.CodeValidationFailed},
+		{"application/xml", `<login>openapi</login>`, http.StatusUnsupportedMediaType, customerr.CodeUnsupportedMedia},
+	} {
+		w = call(http.MethodPost, "/api/user/register", test.contentType, test.body)
+		require.Equal(t, test.status, w.Code, test.body)
+		var problem handlers.Problem
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+		assert.Equal(t, test.code, problem.Code, test.body)
+	}
+	// без Content-Type тело разбирается по единственному описанному типу
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, "/api/user/register", "", `{"login": "openapi", "password": "openapi-pass"}`).Code)
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	userRepo, err := repository.NewUserRepository(ctx, c, storage)
+	require.NoError(t, err)
+	userService := newUserService(t, c, userRepo)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(ctx, c, storage)
+	require.NoError(t, err)
+	balanceOperationService := usecase.NewBalanceOperationService(c, balanceOperationRepo)
+	refreshTokenRepo, err := repository.NewRefreshTokenRepository(ctx, c, storage)
+	require.NoError(t, err)
+	adminRepo, err := repository.NewAdminRepository(ctx, c, storage)
+	require.NoError(t, err)
+	m := metrics.New()
+	jobCtx, stopJob := context.WithCancel(ctx)
+	defer stopJob()
+	go job.NewOrderCountJob(c, balanceOperationRepo, kitlog.NewNopLogger(), m).Run(jobCtx)
+	router := newRouterWithMetrics(t, c, userService, balanceOperationService, usecase.NewAdminService(c, adminRepo, balanceOperationService, refreshTokenRepo), m)
+	call := func(method string, path string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w
+	}
+	require.Equal(t, http.StatusOK, call(http.MethodPost, "/api/user/register", `{"login": "metrics-user", "password": "metrics-pass"}`).Code)
+	require.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/api/user/register", `{"login": "metrics-user"}`).Code)
+	call(http.MethodGet, "/api/unknown", "")
+	// публичный роутер метрики не отдаёт
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/metrics", "").Code)
+
+	var body string
+	require.Eventually(t, func() bool {
+		w := httptest.NewRecorder()
+		m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		body = w.Body.String()
+		return w.Code == http.StatusOK && strings.Contains(body, "gophermart_orders")
+	}, 5*time.Second, 10*time.Millisecond)
+	// запрос, отклонённый до маршрутизации, учитывается по своему маршруту, а путь вне маршрутов — одной серией
+	assert.Contains(t, body, `gophermart_http_requests_total{method="POST",route="/api/user/register",status="200"} 1`)
+	assert.Contains(t, body, `gophermart_http_requests_total{method="POST",route="/api/user/register",status="400"} 1`)
+	assert.Contains(t, body, `gophermart_http_request_duration_seconds_count{method="POST",route="/api/user/register",status="200"} 1`)
+	assert.Contains(t, body, `route="unmatched",status="401"`)
+	assert.NotContains(t, body, "/api/unknown")
+	for _, status := range []string{"NEW", "PROCESSING", "INVALID", "PROCESSED"} {
+		assert.Contains(t, body, `gophermart_orders{status="`+status+`"}`)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+	_, err := tracing.Setup(context.Background(), config.TracesExporterNone, "", io.Discard)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	userRepo, err := repository.NewUserRepository(ctx, c, storage)
+	require.NoError(t, err)
+	userService := newUserService(t, c, userRepo)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(ctx, c, storage)
+	require.NoError(t, err)
+	balanceOperationService := usecase.NewBalanceOperationService(c, balanceOperationRepo)
+	refreshTokenRepo, err := repository.NewRefreshTokenRepository(ctx, c, storage)
+	require.NoError(t, err)
+	adminRepo, err := repository.NewAdminRepository(ctx, c, storage)
+	require.NoError(t, err)
+	router := newRouter(t, c, userService, balanceOperationService, usecase.NewAdminService(c, adminRepo, balanceOperationService, refreshTokenRepo))
+	request := httptest.NewRequest(http.MethodPost, "/api/user/register", strings.NewReader(`{"login": "tracing-user", "password": "tracing-pass"}`))
+	request.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+	res := w.Result()
+	defer res.Body.Close()
+	var access *http.Cookie
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == handlers.AccessTokenCookie {
+			access = cookie
+		}
+	}
+	require.NotNil(t, access)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	request = httptest.NewRequest(http.MethodPost, "/api/user/orders", strings.NewReader(luhnNumber(2500)))
+	request.Header.Set("Content-Type", "text/plain")
+	request.AddCookie(access)
+	request.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	require.NoError(t, provider.ForceFlush(ctx))
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	// серверный спан продолжает трассировку клиента, а спан usecase вложен в него
+	server, ok := spans["POST /api/user/orders"]
+	require.True(t, ok)
+	assert.Equal(t, traceID, server.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+	assert.Contains(t, server.Attributes(), semconv.HTTPStatusCode(http.StatusAccepted))
+	usecaseSpan, ok := spans["BalanceOperationService.CreateNewOrder"]
+	require.True(t, ok)
+	assert.Equal(t, server.SpanContext().SpanID(), usecaseSpan.Parent().SpanID())
+	assert.Equal(t, codes.Unset, usecaseSpan.Status().Code)
+
+	// отказ по вине клиента записывается в спан, но не помечает его ошибкой
+	seen := len(recorder.Ended())
+	request = httptest.NewRequest(http.MethodPost, "/api/user/orders", strings.NewReader("12345678923"))
+	request.Header.Set("Content-Type", "text/plain")
+	request.AddCookie(access)
+	router.ServeHTTP(httptest.NewRecorder(), request)
+	spans = make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended()[seen:] {
+		spans[span.Name()] = span
+	}
+	usecaseSpan, ok = spans["BalanceOperationService.CreateNewOrder"]
+	require.True(t, ok)
+	assert.Equal(t, codes.Unset, usecaseSpan.Status().Code)
+	require.Len(t, usecaseSpan.Events(), 1)
+	assert.Equal(t, "exception", usecaseSpan.Events()[0].Name)
+}
+
+// legacySchema схема, которую создавал initTables до появления миграций
+const legacySchema = `
+	create table if not exists "user" (
+		"id" serial not null,
+		"login" varchar(255) not null,
+		"password" varchar(255) not null,
+		"created_at" timestamp default now(),
+		"deleted_at" timestamp,
+		constraint "user_pk" primary key ("id")
+	);
+	create table if not exists "balance_operation" (
+		"id" serial not null,
+		"sum" integer default 0,
+		"order" varchar(255) not null,
+		"status" varchar(255),
+		"type" varchar(255) not null,
+		"user_id" integer not null,
+		"created_at" timestamp default now(),
+		"deleted_at" timestamp,
+		constraint "balance_operation_pk" primary key ("id")
+	);
+	ALTER TABLE "balance_operation" ADD CONSTRAINT "balance_operation_fk" FOREIGN KEY ("user_id") REFERENCES "user"("id");
+	CREATE UNIQUE INDEX "order_idx" ON "balance_operation"("order") where "deleted_at" is null;
+	CREATE UNIQUE INDEX "login_idx" ON "user"("login") where "deleted_at" is null;
+`
+
+func TestMigrateLegacySchema(t *testing.T) {
+	if c.UseMemoryStorage() {
+		t.Skip("migrations need postgres")
+	}
+	ctx := context.Background()
+	_, err := storage.Pool.Exec(ctx, `create database "gophermart_legacy"`)
+	require.NoError(t, err)
+	poolConfig := storage.Pool.Config().Copy()
+	poolConfig.ConnConfig.Database = "gophermart_legacy"
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	require.NoError(t, err)
+	defer func() {
+		pool.Close()
+		_, err := storage.Pool.Exec(ctx, `drop database "gophermart_legacy"`)
+		assert.NoError(t, err)
+	}()
+
+	_, err = pool.Exec(ctx, legacySchema)
+	require.NoError(t, err)
+	var userID int
+	require.NoError(t, pool.QueryRow(ctx, `insert into "user" ("login", "password") values ('legacy', 'hash') returning "id"`).Scan(&userID))
+	order := luhnNumber(2600)
+	_, err = pool.Exec(ctx, `insert into "balance_operation" ("sum", "order", "status", "type", "user_id") values (50000, $1, 'PROCESSED', 'ACCRUAL', $2)`, order, userID)
+	require.NoError(t, err)
+
+	require.NoError(t, migrateUp(ctx, pool))
+	m, err := newMigrate(pool)
+	require.NoError(t, err)
+	defer m.Close()
+	version, dirty, err := m.Version()
+	require.NoError(t, err)
+	assert.False(t, dirty)
+	assert.EqualValues(t, 14, version)
+
+	// данные сохранились, а списание по номеру заказа начисления больше не упирается в старый order_idx
+	legacyStorage := repository.NewPostgresStorage(pool)
+	balanceOperationRepo, err := repository.NewBalanceOperationRepository(ctx, c, legacyStorage)
+	require.NoError(t, err)
+	balance, err := balanceOperationRepo.GetBalanceByUser(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, entity.NewMoney(500, 0), balance.Current)
+	balanceOperationService := usecase.NewBalanceOperationService(c, balanceOperationRepo)
+	require.NoError(t, balanceOperationService.CreateWithdraw(ctx, userID, &handlers.WithdrawRequest{Order: order, Sum: entity.NewMoney(100, 0)}))
+	balance, err = balanceOperationRepo.GetBalanceByUser(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, entity.NewMoney(400, 0), balance.Current)
+}
@@ -23,18 +23,18 @@ type TestDatabase struct {
 	container  testcontainers.Container
 }
 
-func SetupTestDatabase() *TestDatabase {
+func SetupTestDatabase() (*TestDatabase, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
 	defer cancel()
 	container, dbInstance, dbAddr, err := createContainer(ctx)
 	if err != nil {
-		log.Fatal("failed to setup test", err)
+		return nil, fmt.Errorf("failed to setup test: %w", err)
 	}
 	return &TestDatabase{
 		container:  container,
 		DBInstance: dbInstance,
 		DBAddress:  dbAddr,
-	}
+	}, nil
 }
 
 func (tdb *TestDatabase) TearDown() {
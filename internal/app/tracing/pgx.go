@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// rowsAffectedKey число строк из тега команды Postgres
+const rowsAffectedKey = attribute.Key("db.rows_affected")
+
+// PgxTracer открывает спан на каждый запрос pgx и на пачку (pgx.Batch) целиком;
+// запросы пачки записываются событиями её спана. Значения параметров в спаны не попадают.
+type PgxTracer struct{}
+
+var (
+	_ pgx.QueryTracer = PgxTracer{}
+	_ pgx.BatchTracer = PgxTracer{}
+)
+
+func (PgxTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	operation := sqlOperation(data.SQL)
+	ctx, _ = Tracer().Start(ctx, "postgres "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperation(operation), semconv.DBStatement(data.SQL)),
+	)
+	return ctx
+}
+
+func (PgxTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(rowsAffectedKey.Int64(data.CommandTag.RowsAffected()))
+	endQuerySpan(span, data.Err)
+}
+
+func (PgxTracer) TraceBatchStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	ctx, _ = Tracer().Start(ctx, "postgres batch",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, attribute.Int("db.batch.size", data.Batch.Len())),
+	)
+	return ctx
+}
+
+func (PgxTracer) TraceBatchQuery(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchQueryData) {
+	attributes := []attribute.KeyValue{semconv.DBStatement(data.SQL), rowsAffectedKey.Int64(data.CommandTag.RowsAffected())}
+	if data.Err != nil {
+		attributes = append(attributes, attribute.String("error", data.Err.Error()))
+	}
+	trace.SpanFromContext(ctx).AddEvent("query", trace.WithAttributes(attributes...))
+}
+
+func (PgxTracer) TraceBatchEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchEndData) {
+	endQuerySpan(trace.SpanFromContext(ctx), data.Err)
+}
+
+// endQuerySpan не считает ошибкой pgx.ErrNoRows: репозитории отвечают на него 404
+func endQuerySpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// sqlOperation первое слово запроса в нижнем регистре: select, insert, with, begin...
+func sqlOperation(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "query"
+	}
+	return strings.ToLower(fields[0])
+}
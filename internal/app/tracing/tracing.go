@@ -0,0 +1,113 @@
+// Package tracing настраивает OpenTelemetry: провайдер спанов с выгрузкой в stdout или по OTLP,
+// распространение контекста по W3C Trace Context и спаны запросов к Postgres.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ServiceName         = "gophermart"
+	instrumentationName = "github.com/GusevGrishaEm1/gophermart-web-app.git"
+)
+
+// Tracer трассировщик сервиса. До Setup спаны не записываются, но контекст входящего запроса
+// всё равно передаётся дальше, в том числе в исходящие запросы.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Setup устанавливает глобальные провайдер спанов и пропагатор и возвращает функцию,
+// которая при остановке выгружает накопленные спаны. out — куда пишет экспортёр stdout.
+// Имя сервиса и атрибуты ресурса можно переопределить через OTEL_SERVICE_NAME и OTEL_RESOURCE_ATTRIBUTES.
+func Setup(ctx context.Context, exporter string, otlpEndpoint string, out io.Writer) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	var spanExporter sdktrace.SpanExporter
+	switch exporter {
+	case "", config.TracesExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case config.TracesExporterStdout:
+		stdout, err := stdouttrace.New(stdouttrace.WithWriter(out))
+		if err != nil {
+			return nil, err
+		}
+		spanExporter = stdout
+	case config.TracesExporterOTLP:
+		options, err := otlpOptions(otlpEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		otlp, err := otlptracehttp.New(ctx, options...)
+		if err != nil {
+			return nil, err
+		}
+		spanExporter = otlp
+	default:
+		return nil, fmt.Errorf("unknown traces exporter %q", exporter)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	res, err = resource.Merge(res, resource.Environment())
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(spanExporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// otlpOptions переводит базовый адрес коллектора вида http://host:4318 в опции экспортёра;
+// спаны уходят на <адрес>/v1/traces, как того требует спецификация OTLP для OTEL_EXPORTER_OTLP_ENDPOINT
+func otlpOptions(endpoint string) ([]otlptracehttp.Option, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("otlp endpoint %q has no host", endpoint)
+	}
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(strings.TrimSuffix(u.Path, "/") + "/v1/traces"),
+	}
+	switch u.Scheme {
+	case "http":
+		options = append(options, otlptracehttp.WithInsecure())
+	case "https":
+	default:
+		return nil, fmt.Errorf("otlp endpoint %q must use http or https", endpoint)
+	}
+	return options, nil
+}
+
+// End завершает спан. Ошибка записывается в спан, но статус Error получают только ошибки сервера:
+// отказ по вине клиента (4xx) — штатный исход. err — указатель на именованный результат функции.
+func End(span trace.Span, err *error) {
+	if err != nil && *err != nil {
+		span.RecordError(*err)
+		customErr := &customerr.CustomError{}
+		if !errors.As(*err, &customErr) || customErr.HTTPStatus == 0 || customErr.HTTPStatus >= 500 {
+			span.SetStatus(codes.Error, (*err).Error())
+		}
+	}
+	span.End()
+}
@@ -0,0 +1,46 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetupOTLP(t *testing.T) {
+	var path string
+	var contentType string
+	var received int
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		contentType = r.Header.Get("Content-Type")
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		received = len(body)
+	}))
+	defer collector.Close()
+
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+	shutdown, err := Setup(context.Background(), config.TracesExporterOTLP, collector.URL+"/", nil)
+	require.NoError(t, err)
+	_, span := Tracer().Start(context.Background(), "span")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+	assert.Equal(t, "/v1/traces", path)
+	assert.Equal(t, "application/x-protobuf", contentType)
+	assert.Positive(t, received)
+}
+
+func TestSetupUnknownExporter(t *testing.T) {
+	_, err := Setup(context.Background(), "jaeger", "", nil)
+	assert.Error(t, err)
+	_, err = Setup(context.Background(), config.TracesExporterOTLP, "localhost:4318", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,177 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	nethttp "net/http"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/controller/http"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository"
+)
+
+// AdminService операции администратора. Каждое действие, включая просмотр данных пользователя,
+// записывается в журнал admin_audit.
+type AdminService struct {
+	c             *config.Config
+	balance       *BalanceOperationService
+	refreshTokens repository.RefreshTokenRepository
+	repository.AdminRepository
+}
+
+func NewAdminService(c *config.Config, r repository.AdminRepository, balance *BalanceOperationService, refreshTokens repository.RefreshTokenRepository) *AdminService {
+	return &AdminService{c, balance, refreshTokens, r}
+}
+
+func (s *AdminService) ListUsers(ctx context.Context) ([]*http.AdminUserResponse, error) {
+	err := s.audit(ctx, entity.AuditListUsers, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	users, err := s.FindUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	responseArr := make([]*http.AdminUserResponse, len(users))
+	for i, user := range users {
+		responseArr[i] = newAdminUserResponse(user)
+	}
+	return responseArr, nil
+}
+
+func (s *AdminService) GetUserOrders(ctx context.Context, userID int, query *http.ListQuery) ([]*http.OrderResponse, string, error) {
+	err := s.auditUser(ctx, entity.AuditViewOrders, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	return s.balance.GetListOrders(ctx, userID, query)
+}
+
+func (s *AdminService) GetUserBalance(ctx context.Context, userID int) (*http.BalanceResponse, error) {
+	err := s.auditUser(ctx, entity.AuditViewBalance, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.balance.GetBalance(ctx, userID)
+}
+
+// BlockUser блокирует пользователя и завершает все его сессии
+func (s *AdminService) BlockUser(ctx context.Context, userID int) error {
+	adminID, err := adminIDFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if adminID == userID {
+		return customerr.NewCodedError(errors.New("admin cannot block themselves"), nethttp.StatusConflict, customerr.CodeCannotBlockSelf)
+	}
+	err = s.SetBlocked(ctx, userID, true, &entity.AuditRecord{AdminID: adminID, Action: entity.AuditBlockUser})
+	if err != nil {
+		return err
+	}
+	return s.refreshTokens.RevokeUser(ctx, userID, "")
+}
+
+func (s *AdminService) UnblockUser(ctx context.Context, userID int) error {
+	adminID, err := adminIDFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	return s.SetBlocked(ctx, userID, false, &entity.AuditRecord{AdminID: adminID, Action: entity.AuditUnblockUser})
+}
+
+func (s *AdminService) AdjustBalance(ctx context.Context, userID int, dto *http.AdjustBalanceRequest) error {
+	if dto.Amount == 0 {
+		return customerr.NewCodedError(errors.New("adjustment amount must not be zero"), nethttp.StatusBadRequest, customerr.CodeInvalidAmount)
+	}
+	adminID, err := adminIDFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	record := &entity.AuditRecord{
+		AdminID: adminID,
+		Action:  entity.AuditAdjustBalance,
+		UserID:  userID,
+		Details: dto.Amount.String() + ": " + dto.Reason,
+	}
+	return s.AdminRepository.AdjustBalance(ctx, entity.NewAdjustmentPosting(userID, dto.Amount), record)
+}
+
+// GetAuditRecords при нулевом userID отдаёт журнал целиком; просмотр журнала тоже записывается в журнал
+func (s *AdminService) GetAuditRecords(ctx context.Context, userID int) ([]*http.AuditRecordResponse, error) {
+	var err error
+	if userID == 0 {
+		err = s.audit(ctx, entity.AuditViewAudit, 0, "")
+	} else {
+		err = s.auditUser(ctx, entity.AuditViewAudit, userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	records, err := s.FindAuditRecords(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	responseArr := make([]*http.AuditRecordResponse, len(records))
+	for i, record := range records {
+		responseArr[i] = &http.AuditRecordResponse{
+			ID:        record.ID,
+			AdminID:   record.AdminID,
+			Action:    string(record.Action),
+			UserID:    record.UserID,
+			Details:   record.Details,
+			CreatedAt: record.CreatedAt.Format(time.RFC3339),
+		}
+	}
+	return responseArr, nil
+}
+
+// SetRole назначает роль из командной строки сервиса, в журнале такое действие записывается без администратора
+func (s *AdminService) SetRole(ctx context.Context, login string, role entity.Role) error {
+	if role != entity.RoleUser && role != entity.RoleAdmin {
+		return customerr.NewCodedError(fmt.Errorf("unknown role %q", role), nethttp.StatusBadRequest, customerr.CodeUnknownRole)
+	}
+	return s.AdminRepository.SetRole(ctx, login, role, &entity.AuditRecord{Action: entity.AuditSetRole, Details: string(role)})
+}
+
+// auditUser проверяет, что пользователь существует, чтобы на неизвестный id отвечать 404, а не пустыми данными
+func (s *AdminService) auditUser(ctx context.Context, action entity.AuditAction, userID int) error {
+	_, err := s.FindUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return s.audit(ctx, action, userID, "")
+}
+
+func (s *AdminService) audit(ctx context.Context, action entity.AuditAction, userID int, details string) error {
+	adminID, err := adminIDFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	return s.SaveAuditRecord(ctx, &entity.AuditRecord{AdminID: adminID, Action: action, UserID: userID, Details: details})
+}
+
+func adminIDFromContext(ctx context.Context) (int, error) {
+	adminID, ok := ctx.Value(UserID).(int)
+	if !ok {
+		return 0, customerr.NewError(errors.New("user_id is nil"), nethttp.StatusUnauthorized)
+	}
+	return adminID, nil
+}
+
+func newAdminUserResponse(user *entity.User) *http.AdminUserResponse {
+	response := &http.AdminUserResponse{
+		ID:        user.ID,
+		Login:     user.Login,
+		Role:      string(user.Role),
+		Blocked:   user.IsBlocked(),
+		CreatedAt: user.CreatedAt.Format(time.RFC3339),
+	}
+	if user.IsBlocked() {
+		response.BlockedAt = user.BlockedAt.Format(time.RFC3339)
+	}
+	return response
+}
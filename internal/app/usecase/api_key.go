@@ -0,0 +1,119 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	nethttp "net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/controller/http"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository"
+)
+
+// APIKeyPrefix начало каждого ключа, по нему ключ легко найти в логах и репозиториях
+const APIKeyPrefix = "gm_"
+
+type APIKeyService struct {
+	c *config.Config
+	repository.APIKeyRepository
+}
+
+func NewAPIKeyService(c *config.Config, r repository.APIKeyRepository) *APIKeyService {
+	return &APIKeyService{c, r}
+}
+
+// CreateAPIKey выпускает ключ текущему пользователю; сам ключ возвращается только в этом ответе
+func (s *APIKeyService) CreateAPIKey(ctx context.Context, dto *http.CreateAPIKeyRequest) (*http.APIKeyResponse, error) {
+	userID, ok := ctx.Value(UserID).(int)
+	if !ok {
+		return nil, customerr.NewError(errors.New("user_id is nil"), nethttp.StatusUnauthorized)
+	}
+	for _, scope := range dto.Scopes {
+		if !slices.Contains(entity.APIKeyScopes, scope) {
+			return nil, customerr.NewCodedError(fmt.Errorf("unknown scope %q", scope), nethttp.StatusBadRequest, customerr.CodeUnknownScope)
+		}
+	}
+	prefix, err := randomToken(6)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	prefix = APIKeyPrefix + prefix
+	plain := prefix + "_" + secret
+	scopes := slices.Clone(dto.Scopes)
+	slices.Sort(scopes)
+	key := &entity.APIKey{
+		UserID:  userID,
+		Name:    dto.Name,
+		Prefix:  prefix,
+		KeyHash: hashToken(plain),
+		Scopes:  slices.Compact(scopes),
+	}
+	err = s.Save(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	response := newAPIKeyResponse(key)
+	response.Key = plain
+	return response, nil
+}
+
+func (s *APIKeyService) ListAPIKeys(ctx context.Context) ([]*http.APIKeyResponse, error) {
+	userID, ok := ctx.Value(UserID).(int)
+	if !ok {
+		return nil, customerr.NewError(errors.New("user_id is nil"), nethttp.StatusUnauthorized)
+	}
+	keys, err := s.FindByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	responseArr := make([]*http.APIKeyResponse, len(keys))
+	for i, key := range keys {
+		responseArr[i] = newAPIKeyResponse(key)
+	}
+	return responseArr, nil
+}
+
+func (s *APIKeyService) RevokeAPIKey(ctx context.Context, ID int) error {
+	userID, ok := ctx.Value(UserID).(int)
+	if !ok {
+		return customerr.NewError(errors.New("user_id is nil"), nethttp.StatusUnauthorized)
+	}
+	return s.Revoke(ctx, userID, ID)
+}
+
+// AuthenticateAPIKey возвращает владельца ключа и права ключа. Время использования ключа,
+// как и время последнего запроса сессии, обновляется не чаще SessionTouchInterval.
+func (s *APIKeyService) AuthenticateAPIKey(ctx context.Context, key string) (int, []string, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return 0, nil, customerr.NewCodedError(errors.New("malformed api key"), nethttp.StatusUnauthorized, customerr.CodeAPIKeyInvalid)
+	}
+	apiKey, err := s.Use(ctx, hashToken(key), time.Now().Add(-SessionTouchInterval))
+	if err != nil {
+		return 0, nil, err
+	}
+	return apiKey.UserID, apiKey.Scopes, nil
+}
+
+func newAPIKeyResponse(key *entity.APIKey) *http.APIKeyResponse {
+	response := &http.APIKeyResponse{
+		ID:        key.ID,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		Scopes:    key.Scopes,
+		CreatedAt: key.CreatedAt.Format(time.RFC3339),
+	}
+	if !key.LastUsedAt.IsZero() {
+		response.LastUsedAt = key.LastUsedAt.Format(time.RFC3339)
+	}
+	return response
+}
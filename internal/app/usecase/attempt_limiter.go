@@ -0,0 +1,108 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository"
+)
+
+const (
+	DefaultAttemptLockout    = time.Minute
+	DefaultAttemptMaxLockout = time.Hour
+)
+
+// AttemptLimit порог неудачных попыток для одного ключа ограничения
+type AttemptLimit struct {
+	Key         string
+	MaxFailures int
+}
+
+// LockoutError возвращается, пока ключ заблокирован; RetryAfter попадает в одноимённый заголовок ответа
+type LockoutError struct {
+	Lockout time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("too many failed attempts, retry after %s", e.Lockout.Round(time.Second))
+}
+
+func (e *LockoutError) RetryAfter() time.Duration {
+	return e.Lockout
+}
+
+// AttemptLimiter считает неудачные попытки по произвольным ключам и блокирует ключ,
+// превысивший порог, на время, удваивающееся с каждой следующей неудачей
+type AttemptLimiter struct {
+	lockout    time.Duration
+	maxLockout time.Duration
+	repository.AttemptRepository
+}
+
+func NewAttemptLimiter(c *config.Config, r repository.AttemptRepository) *AttemptLimiter {
+	lockout := c.LoginLockout
+	if lockout <= 0 {
+		lockout = DefaultAttemptLockout
+	}
+	maxLockout := c.LoginMaxLockout
+	if maxLockout < lockout {
+		maxLockout = max(lockout, DefaultAttemptMaxLockout)
+	}
+	return &AttemptLimiter{lockout, maxLockout, r}
+}
+
+// Check возвращает ошибку 429, если заблокирован хотя бы один из ключей
+func (l *AttemptLimiter) Check(ctx context.Context, limits ...AttemptLimit) error {
+	keys := make([]string, 0, len(limits))
+	for _, limit := range limits {
+		keys = append(keys, limit.Key)
+	}
+	lockout, err := l.GetLockout(ctx, keys)
+	if err != nil {
+		return err
+	}
+	if lockout > 0 {
+		return customerr.NewCodedError(&LockoutError{lockout}, nethttp.StatusTooManyRequests, customerr.CodeTooManyAttempts)
+	}
+	return nil
+}
+
+// Fail учитывает неудачу по каждому ключу и блокирует ключи, превысившие порог.
+// Счётчик сбрасывается, если неудач не было дольше максимальной блокировки.
+func (l *AttemptLimiter) Fail(ctx context.Context, limits ...AttemptLimit) error {
+	for _, limit := range limits {
+		failures, err := l.RegisterFailure(ctx, limit.Key, l.maxLockout)
+		if err != nil {
+			return err
+		}
+		if limit.MaxFailures <= 0 || failures < limit.MaxFailures {
+			continue
+		}
+		if err = l.Lock(ctx, limit.Key, l.lockoutFor(failures-limit.MaxFailures)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Succeed сбрасывает счётчики ключей после успешной попытки
+func (l *AttemptLimiter) Succeed(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		if err := l.Reset(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *AttemptLimiter) lockoutFor(excess int) time.Duration {
+	lockout := l.lockout
+	for i := 0; i < excess && lockout < l.maxLockout; i++ {
+		lockout *= 2
+	}
+	return min(lockout, l.maxLockout)
+}
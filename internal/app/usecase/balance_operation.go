@@ -2,9 +2,12 @@ package usecase
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	nethttp "net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
@@ -12,6 +15,7 @@ import (
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
 	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/tracing"
 )
 
 type BalanceOperationService struct {
@@ -23,9 +27,11 @@ func NewBalanceOperationService(c *config.Config, r repository.BalanceOperationR
 	return &BalanceOperationService{c, r}
 }
 
-func (s *BalanceOperationService) CreateNewOrder(ctx context.Context, dto *http.CreateOrderRequest) error {
+func (s *BalanceOperationService) CreateNewOrder(ctx context.Context, dto *http.CreateOrderRequest) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "BalanceOperationService.CreateNewOrder")
+	defer tracing.End(span, &err)
 	if !checkLuhn(dto.Order) {
-		return customerr.NewError(errors.New("luhn alg validation failed"), nethttp.StatusUnprocessableEntity)
+		return customerr.NewCodedError(errors.New("luhn alg validation failed"), nethttp.StatusUnprocessableEntity, customerr.CodeOrderNumberInvalid)
 	}
 	balanceOperation := &entity.BalanceOperation{
 		Order:  dto.Order,
@@ -37,43 +43,58 @@ func (s *BalanceOperationService) CreateNewOrder(ctx context.Context, dto *http.
 	return s.SaveOrder(ctx, balanceOperation)
 }
 
-func (s *BalanceOperationService) GetListOrders(ctx context.Context, userID int) ([]*http.OrderResponse, error) {
-	entityArr, err := s.FindOrdersByUser(ctx, userID)
+// GetListOrders возвращает страницу заказов и курсор следующей страницы (пустой на последней)
+func (s *BalanceOperationService) GetListOrders(ctx context.Context, userID int, query *http.ListQuery) (_ []*http.OrderResponse, _ string, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "BalanceOperationService.GetListOrders")
+	defer tracing.End(span, &err)
+	filter, err := newBalanceOperationFilter(userID, query)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	entityArr, err := s.FindOrdersByUser(ctx, filter)
+	if err != nil {
+		return nil, "", err
 	}
+	entityArr, next := nextPage(entityArr, query.Limit)
 	responseArr := make([]*http.OrderResponse, len(entityArr))
 	for i, entity := range entityArr {
 		response := &http.OrderResponse{
 			Number:     entity.Order,
 			Status:     string(entity.Status),
-			Accrual:    float32(entity.Sum) / 100,
+			Accrual:    entity.Sum,
 			UploadedAt: entity.CreatedAt.Format(time.RFC3339),
 		}
 		responseArr[i] = response
 	}
-	return responseArr, nil
+	return responseArr, next, nil
 }
 
-func (s *BalanceOperationService) GetBalance(ctx context.Context, userID int) (*http.BalanceResponse, error) {
-	current, withdrawn, err := s.GetBalanceByUser(ctx, userID)
+func (s *BalanceOperationService) GetBalance(ctx context.Context, userID int) (_ *http.BalanceResponse, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "BalanceOperationService.GetBalance")
+	defer tracing.End(span, &err)
+	balance, err := s.GetBalanceByUser(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
 	result := &http.BalanceResponse{
-		Current:   float32(current) / 100,
-		Withdrawn: float32(withdrawn) / 100 * (-1),
+		Current:   balance.Current,
+		Withdrawn: balance.Withdrawn,
 	}
 	return result, nil
 }
 
-func (s *BalanceOperationService) CreateWithdraw(ctx context.Context, userID int, withdraw *http.WithdrawRequest) error {
+func (s *BalanceOperationService) CreateWithdraw(ctx context.Context, userID int, withdraw *http.WithdrawRequest) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "BalanceOperationService.CreateWithdraw")
+	defer tracing.End(span, &err)
 	if !checkLuhn(withdraw.Order) {
-		return customerr.NewError(errors.New("luhn alg validation failed"), nethttp.StatusUnprocessableEntity)
+		return customerr.NewCodedError(errors.New("luhn alg validation failed"), nethttp.StatusUnprocessableEntity, customerr.CodeOrderNumberInvalid)
+	}
+	if !withdraw.Sum.IsPositive() {
+		return customerr.NewCodedError(errors.New("withdraw sum must be positive"), nethttp.StatusBadRequest, customerr.CodeInvalidAmount)
 	}
 	balanceOperation := &entity.BalanceOperation{
 		Order:  withdraw.Order,
-		Sum:    int(withdraw.Sum*100) * (-1),
+		Sum:    withdraw.Sum.Neg(),
 		UserID: userID,
 		Status: entity.PROCESSED,
 		Type:   entity.WITHDRAW,
@@ -101,19 +122,89 @@ func checkLuhn(order string) bool {
 	return sum%10 == 0
 }
 
-func (s *BalanceOperationService) GetWithdrawals(ctx context.Context, userID int) ([]*http.WithdrawResponse, error) {
-	entityArr, err := s.FindWithdrawsByUser(ctx, userID)
+// GetWithdrawals возвращает страницу списаний и курсор следующей страницы (пустой на последней)
+func (s *BalanceOperationService) GetWithdrawals(ctx context.Context, userID int, query *http.ListQuery) (_ []*http.WithdrawResponse, _ string, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "BalanceOperationService.GetWithdrawals")
+	defer tracing.End(span, &err)
+	filter, err := newBalanceOperationFilter(userID, query)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	entityArr, err := s.FindWithdrawsByUser(ctx, filter)
+	if err != nil {
+		return nil, "", err
 	}
+	entityArr, next := nextPage(entityArr, query.Limit)
 	responseArr := make([]*http.WithdrawResponse, len(entityArr))
 	for i, entity := range entityArr {
 		response := &http.WithdrawResponse{
 			Order:       entity.Order,
-			Sum:         float32(entity.Sum) / 100 * (-1),
+			Sum:         entity.Sum.Neg(),
 			ProcessedAt: entity.CreatedAt.Format(time.RFC3339),
 		}
 		responseArr[i] = response
 	}
-	return responseArr, nil
+	return responseArr, next, nil
+}
+
+// newBalanceOperationFilter запрашивает на одну запись больше страницы, чтобы узнать, есть ли следующая
+func newBalanceOperationFilter(userID int, query *http.ListQuery) (*entity.BalanceOperationFilter, error) {
+	filter := &entity.BalanceOperationFilter{
+		UserID:    userID,
+		From:      query.From,
+		To:        query.To,
+		Ascending: query.Ascending,
+		Limit:     query.Limit + 1,
+	}
+	for _, status := range query.Statuses {
+		switch status := entity.ProcessStatus(status); status {
+		case entity.NEW, entity.PROCESSING, entity.INVALID, entity.PROCESSED:
+			filter.Statuses = append(filter.Statuses, status)
+		default:
+			return nil, customerr.NewCodedError(fmt.Errorf("unknown status %q", status), nethttp.StatusBadRequest, customerr.CodeInvalidQuery)
+		}
+	}
+	if query.Cursor != "" {
+		cursor, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return nil, customerr.NewCodedError(err, nethttp.StatusBadRequest, customerr.CodeInvalidQuery)
+		}
+		filter.After = cursor
+	}
+	return filter, nil
+}
+
+func nextPage(entityArr []*entity.BalanceOperation, limit int) ([]*entity.BalanceOperation, string) {
+	if len(entityArr) <= limit {
+		return entityArr, ""
+	}
+	last := entityArr[limit-1]
+	return entityArr[:limit], encodeCursor(&entity.BalanceOperationCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+}
+
+// курсор непрозрачен для клиента: base64 от "<created_at в наносекундах>.<id>"
+func encodeCursor(cursor *entity.BalanceOperationCursor) string {
+	raw := strconv.FormatInt(cursor.CreatedAt.UnixNano(), 10) + "." + strconv.Itoa(cursor.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (*entity.BalanceOperationCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	nanos, ID, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return nil, errors.New("malformed cursor")
+	}
+	createdAt, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	cursor := &entity.BalanceOperationCursor{CreatedAt: time.Unix(0, createdAt).UTC()}
+	cursor.ID, err = strconv.Atoi(ID)
+	if err != nil {
+		return nil, err
+	}
+	return cursor, nil
 }
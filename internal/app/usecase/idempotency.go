@@ -0,0 +1,102 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	nethttp "net/http"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository"
+)
+
+const (
+	DefaultIdempotencyKeyTTL = 24 * time.Hour
+	// DefaultIdempotencyLockTTL через сколько незавершённый запрос считается брошенным
+	// (процесс упал или ответ не удалось сохранить) и ключ можно занять заново
+	DefaultIdempotencyLockTTL = time.Minute
+	MaxIdempotencyKeyLength   = 255
+)
+
+type IdempotencyService struct {
+	c *config.Config
+	repository.IdempotencyRepository
+}
+
+func NewIdempotencyService(c *config.Config, r repository.IdempotencyRepository) *IdempotencyService {
+	return &IdempotencyService{c, r}
+}
+
+// BeginRequest занимает ключ под запрос. Если ключ занят, возвращается запись-бронь и true:
+// запрос нужно выполнить и передать бронь в FinishRequest. Если запрос с этим ключом уже завершён,
+// возвращается сохранённый ответ для повтора и false.
+func (s *IdempotencyService) BeginRequest(ctx context.Context, userID int, key string, method string, path string, body []byte) (*entity.IdempotencyRecord, bool, error) {
+	if len(key) > MaxIdempotencyKeyLength {
+		return nil, false, customerr.NewCodedError(errors.New("idempotency key is too long"), nethttp.StatusBadRequest, customerr.CodeIdempotencyKeyInvalid)
+	}
+	record := &entity.IdempotencyRecord{
+		UserID:      userID,
+		Key:         key,
+		Fingerprint: fingerprint(method, path, body),
+	}
+	existing, reserved, err := s.Reserve(ctx, record, s.ttl(), s.lockTTL())
+	if err != nil {
+		return nil, false, err
+	}
+	if reserved {
+		return existing, true, nil
+	}
+	if existing.Fingerprint != record.Fingerprint {
+		return nil, false, customerr.NewCodedError(errors.New("idempotency key is reused with another request"), nethttp.StatusUnprocessableEntity, customerr.CodeIdempotencyKeyReused)
+	}
+	if existing.InProgress() {
+		return nil, false, customerr.NewCodedError(errors.New("request with this idempotency key is in progress"), nethttp.StatusConflict, customerr.CodeIdempotencyRequestInFlight)
+	}
+	return existing, false, nil
+}
+
+// FinishRequest сохраняет ответ для повторов. Ответы с ошибкой сервера не сохраняются,
+// чтобы клиент мог повторить запрос с тем же ключом. Если ответ сохранить не удалось, ключ тоже освобождается.
+// Ключ, который после lockTTL занял повтор, не перезаписывается и не освобождается.
+func (s *IdempotencyService) FinishRequest(ctx context.Context, reservation *entity.IdempotencyRecord, statusCode int, contentType string, body []byte) error {
+	if statusCode >= nethttp.StatusInternalServerError {
+		return s.Release(ctx, reservation)
+	}
+	completed := *reservation
+	completed.StatusCode = statusCode
+	completed.ContentType = contentType
+	completed.Body = body
+	err := s.Complete(ctx, &completed)
+	if err != nil {
+		return errors.Join(err, s.Release(ctx, reservation))
+	}
+	return nil
+}
+
+func (s *IdempotencyService) ttl() time.Duration {
+	if s.c.IdempotencyKeyTTL > 0 {
+		return s.c.IdempotencyKeyTTL
+	}
+	return DefaultIdempotencyKeyTTL
+}
+
+func (s *IdempotencyService) lockTTL() time.Duration {
+	if s.c.IdempotencyLockTTL > 0 {
+		return s.c.IdempotencyLockTTL
+	}
+	return DefaultIdempotencyLockTTL
+}
+
+func fingerprint(method string, path string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method))
+	hash.Write([]byte{0})
+	hash.Write([]byte(path))
+	hash.Write([]byte{0})
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,41 @@
+package job
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// AccrualStats счётчики опроса системы начислений с момента запуска
+type AccrualStats struct {
+	Requests int64
+	Failed   int64
+	Updated  int64
+	Batches  int64
+	InFlight int64
+}
+
+type accrualMetrics struct {
+	requests atomic.Int64
+	failed   atomic.Int64
+	updated  atomic.Int64
+	batches  atomic.Int64
+	inFlight atomic.Int64
+}
+
+func (m *accrualMetrics) snapshot() AccrualStats {
+	return AccrualStats{
+		Requests: m.requests.Load(),
+		Failed:   m.failed.Load(),
+		Updated:  m.updated.Load(),
+		Batches:  m.batches.Load(),
+		InFlight: m.inFlight.Load(),
+	}
+}
+
+// perSecond пересчитывает прирост счётчика за период в штуки в секунду
+func perSecond(delta int64, period time.Duration) float64 {
+	if period <= 0 {
+		return 0
+	}
+	return float64(delta) / period.Seconds()
+}
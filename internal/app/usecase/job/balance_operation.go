@@ -2,86 +2,266 @@ package job
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/metrics"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/tracing"
+	log "github.com/go-kit/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const MaxArraySize int = 1000
+const (
+	MaxArraySize           int = 1000
+	DefaultAccrualWorkers  int = 4
+	DefaultAccrualLease        = 5 * time.Minute
+	AccrualMetricsInterval     = time.Minute
+)
 
 type AccrualWebAPI interface {
-	GetAccrualRequest(order string) (*entity.AccrualResponse, error)
+	GetAccrualRequest(ctx context.Context, order string) (*entity.AccrualResponse, error)
 }
 
 type BalanceOperationJob struct {
+	workers           int
+	owner             string
+	lease             time.Duration
+	logger            log.Logger
+	metrics           accrualMetrics
+	promMetrics       *metrics.Metrics
 	chToUpdateAccrual chan *entity.BalanceOperation
+	// held заказы, захваченные этим экземпляром и ещё не сохранённые: в очереди или в пачке воркера
+	heldMu sync.Mutex
+	held   map[int]struct{}
 	AccrualWebAPI
 	repository.BalanceOperationRepository
 }
 
-func NewBalanceOperationJob(config *config.Config, r repository.BalanceOperationRepository, webAPI AccrualWebAPI) *BalanceOperationJob {
+func NewBalanceOperationJob(config *config.Config, r repository.BalanceOperationRepository, webAPI AccrualWebAPI, logger log.Logger, m *metrics.Metrics) *BalanceOperationJob {
+	workers := config.AccrualWorkers
+	if workers <= 0 {
+		workers = DefaultAccrualWorkers
+	}
+	lease := config.AccrualLease
+	if lease <= 0 {
+		lease = DefaultAccrualLease
+	}
 	return &BalanceOperationJob{
-		make(chan *entity.BalanceOperation, 1024),
-		webAPI,
-		r,
+		workers:                    workers,
+		owner:                      newLeaseOwner(),
+		lease:                      lease,
+		logger:                     logger,
+		promMetrics:                m,
+		chToUpdateAccrual:          make(chan *entity.BalanceOperation, 1024),
+		held:                       make(map[int]struct{}),
+		AccrualWebAPI:              webAPI,
+		BalanceOperationRepository: r,
+	}
+}
+
+// Run запускает продюсера, пул воркеров и отчёт о пропускной способности;
+// возвращается, когда все воркеры сохранили накопленные результаты
+func (j *BalanceOperationJob) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(j.workers)
+	for i := 0; i < j.workers; i++ {
+		go func() {
+			defer wg.Done()
+			j.ConsumeOrder(ctx)
+		}()
 	}
+	go j.ProduceOrder(ctx)
+	go j.ReportMetrics(ctx)
+	wg.Wait()
 }
 
+// Stats возвращает текущие значения счётчиков опроса
+func (j *BalanceOperationJob) Stats() AccrualStats {
+	return j.metrics.snapshot()
+}
+
+// QueueLength сколько захваченных заказов ждут свободного воркера
+func (j *BalanceOperationJob) QueueLength() int {
+	return len(j.chToUpdateAccrual)
+}
+
+// ProduceOrder захватывает заказы в аренду порциями не больше свободного места в очереди воркеров
 func (j *BalanceOperationJob) ProduceOrder(ctx context.Context) {
 	defer close(j.chToUpdateAccrual)
 	ticker := time.NewTicker(500 * time.Millisecond)
-loop:
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
-			break loop
+			return
 		case <-ticker.C:
-			orders, err := j.FindOrdersToProcess(ctx)
+			limit := cap(j.chToUpdateAccrual) - len(j.chToUpdateAccrual)
+			if limit <= 0 {
+				continue
+			}
+			orders, err := j.FindOrdersToProcess(ctx, j.owner, limit, j.lease, j.heldIDs())
 			if err != nil {
+				j.logger.Log("job", "accrual", "msg", "failed to claim orders", "err", err)
 				continue
 			}
+			j.hold(orders)
 			for _, el := range orders {
-				j.chToUpdateAccrual <- el
+				select {
+				case j.chToUpdateAccrual <- el:
+				case <-ctx.Done():
+					return
+				}
 			}
 		}
 	}
 }
 
+// ConsumeOrder один воркер пула: опрашивает систему начислений и копит свою пачку для UpdateOrders.
+// После отмены контекста дочитывает очередь до закрытия, возвращая оставшиеся заказы в NEW,
+// и сохраняет накопленное перед выходом.
 func (j *BalanceOperationJob) ConsumeOrder(ctx context.Context) {
 	arrayToUpdate := make([]*entity.BalanceOperation, 0)
 	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
 	defer func() {
-		if len(arrayToUpdate) > 0 {
-			j.UpdateOrders(ctx, arrayToUpdate)
-			arrayToUpdate = arrayToUpdate[:0]
-		}
+		j.flush(ctx, arrayToUpdate)
 	}()
-loop:
 	for {
 		select {
-		case el := <-j.chToUpdateAccrual:
-			response, err := j.GetAccrualRequest(el.Order)
-			if err != nil {
-				el.Sum = 0
-				el.Status = entity.NEW
+		case el, ok := <-j.chToUpdateAccrual:
+			if !ok {
+				return
+			}
+			if ctx.Err() != nil {
+				release(el)
 			} else {
-				el.Sum = int(response.Accrual * 100)
-				el.Status = entity.ProcessStatus(response.Status)
+				j.process(ctx, el)
 			}
 			arrayToUpdate = append(arrayToUpdate, el)
 			if len(arrayToUpdate) > MaxArraySize {
-				j.UpdateOrders(ctx, arrayToUpdate)
+				j.flush(ctx, arrayToUpdate)
 				arrayToUpdate = arrayToUpdate[:0]
 			}
 		case <-ticker.C:
-			if len(arrayToUpdate) > 0 {
-				j.UpdateOrders(ctx, arrayToUpdate)
-				arrayToUpdate = arrayToUpdate[:0]
-			}
+			j.flush(ctx, arrayToUpdate)
+			arrayToUpdate = arrayToUpdate[:0]
+		}
+	}
+}
+
+// ReportMetrics периодически пишет в лог пропускную способность опроса
+func (j *BalanceOperationJob) ReportMetrics(ctx context.Context) {
+	ticker := time.NewTicker(AccrualMetricsInterval)
+	defer ticker.Stop()
+	prev := j.Stats()
+	prevTime := time.Now()
+	for {
+		select {
 		case <-ctx.Done():
-			break loop
+			return
+		case now := <-ticker.C:
+			stats := j.Stats()
+			period := now.Sub(prevTime)
+			j.logger.Log(
+				"job", "accrual",
+				"workers", j.workers,
+				"in_flight", stats.InFlight,
+				"requests_per_sec", perSecond(stats.Requests-prev.Requests, period),
+				"updated_per_sec", perSecond(stats.Updated-prev.Updated, period),
+				"failed", stats.Failed-prev.Failed,
+				"batches", stats.Batches-prev.Batches,
+			)
+			prev, prevTime = stats, now
 		}
 	}
 }
+
+// process опрашивает систему начислений по заказу; у каждого опроса своя трассировка
+func (j *BalanceOperationJob) process(ctx context.Context, el *entity.BalanceOperation) {
+	ctx, span := tracing.Tracer().Start(ctx, "BalanceOperationJob.process", trace.WithAttributes(attribute.String("order.number", el.Order)))
+	defer span.End()
+	j.metrics.inFlight.Add(1)
+	defer j.metrics.inFlight.Add(-1)
+	j.metrics.requests.Add(1)
+	response, err := j.GetAccrualRequest(ctx, el.Order)
+	if err != nil {
+		span.RecordError(err)
+		j.metrics.failed.Add(1)
+		release(el)
+		return
+	}
+	el.Sum = response.Accrual
+	el.Status = entity.ProcessStatus(response.Status)
+}
+
+// release возвращает заказ в NEW, чтобы его снова захватил этот или другой экземпляр
+func release(el *entity.BalanceOperation) {
+	el.Sum = 0
+	el.Status = entity.NEW
+}
+
+func (j *BalanceOperationJob) flush(ctx context.Context, balanceOperations []*entity.BalanceOperation) {
+	if len(balanceOperations) == 0 {
+		return
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "BalanceOperationJob.flush", trace.WithAttributes(attribute.Int("batch.size", len(balanceOperations))))
+	defer span.End()
+	// даже если сохранить не удалось, аренда истечёт и заказы захватятся заново
+	defer j.unhold(balanceOperations)
+	// при остановке контекст уже отменён, а полученные результаты терять нельзя
+	if err := j.UpdateOrders(context.WithoutCancel(ctx), balanceOperations); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		j.logger.Log("job", "accrual", "err", err)
+		return
+	}
+	j.metrics.batches.Add(1)
+	j.metrics.updated.Add(int64(len(balanceOperations)))
+	j.promMetrics.ObserveAccrualBatch(len(balanceOperations))
+}
+
+func (j *BalanceOperationJob) hold(balanceOperations []*entity.BalanceOperation) {
+	j.heldMu.Lock()
+	defer j.heldMu.Unlock()
+	for _, el := range balanceOperations {
+		j.held[el.ID] = struct{}{}
+	}
+}
+
+func (j *BalanceOperationJob) unhold(balanceOperations []*entity.BalanceOperation) {
+	j.heldMu.Lock()
+	defer j.heldMu.Unlock()
+	for _, el := range balanceOperations {
+		delete(j.held, el.ID)
+	}
+}
+
+func (j *BalanceOperationJob) heldIDs() []int {
+	j.heldMu.Lock()
+	defer j.heldMu.Unlock()
+	ids := make([]int, 0, len(j.held))
+	for id := range j.held {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// newLeaseOwner идентификатор экземпляра сервиса, уникальный и между перезапусками
+func newLeaseOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(suffix))
+}
@@ -0,0 +1,193 @@
+package job
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository/memory"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/metrics"
+	log "github.com/go-kit/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type slowAccrualWebAPI struct {
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (webAPI *slowAccrualWebAPI) GetAccrualRequest(ctx context.Context, order string) (*entity.AccrualResponse, error) {
+	webAPI.mu.Lock()
+	webAPI.inFlight++
+	webAPI.maxInFlight = max(webAPI.maxInFlight, webAPI.inFlight)
+	webAPI.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	webAPI.mu.Lock()
+	webAPI.inFlight--
+	webAPI.mu.Unlock()
+	return &entity.AccrualResponse{Order: order, Status: string(entity.PROCESSED), Accrual: entity.NewMoney(10, 0)}, nil
+}
+
+func TestBalanceOperationJobWorkerPool(t *testing.T) {
+	ctx := context.Background()
+	repo, err := memory.NewBalanceOperationRepository(ctx, memory.NewStorage())
+	require.NoError(t, err)
+	const orders = 40
+	for i := 0; i < orders; i++ {
+		err = repo.SaveOrder(ctx, &entity.BalanceOperation{
+			Order:  strconv.Itoa(1000 + i),
+			Status: entity.NEW,
+			Type:   entity.ACCRUAL,
+			UserID: 1,
+		})
+		require.NoError(t, err)
+	}
+	webAPI := &slowAccrualWebAPI{}
+	j := NewBalanceOperationJob(&config.Config{AccrualWorkers: 8}, repo, webAPI, log.NewNopLogger(), metrics.New())
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		j.Run(ctx)
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		return j.Stats().Updated == orders
+	}, 5*time.Second, 50*time.Millisecond)
+	cancel()
+	<-done
+
+	balance, err := repo.GetBalanceByUser(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, entity.NewMoney(10*orders, 0), balance.Current)
+	assert.Greater(t, webAPI.maxInFlight, 1)
+	assert.LessOrEqual(t, webAPI.maxInFlight, 8)
+	stats := j.Stats()
+	assert.EqualValues(t, orders, stats.Requests)
+	assert.Zero(t, stats.Failed)
+	assert.Zero(t, stats.InFlight)
+}
+
+func TestFindOrdersToProcessLeases(t *testing.T) {
+	ctx := context.Background()
+	repo, err := memory.NewBalanceOperationRepository(ctx, memory.NewStorage())
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		err = repo.SaveOrder(ctx, &entity.BalanceOperation{
+			Order:  strconv.Itoa(2000 + i),
+			Status: entity.NEW,
+			Type:   entity.ACCRUAL,
+			UserID: 1,
+		})
+		require.NoError(t, err)
+	}
+
+	claimedByA, err := repo.FindOrdersToProcess(ctx, "a", 2, 100*time.Millisecond, nil)
+	require.NoError(t, err)
+	require.Len(t, claimedByA, 2)
+	claimedByB, err := repo.FindOrdersToProcess(ctx, "b", 10, time.Minute, nil)
+	require.NoError(t, err)
+	require.Len(t, claimedByB, 1, "leased orders must not be claimed twice")
+
+	time.Sleep(150 * time.Millisecond)
+	reclaimed, err := repo.FindOrdersToProcess(ctx, "b", 10, time.Minute, nil)
+	require.NoError(t, err)
+	require.Len(t, reclaimed, 2, "expired leases must be reclaimed")
+
+	// результат "a" пришёл после потери аренды и должен быть отброшен
+	for _, el := range claimedByA {
+		el.Status = entity.PROCESSED
+		el.Sum = entity.NewMoney(10, 0)
+	}
+	require.NoError(t, repo.UpdateOrders(ctx, claimedByA))
+	balance, err := repo.GetBalanceByUser(ctx, 1)
+	require.NoError(t, err)
+	assert.Zero(t, balance.Current)
+
+	for _, el := range append(reclaimed, claimedByB...) {
+		el.Status = entity.PROCESSED
+		el.Sum = entity.NewMoney(10, 0)
+	}
+	require.NoError(t, repo.UpdateOrders(ctx, append(reclaimed, claimedByB...)))
+	balance, err = repo.GetBalanceByUser(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, entity.NewMoney(30, 0), balance.Current)
+}
+
+type blockingAccrualWebAPI struct{}
+
+func (webAPI *blockingAccrualWebAPI) GetAccrualRequest(ctx context.Context, order string) (*entity.AccrualResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestBalanceOperationJobShutdownReleasesOrders(t *testing.T) {
+	ctx := context.Background()
+	repo, err := memory.NewBalanceOperationRepository(ctx, memory.NewStorage())
+	require.NoError(t, err)
+	const orders = 20
+	for i := 0; i < orders; i++ {
+		err = repo.SaveOrder(ctx, &entity.BalanceOperation{
+			Order:  strconv.Itoa(3000 + i),
+			Status: entity.NEW,
+			Type:   entity.ACCRUAL,
+			UserID: 1,
+		})
+		require.NoError(t, err)
+	}
+	j := NewBalanceOperationJob(&config.Config{AccrualWorkers: 2}, repo, &blockingAccrualWebAPI{}, log.NewNopLogger(), metrics.New())
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		j.Run(ctx)
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		return j.Stats().InFlight == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not stop")
+	}
+
+	// все захваченные заказы, включая оставшиеся в очереди, вернулись в NEW
+	released, err := repo.FindOrdersToProcess(context.Background(), "next", orders, time.Minute, nil)
+	require.NoError(t, err)
+	assert.Len(t, released, orders)
+}
+
+func TestBalanceOperationJobKeepsQueuedOrdersAfterLeaseExpires(t *testing.T) {
+	ctx := context.Background()
+	repo, err := memory.NewBalanceOperationRepository(ctx, memory.NewStorage())
+	require.NoError(t, err)
+	const orders = 5
+	for i := 0; i < orders; i++ {
+		err = repo.SaveOrder(ctx, &entity.BalanceOperation{
+			Order:  strconv.Itoa(4000 + i),
+			Status: entity.NEW,
+			Type:   entity.ACCRUAL,
+			UserID: 1,
+		})
+		require.NoError(t, err)
+	}
+	j := NewBalanceOperationJob(&config.Config{AccrualWorkers: 1, AccrualLease: 100 * time.Millisecond}, repo, &blockingAccrualWebAPI{}, log.NewNopLogger(), metrics.New())
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go j.Run(ctx)
+	require.Eventually(t, func() bool {
+		return j.Stats().InFlight == 1 && j.QueueLength() == orders-1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// аренда истекла, пока заказы стоят в очереди, но тот же экземпляр не захватывает их второй раз
+	time.Sleep(1200 * time.Millisecond)
+	assert.Equal(t, orders-1, j.QueueLength())
+	assert.EqualValues(t, 1, j.Stats().Requests)
+}
@@ -0,0 +1,37 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository"
+	log "github.com/go-kit/log"
+)
+
+const IdempotencyCleanupInterval = 10 * time.Minute
+
+type IdempotencyJob struct {
+	logger log.Logger
+	repository.IdempotencyRepository
+}
+
+func NewIdempotencyJob(config *config.Config, r repository.IdempotencyRepository, logger log.Logger) *IdempotencyJob {
+	return &IdempotencyJob{logger, r}
+}
+
+// DeleteExpired периодически удаляет сохранённые ответы с истёкшим сроком повтора
+func (j *IdempotencyJob) DeleteExpired(ctx context.Context) {
+	ticker := time.NewTicker(IdempotencyCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := j.IdempotencyRepository.DeleteExpired(ctx); err != nil {
+				j.logger.Log("job", "idempotency", "err", err)
+			}
+		}
+	}
+}
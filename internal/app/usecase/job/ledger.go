@@ -0,0 +1,56 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository"
+	log "github.com/go-kit/log"
+)
+
+type LedgerJob struct {
+	interval time.Duration
+	logger   log.Logger
+	repository.LedgerRepository
+}
+
+func NewLedgerJob(config *config.Config, r repository.LedgerRepository, logger log.Logger) *LedgerJob {
+	interval := config.LedgerCheckInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &LedgerJob{interval, logger, r}
+}
+
+// CheckInvariants периодически сверяет материализованные балансы с книгой проводок
+func (j *LedgerJob) CheckInvariants(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			j.checkInvariants(ctx)
+		}
+	}
+}
+
+func (j *LedgerJob) checkInvariants(ctx context.Context) {
+	violations, err := j.LedgerRepository.CheckInvariants(ctx)
+	if err != nil {
+		j.logger.Log("job", "ledger", "err", err)
+		return
+	}
+	for _, v := range violations {
+		j.logger.Log(
+			"job", "ledger",
+			"invariant", v.Invariant,
+			"user_id", v.UserID,
+			"balance_operation_id", v.BalanceOperationID,
+			"expected", v.Expected,
+			"actual", v.Actual,
+		)
+	}
+}
@@ -0,0 +1,57 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/metrics"
+	log "github.com/go-kit/log"
+)
+
+const DefaultOrderCountInterval = 30 * time.Second
+
+// OrderCountJob пересчитывает заказы по статусам для метрик: сбор метрик читает готовые значения
+// и не ходит в базу на каждый запрос Prometheus
+type OrderCountJob struct {
+	interval time.Duration
+	logger   log.Logger
+	metrics  *metrics.Metrics
+	repository.BalanceOperationRepository
+}
+
+func NewOrderCountJob(config *config.Config, r repository.BalanceOperationRepository, logger log.Logger, m *metrics.Metrics) *OrderCountJob {
+	interval := config.OrderCountInterval
+	if interval <= 0 {
+		interval = DefaultOrderCountInterval
+	}
+	return &OrderCountJob{interval, logger, m, r}
+}
+
+// Run считает заказы сразу при запуске и затем раз в interval
+func (j *OrderCountJob) Run(ctx context.Context) {
+	j.refresh(ctx)
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			j.refresh(ctx)
+		}
+	}
+}
+
+// refresh при ошибке оставляет прежние значения
+func (j *OrderCountJob) refresh(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, j.interval)
+	defer cancel()
+	counts, err := j.CountOrdersByStatus(ctx)
+	if err != nil {
+		j.logger.Log("job", "order_count", "err", err)
+		return
+	}
+	j.metrics.SetOrders(counts)
+}
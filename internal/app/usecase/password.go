@@ -0,0 +1,167 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	nethttp "net/http"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/controller/http"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/notifier"
+)
+
+const DefaultPasswordResetTTL = time.Hour
+
+// ChangePassword меняет пароль по старому паролю. Остальные сессии пользователя завершаются,
+// текущая остаётся; API-ключи отзываются. Неверный старый пароль учитывается тем же ограничителем, что и вход.
+func (s *UserService) ChangePassword(ctx context.Context, dto *http.ChangePasswordRequest) error {
+	user, err := s.currentUser(ctx)
+	if err != nil {
+		return err
+	}
+	limit := AttemptLimit{Key: "login:" + user.Login, MaxFailures: s.c.LoginMaxFailures}
+	err = s.limiter.Check(ctx, limit)
+	if err != nil {
+		return err
+	}
+	_, err = s.hasher.Verify(dto.OldPassword, user.Password)
+	if err != nil {
+		if err = s.limiter.Fail(ctx, limit); err != nil {
+			return err
+		}
+		return customerr.NewCodedError(errors.New("old password does not match"), nethttp.StatusForbidden, customerr.CodeCurrentPasswordMismatch)
+	}
+	err = s.policy.Validate(dto.NewPassword, user.Login)
+	if err != nil {
+		return err
+	}
+	err = s.setPassword(ctx, user.ID, dto.NewPassword)
+	if err != nil {
+		return err
+	}
+	sessionID, _ := ctx.Value(SessionID).(string)
+	return s.revokeAccess(ctx, user.ID, sessionID)
+}
+
+// RequestPasswordReset отправляет пользователю одноразовый токен сброса пароля.
+// Для неизвестного логина ошибки нет, чтобы по ответу нельзя было перебирать логины.
+// Каждый запрос расходует попытку по логину и по адресу, как неудачный вход: иначе уведомлениями
+// и токенами сброса можно было бы заваливать любой логин.
+func (s *UserService) RequestPasswordReset(ctx context.Context, dto *http.PasswordResetRequest) error {
+	limits := []AttemptLimit{
+		{Key: "reset:" + dto.Login, MaxFailures: s.c.LoginMaxFailures},
+		{Key: "reset-ip:" + dto.IP, MaxFailures: s.c.LoginIPMaxFailures},
+	}
+	err := s.limiter.Check(ctx, limits...)
+	if err != nil {
+		return err
+	}
+	err = s.limiter.Fail(ctx, limits...)
+	if err != nil {
+		return err
+	}
+	user, err := s.FindByLogin(ctx, dto.Login)
+	if err != nil {
+		customErr := &customerr.CustomError{}
+		if errors.As(err, &customErr) && customErr.HTTPStatus == nethttp.StatusUnauthorized {
+			return nil
+		}
+		return err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	ttl := s.c.PasswordResetTTL
+	if ttl <= 0 {
+		ttl = DefaultPasswordResetTTL
+	}
+	token := &entity.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(secret),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	err = s.resetTokens.Save(ctx, token)
+	if err != nil {
+		return err
+	}
+	return s.notifier.Send(ctx, &notifier.Message{
+		To:      user.Login,
+		Subject: "Password reset",
+		Body:    fmt.Sprintf("Password reset token: %s. It expires at %s.", secret, token.ExpiresAt.UTC().Format(time.RFC3339)),
+		SentAt:  time.Now(),
+	})
+}
+
+// ResetPassword задаёт новый пароль по токену сброса, завершает все сессии пользователя,
+// отзывает его API-ключи и снимает блокировку входа по его логину
+func (s *UserService) ResetPassword(ctx context.Context, dto *http.PasswordResetConfirmRequest) error {
+	// до погашения токена проверяем всё, кроме совпадения с логином, чтобы слабый пароль не сжигал токен
+	err := s.policy.Validate(dto.Password, "")
+	if err != nil {
+		return err
+	}
+	userID, err := s.resetTokens.Consume(ctx, hashToken(dto.Token))
+	if err != nil {
+		return err
+	}
+	user, err := s.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	err = s.policy.Validate(dto.Password, user.Login)
+	if err != nil {
+		return err
+	}
+	err = s.setPassword(ctx, userID, dto.Password)
+	if err != nil {
+		return err
+	}
+	err = s.revokeAccess(ctx, userID, "")
+	if err != nil {
+		return err
+	}
+	return s.limiter.Succeed(ctx, "login:"+user.Login, "reset:"+user.Login)
+}
+
+// DeleteUser мягко удаляет текущего пользователя, завершает все его сессии и отзывает API-ключи;
+// выданные ему access-токены перестают проходить ExistsUser
+func (s *UserService) DeleteUser(ctx context.Context) error {
+	userID, err := s.GetUserIDFromContext(ctx)
+	if err != nil {
+		return customerr.NewError(err, nethttp.StatusUnauthorized)
+	}
+	err = s.Delete(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return s.revokeAccess(ctx, userID, "")
+}
+
+// revokeAccess завершает сессии пользователя, кроме exceptSessionID, и отзывает его API-ключи
+func (s *UserService) revokeAccess(ctx context.Context, userID int, exceptSessionID string) error {
+	err := s.refreshTokens.RevokeUser(ctx, userID, exceptSessionID)
+	if err != nil {
+		return err
+	}
+	return s.apiKeys.RevokeUser(ctx, userID)
+}
+
+func (s *UserService) currentUser(ctx context.Context) (*entity.User, error) {
+	userID, err := s.GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, customerr.NewError(err, nethttp.StatusUnauthorized)
+	}
+	return s.FindByID(ctx, userID)
+}
+
+func (s *UserService) setPassword(ctx context.Context, userID int, password string) error {
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	return s.UpdatePassword(ctx, userID, hash)
+}
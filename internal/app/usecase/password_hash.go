@@ -0,0 +1,140 @@
+package usecase
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	DefaultBcryptCost    = 12
+	DefaultArgon2Memory  = 64 * 1024
+	DefaultArgon2Time    = 1
+	DefaultArgon2Threads = 2
+
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var errPasswordMismatch = errors.New("password does not match")
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+// passwordHasher хэширует пароли настроенным алгоритмом, но проверяет хэши обоих форматов,
+// чтобы после смены алгоритма или параметров старые пароли продолжали подходить
+type passwordHasher struct {
+	algorithm  string
+	bcryptCost int
+	argon2     argon2Params
+	dummyOnce  sync.Once
+	dummy      string
+}
+
+func newPasswordHasher(c *config.Config) *passwordHasher {
+	h := &passwordHasher{
+		algorithm:  c.PasswordHash,
+		bcryptCost: c.BcryptCost,
+		argon2:     argon2Params{uint32(c.Argon2Memory), uint32(c.Argon2Time), uint8(c.Argon2Threads)},
+	}
+	if h.algorithm == "" {
+		h.algorithm = config.PasswordHashBcrypt
+	}
+	if h.bcryptCost == 0 {
+		h.bcryptCost = DefaultBcryptCost
+	}
+	if h.argon2.memory == 0 {
+		h.argon2.memory = DefaultArgon2Memory
+	}
+	if h.argon2.time == 0 {
+		h.argon2.time = DefaultArgon2Time
+	}
+	if h.argon2.threads == 0 {
+		h.argon2.threads = DefaultArgon2Threads
+	}
+	return h
+}
+
+func (h *passwordHasher) Hash(password string) (string, error) {
+	if h.algorithm == config.PasswordHashArgon2id {
+		salt := make([]byte, argon2SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, h.argon2.time, h.argon2.memory, h.argon2.threads, argon2KeyLength)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.argon2.memory, h.argon2.time, h.argon2.threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+	return string(bytes), err
+}
+
+// Verify проверяет пароль и сообщает, что хэш нужно пересчитать: он сделан другим алгоритмом
+// или с параметрами, отличными от текущих
+func (h *passwordHasher) Verify(password string, hash string) (bool, error) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := decodeArgon2Hash(hash)
+		if err != nil {
+			return false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, errPasswordMismatch
+		}
+		return h.algorithm != config.PasswordHashArgon2id || params != h.argon2, nil
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil {
+		return false, err
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return false, err
+	}
+	return h.algorithm != config.PasswordHashBcrypt || cost != h.bcryptCost, nil
+}
+
+// VerifyDummy тратит на проверку столько же, сколько Verify с текущими параметрами,
+// чтобы неизвестный логин нельзя было отличить от неверного пароля по времени ответа
+func (h *passwordHasher) VerifyDummy(password string) {
+	h.dummyOnce.Do(func() {
+		h.dummy, _ = h.Hash("gophermart-dummy-password")
+	})
+	_, _ = h.Verify(password, h.dummy)
+}
+
+func decodeArgon2Hash(hash string) (argon2Params, []byte, []byte, error) {
+	params := argon2Params{}
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, errors.New("invalid argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errors.New("unsupported argon2id version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,87 @@
+package usecase
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	nethttp "net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/config"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+)
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// passwordPolicy требования к новым паролям; на вход со старым паролем не влияет
+type passwordPolicy struct {
+	minLength  int
+	minClasses int
+	denyLogin  bool
+	denied     map[string]struct{}
+}
+
+func newPasswordPolicy(c *config.Config) *passwordPolicy {
+	p := &passwordPolicy{
+		minLength:  c.PasswordMinLength,
+		minClasses: c.PasswordMinClasses,
+		denyLogin:  c.PasswordDenyLogin,
+		denied:     make(map[string]struct{}),
+	}
+	denied := c.PasswordDenied
+	if c.PasswordDenyCommon {
+		denied = append(strings.Fields(commonPasswords), denied...)
+	}
+	for _, password := range denied {
+		p.denied[strings.ToLower(password)] = struct{}{}
+	}
+	return p
+}
+
+// Validate возвращает ошибку 400 с причиной отказа
+func (p *passwordPolicy) Validate(password string, login string) error {
+	if utf8.RuneCountInString(password) < p.minLength {
+		return newPasswordPolicyError(fmt.Sprintf("password must be at least %d characters long", p.minLength))
+	}
+	if classes := characterClasses(password); classes < p.minClasses {
+		return newPasswordPolicyError(fmt.Sprintf("password must contain at least %d of: lowercase, uppercase, digits, symbols", p.minClasses))
+	}
+	lower := strings.ToLower(password)
+	if _, ok := p.denied[lower]; ok {
+		return newPasswordPolicyError("password is too common")
+	}
+	if p.denyLogin && login != "" && lower == strings.ToLower(login) {
+		return newPasswordPolicyError("password must not match login")
+	}
+	return nil
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	classes := 0
+	for _, ok := range []bool{lower, upper, digit, other} {
+		if ok {
+			classes++
+		}
+	}
+	return classes
+}
+
+func newPasswordPolicyError(reason string) error {
+	return customerr.NewCodedError(errors.New(reason), nethttp.StatusBadRequest, customerr.CodeWeakPassword)
+}
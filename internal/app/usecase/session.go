@@ -0,0 +1,136 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	nethttp "net/http"
+	"strings"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/controller/http"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+)
+
+// SessionTouchInterval не чаще этого обновляется время последнего запроса сессии, чтобы не писать в базу на каждый запрос
+const SessionTouchInterval = time.Minute
+
+// столько байт User-Agent и названия устройства сохраняется в сессии
+const (
+	maxUserAgentLength = 512
+	maxDeviceLength    = 128
+)
+
+// ListSessions возвращает действующие сессии пользователя; сессия запроса отмечена как текущая
+func (s *UserService) ListSessions(ctx context.Context) ([]*http.SessionResponse, error) {
+	userID, err := s.GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, customerr.NewError(err, nethttp.StatusUnauthorized)
+	}
+	sessionID, _ := ctx.Value(SessionID).(string)
+	sessions, err := s.sessions.FindByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*http.SessionResponse, 0, len(sessions))
+	for _, el := range sessions {
+		result = append(result, &http.SessionResponse{
+			ID:         el.ID,
+			Device:     el.Device,
+			IP:         el.IP,
+			UserAgent:  el.UserAgent,
+			CreatedAt:  el.CreatedAt.Format(time.RFC3339),
+			LastSeenAt: el.LastSeenAt.Format(time.RFC3339),
+			Current:    el.ID == sessionID,
+		})
+	}
+	return result, nil
+}
+
+// EndSession завершает сессию пользователя на другом устройстве (или текущую); её access-токены
+// перестают приниматься сразу, refresh-токены отзываются
+func (s *UserService) EndSession(ctx context.Context, ID string) error {
+	userID, err := s.GetUserIDFromContext(ctx)
+	if err != nil {
+		return customerr.NewError(err, nethttp.StatusUnauthorized)
+	}
+	if ID == "" {
+		return customerr.NewError(errors.New("session id is empty"), nethttp.StatusBadRequest)
+	}
+	return s.sessions.End(ctx, userID, ID)
+}
+
+// TouchSession отмечает запрос в сессии; вызывается SecurityMiddleware после проверки токена
+func (s *UserService) TouchSession(ctx context.Context, sessionID string, ip string) error {
+	return s.sessions.Touch(ctx, sessionID, ip, time.Now().Add(-SessionTouchInterval))
+}
+
+func (s *UserService) saveSession(ctx context.Context, userID int, familyID string, client *http.ClientInfo) error {
+	return s.sessions.Save(ctx, &entity.Session{
+		ID:        familyID,
+		UserID:    userID,
+		IP:        client.IP,
+		UserAgent: truncate(client.UserAgent, maxUserAgentLength),
+		Device:    truncate(deviceFromUserAgent(client.UserAgent), maxDeviceLength),
+	})
+}
+
+func truncate(s string, size int) string {
+	if len(s) <= size {
+		return s
+	}
+	return strings.ToValidUTF8(s[:size], "")
+}
+
+// userAgentMarker название браузера или ОС и подстроки User-Agent, по которым оно узнаётся
+type userAgentMarker struct {
+	name    string
+	markers []string
+}
+
+// Порядок важен: User-Agent Edge и Opera содержит Chrome и Safari, Chrome — Safari, Android — Linux
+var (
+	userAgentBrowsers = []userAgentMarker{
+		{"Edge", []string{"Edg/", "Edge/"}},
+		{"Opera", []string{"OPR/", "Opera"}},
+		{"Firefox", []string{"Firefox/", "FxiOS/"}},
+		{"Chrome", []string{"Chrome/", "CriOS/"}},
+		{"Safari", []string{"Safari/"}},
+	}
+	userAgentSystems = []userAgentMarker{
+		{"iOS", []string{"iPhone", "iPad"}},
+		{"Android", []string{"Android"}},
+		{"Windows", []string{"Windows"}},
+		{"macOS", []string{"Mac OS X", "Macintosh"}},
+		{"Linux", []string{"Linux"}},
+	}
+)
+
+// deviceFromUserAgent грубо определяет браузер и ОС, чтобы пользователь узнал сессию в списке
+func deviceFromUserAgent(userAgent string) string {
+	browser := matchUserAgent(userAgent, userAgentBrowsers)
+	system := matchUserAgent(userAgent, userAgentSystems)
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "" || system != "":
+		return browser + system
+	case userAgent != "":
+		// API-клиенты вроде curl/8.0 узнаются по названию
+		name, _, _ := strings.Cut(userAgent, "/")
+		return name
+	default:
+		return "Unknown device"
+	}
+}
+
+func matchUserAgent(userAgent string, known []userAgentMarker) string {
+	for _, el := range known {
+		for _, marker := range el.markers {
+			if strings.Contains(userAgent, marker) {
+				return el.name
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,236 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	nethttp "net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/controller/http"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
+	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+)
+
+const (
+	// TwoFactorTokenTTL сколько действует токен между вводом пароля и кода
+	TwoFactorTokenTTL = 5 * time.Minute
+	// RecoveryCodesCount сколько кодов восстановления выдаётся при подключении 2FA
+	RecoveryCodesCount = 10
+	// twoFactorPurpose отличает токен второго шага входа от access-токена
+	twoFactorPurpose = "2fa"
+)
+
+// SetupTwoFactor выдаёт новый секрет TOTP. 2FA начинает действовать только после ConfirmTwoFactor,
+// до этого повторный вызов заменяет секрет.
+func (s *UserService) SetupTwoFactor(ctx context.Context) (*http.TwoFactorSetupResponse, error) {
+	user, err := s.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	err = s.twoFactors.Save(ctx, &entity.TwoFactor{UserID: user.ID, Secret: secret})
+	if err != nil {
+		return nil, err
+	}
+	return &http.TwoFactorSetupResponse{
+		Secret: secret,
+		URI:    totpURI(s.c.TOTPIssuer, user.Login, secret),
+	}, nil
+}
+
+// ConfirmTwoFactor включает 2FA по первому коду из приложения и выдаёт коды восстановления;
+// они показываются только в этом ответе
+func (s *UserService) ConfirmTwoFactor(ctx context.Context, dto *http.TwoFactorCodeRequest) (*http.RecoveryCodesResponse, error) {
+	userID, err := s.GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, customerr.NewError(err, nethttp.StatusUnauthorized)
+	}
+	twoFactor, err := s.twoFactors.FindByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if twoFactor.IsEnabled() {
+		return nil, customerr.NewCodedError(errors.New("two-factor authentication is already enabled"), nethttp.StatusConflict, customerr.CodeTwoFactorAlreadyEnabled)
+	}
+	step, err := matchTOTP(twoFactor.Secret, dto.Code, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if step == 0 {
+		return nil, customerr.NewCodedError(errors.New("invalid two-factor code"), nethttp.StatusForbidden, customerr.CodeTwoFactorCodeInvalid)
+	}
+	codes := make([]string, 0, RecoveryCodesCount)
+	hashes := make([]string, 0, RecoveryCodesCount)
+	for i := 0; i < RecoveryCodesCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(code))
+	}
+	err = s.twoFactors.Confirm(ctx, userID, step, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return &http.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// DisableTwoFactor отключает 2FA по коду TOTP или коду восстановления
+func (s *UserService) DisableTwoFactor(ctx context.Context, dto *http.TwoFactorCodeRequest) error {
+	userID, err := s.GetUserIDFromContext(ctx)
+	if err != nil {
+		return customerr.NewError(err, nethttp.StatusUnauthorized)
+	}
+	twoFactor, err := s.twoFactors.FindByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if twoFactor.IsEnabled() {
+		err = s.checkTwoFactorCode(ctx, twoFactor, dto.Code, true, nethttp.StatusForbidden)
+		if err != nil {
+			return err
+		}
+	}
+	return s.twoFactors.Delete(ctx, userID)
+}
+
+// LoginTwoFactor второй шаг входа: по токену из LoginUser и коду TOTP или коду восстановления открывает сессию
+func (s *UserService) LoginTwoFactor(ctx context.Context, dto *http.TwoFactorLoginRequest) (*http.AuthTokens, error) {
+	claims, err := s.parseToken(dto.Token)
+	if err != nil || claims.Purpose != twoFactorPurpose {
+		return nil, customerr.NewCodedError(errors.New("invalid two-factor token"), nethttp.StatusUnauthorized, customerr.CodeTwoFactorTokenInvalid)
+	}
+	ipLimit := AttemptLimit{Key: "ip:" + dto.IP, MaxFailures: s.c.LoginIPMaxFailures}
+	err = s.limiter.Check(ctx, ipLimit)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.FindByID(ctx, claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.IsBlocked() {
+		return nil, customerr.NewCodedError(errors.New("user is blocked"), nethttp.StatusForbidden, customerr.CodeUserBlocked)
+	}
+	twoFactor, err := s.twoFactors.FindByUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.checkTwoFactorCode(ctx, twoFactor, dto.Code, true, nethttp.StatusUnauthorized)
+	if err != nil {
+		if hasStatus(err, nethttp.StatusUnauthorized) {
+			return nil, s.loginFailed(ctx, &http.LoginRequest{Login: user.Login, ClientInfo: dto.ClientInfo}, "invalid two-factor code", []AttemptLimit{ipLimit}, err)
+		}
+		return nil, err
+	}
+	return s.startSession(ctx, user.ID, &dto.ClientInfo)
+}
+
+// VerifyWithdrawal требует свежий код TOTP для списания больше порога у пользователей с включённой 2FA.
+// Коды восстановления здесь не принимаются: они только для входа и отключения 2FA.
+func (s *UserService) VerifyWithdrawal(ctx context.Context, userID int, withdraw *http.WithdrawRequest) error {
+	if withdraw.Sum <= s.c.WithdrawTOTPAbove {
+		return nil
+	}
+	twoFactor, err := s.twoFactors.FindByUser(ctx, userID)
+	if hasStatus(err, nethttp.StatusNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !twoFactor.IsEnabled() {
+		return nil
+	}
+	if withdraw.TOTPCode == "" {
+		return customerr.NewCodedError(errors.New("two-factor code is required"), nethttp.StatusForbidden, customerr.CodeTwoFactorRequired)
+	}
+	return s.checkTwoFactorCode(ctx, twoFactor, withdraw.TOTPCode, false, nethttp.StatusForbidden)
+}
+
+// twoFactorEnabled сообщает, нужен ли пользователю второй шаг входа
+func (s *UserService) twoFactorEnabled(ctx context.Context, userID int) (bool, error) {
+	twoFactor, err := s.twoFactors.FindByUser(ctx, userID)
+	if hasStatus(err, nethttp.StatusNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return twoFactor.IsEnabled(), nil
+}
+
+func (s *UserService) issueTwoFactorToken(userID int) (*http.AuthTokens, error) {
+	expiresAt := time.Now().Add(TwoFactorTokenTTL)
+	token, err := s.signClaims(&Claims{UserID: userID, Purpose: twoFactorPurpose}, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &http.AuthTokens{TwoFactorToken: token, TwoFactorTokenExpiresAt: expiresAt}, nil
+}
+
+// checkTwoFactorCode проверяет код с отдельным ограничителем попыток на пользователя:
+// шесть цифр иначе можно было бы перебрать. Неверный код возвращается с кодом ответа status.
+func (s *UserService) checkTwoFactorCode(ctx context.Context, twoFactor *entity.TwoFactor, code string, allowRecovery bool, status int) error {
+	limit := AttemptLimit{Key: "2fa:" + strconv.Itoa(twoFactor.UserID), MaxFailures: s.c.LoginMaxFailures}
+	err := s.limiter.Check(ctx, limit)
+	if err != nil {
+		return err
+	}
+	ok, err := s.useTwoFactorCode(ctx, twoFactor, code, allowRecovery)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err = s.limiter.Fail(ctx, limit); err != nil {
+			return err
+		}
+		return customerr.NewCodedError(errors.New("invalid two-factor code"), status, customerr.CodeTwoFactorCodeInvalid)
+	}
+	return s.limiter.Succeed(ctx, limit.Key)
+}
+
+// useTwoFactorCode принимает код TOTP, который ещё не использовался, или неиспользованный код восстановления
+func (s *UserService) useTwoFactorCode(ctx context.Context, twoFactor *entity.TwoFactor, code string, allowRecovery bool) (bool, error) {
+	step, err := matchTOTP(twoFactor.Secret, code, time.Now())
+	if err != nil {
+		return false, err
+	}
+	if step != 0 {
+		err = s.twoFactors.UseStep(ctx, twoFactor.UserID, step)
+	} else if allowRecovery {
+		err = s.twoFactors.UseRecoveryCode(ctx, twoFactor.UserID, hashToken(normalizeRecoveryCode(code)))
+	} else {
+		return false, nil
+	}
+	if hasStatus(err, nethttp.StatusUnauthorized) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// newRecoveryCode код вида xxxx-xxxx из строчных букв base32 и цифр
+func newRecoveryCode() (string, error) {
+	buf := make([]byte, 5)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(totpEncoding.EncodeToString(buf))
+	return code[:4] + "-" + code[4:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
+
+func hasStatus(err error, status int) bool {
+	customErr := &customerr.CustomError{}
+	return errors.As(err, &customErr) && customErr.HTTPStatus == status
+}
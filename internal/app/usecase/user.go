@@ -2,6 +2,10 @@ package usecase
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	nethttp "net/http"
 	"time"
@@ -10,29 +14,52 @@ import (
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/controller/http"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/entity"
 	customerr "github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/error"
+	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/notifier"
 	"github.com/GusevGrishaEm1/gophermart-web-app.git/internal/app/infrastructure/repository"
 
 	"github.com/golang-jwt/jwt/v4"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type UserInfo string
 
-const UserID UserInfo = "USER_ID"
+const (
+	UserID    UserInfo = "USER_ID"
+	SessionID UserInfo = "SESSION_ID"
+	// Scopes права API-ключа; у запросов с JWT сессии их нет
+	Scopes UserInfo = "SCOPES"
+)
+
+const (
+	DefaultAccessTokenTTL  = 15 * time.Minute
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
 
 type UserService struct {
-	c *config.Config
+	c             *config.Config
+	refreshTokens repository.RefreshTokenRepository
+	resetTokens   repository.PasswordResetRepository
+	twoFactors    repository.TwoFactorRepository
+	sessions      repository.SessionRepository
+	apiKeys       repository.APIKeyRepository
+	limiter       *AttemptLimiter
+	notifier      notifier.Notifier
+	hasher        *passwordHasher
+	policy        *passwordPolicy
 	repository.UserRepository
 }
 
-func NewUserService(c *config.Config, r repository.UserRepository) *UserService {
-	return &UserService{c, r}
+func NewUserService(c *config.Config, r repository.UserRepository, refreshTokens repository.RefreshTokenRepository, resetTokens repository.PasswordResetRepository, twoFactors repository.TwoFactorRepository, sessions repository.SessionRepository, apiKeys repository.APIKeyRepository, limiter *AttemptLimiter, n notifier.Notifier) *UserService {
+	return &UserService{c, refreshTokens, resetTokens, twoFactors, sessions, apiKeys, limiter, n, newPasswordHasher(c), newPasswordPolicy(c), r}
 }
 
-func (s *UserService) RegisterUser(ctx context.Context, dto *http.RegisterRequest) (string, error) {
-	hash, err := hashPassword(dto.Password)
+func (s *UserService) RegisterUser(ctx context.Context, dto *http.RegisterRequest) (*http.AuthTokens, error) {
+	err := s.policy.Validate(dto.Password, dto.Login)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	hash, err := s.hasher.Hash(dto.Password)
+	if err != nil {
+		return nil, err
 	}
 	user := &entity.User{
 		Login:    dto.Login,
@@ -40,57 +67,216 @@ func (s *UserService) RegisterUser(ctx context.Context, dto *http.RegisterReques
 	}
 	id, err := s.Save(ctx, user)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	jwt, err := buildJWTString(id)
+	return s.startSession(ctx, id, &dto.ClientInfo)
+}
+
+// LoginUser проверяет блокировку логина и IP до сравнения пароля,
+// чтобы подбор не тратил процессор на bcrypt. Для неизвестного логина пароль
+// сверяется с фиктивным хэшем, чтобы время ответа не выдавало существование логина. Если у пользователя включена 2FA,
+// вместо сессии выдаётся токен для второго шага входа (LoginTwoFactor).
+func (s *UserService) LoginUser(ctx context.Context, dto *http.LoginRequest) (*http.AuthTokens, error) {
+	limits := []AttemptLimit{
+		{Key: "login:" + dto.Login, MaxFailures: s.c.LoginMaxFailures},
+		{Key: "ip:" + dto.IP, MaxFailures: s.c.LoginIPMaxFailures},
+	}
+	err := s.limiter.Check(ctx, limits...)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	user, err := s.FindByLogin(ctx, dto.Login)
+	if err != nil {
+		customErr := &customerr.CustomError{}
+		if errors.As(err, &customErr) && customErr.HTTPStatus == nethttp.StatusUnauthorized {
+			s.hasher.VerifyDummy(dto.Password)
+			return nil, s.loginFailed(ctx, dto, "unknown login", limits, invalidCredentials())
+		}
+		return nil, err
+	}
+	rehash, err := s.hasher.Verify(dto.Password, user.Password)
+	if err != nil {
+		return nil, s.loginFailed(ctx, dto, "invalid password", limits, invalidCredentials())
+	}
+	if user.IsBlocked() {
+		return nil, customerr.NewCodedError(errors.New("user is blocked"), nethttp.StatusForbidden, customerr.CodeUserBlocked)
+	}
+	if rehash {
+		// пересчёт хэша под текущие параметры не должен мешать входу: при ошибке попробуем в следующий раз
+		_ = s.setPassword(ctx, user.ID, dto.Password)
+	}
+	err = s.limiter.Succeed(ctx, limits[0].Key)
+	if err != nil {
+		return nil, err
+	}
+	twoFactor, err := s.twoFactorEnabled(ctx, user.ID)
+	if err != nil {
+		return nil, err
 	}
-	return jwt, nil
+	if twoFactor {
+		return s.issueTwoFactorToken(user.ID)
+	}
+	return s.startSession(ctx, user.ID, &dto.ClientInfo)
 }
 
-func hashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+// loginFailed учитывает неудачную попытку в ограничителе и журнале аудита и возвращает исходную ошибку
+func (s *UserService) loginFailed(ctx context.Context, dto *http.LoginRequest, reason string, limits []AttemptLimit, cause error) error {
+	err := s.limiter.Fail(ctx, limits...)
+	if err != nil {
+		return err
+	}
+	err = s.limiter.SaveLoginAttempt(ctx, &entity.LoginAttempt{Login: dto.Login, IP: dto.IP, Reason: reason})
+	if err != nil {
+		return err
+	}
+	return cause
 }
 
-func (s *UserService) LoginUser(ctx context.Context, dto *http.LoginRequest) (string, error) {
-	user, err := s.FindByLogin(ctx, dto.Login)
+// invalidCredentials одна ошибка для неизвестного логина и неверного пароля, чтобы по ответу нельзя было перебирать логины
+func invalidCredentials() error {
+	return customerr.NewCodedError(errors.New("invalid login or password"), nethttp.StatusUnauthorized, customerr.CodeInvalidCredentials)
+}
+
+// RefreshTokens обменивает refresh-токен на новую пару токенов той же сессии
+func (s *UserService) RefreshTokens(ctx context.Context, refreshToken string) (*http.AuthTokens, error) {
+	next, secret, err := s.newRefreshToken()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	err = checkPasswordHash(dto.Password, user.Password)
+	err = s.refreshTokens.Rotate(ctx, hashToken(refreshToken), next)
 	if err != nil {
-		return "", customerr.NewError(err, nethttp.StatusUnauthorized)
+		return nil, err
 	}
-	jwt, err := buildJWTString(user.ID)
+	return s.issueTokens(next, secret)
+}
+
+// Logout завершает текущую сессию: отзываются её refresh-токены, а выданные в ней access-токены
+// перестают приниматься SecurityMiddleware
+func (s *UserService) Logout(ctx context.Context) error {
+	sessionID, ok := ctx.Value(SessionID).(string)
+	if !ok || sessionID == "" {
+		return customerr.NewError(errors.New("session_id is nil"), nethttp.StatusUnauthorized)
+	}
+	return s.refreshTokens.RevokeFamily(ctx, sessionID)
+}
+
+// Authenticate проверяет access-токен и то, что его сессия не завершена
+func (s *UserService) Authenticate(ctx context.Context, token string) (int, string, error) {
+	claims, err := s.parseToken(token)
+	if err != nil {
+		return 0, "", customerr.NewError(err, nethttp.StatusUnauthorized)
+	}
+	if claims.UserID == 0 || claims.SessionID == "" || claims.Purpose != "" {
+		return 0, "", customerr.NewError(errors.New("token has no session"), nethttp.StatusUnauthorized)
+	}
+	active, err := s.refreshTokens.IsFamilyActive(ctx, claims.SessionID)
 	if err != nil {
+		return 0, "", err
+	}
+	if !active {
+		return 0, "", customerr.NewCodedError(errors.New("session is revoked"), nethttp.StatusUnauthorized, customerr.CodeSessionRevoked)
+	}
+	return claims.UserID, claims.SessionID, nil
+}
+
+func (s *UserService) startSession(ctx context.Context, userID int, client *http.ClientInfo) (*http.AuthTokens, error) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, secret, err := s.newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	refreshToken.UserID = userID
+	refreshToken.FamilyID = familyID
+	err = s.refreshTokens.Save(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	err = s.saveSession(ctx, userID, familyID, client)
+	if err != nil {
+		return nil, err
+	}
+	return s.issueTokens(refreshToken, secret)
+}
+
+func (s *UserService) newRefreshToken() (*entity.RefreshToken, string, error) {
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	ttl := s.c.RefreshTokenTTL
+	if ttl <= 0 {
+		ttl = DefaultRefreshTokenTTL
+	}
+	return &entity.RefreshToken{
+		TokenHash: hashToken(secret),
+		ExpiresAt: time.Now().Add(ttl),
+	}, secret, nil
+}
+
+func (s *UserService) issueTokens(refreshToken *entity.RefreshToken, secret string) (*http.AuthTokens, error) {
+	ttl := s.c.AccessTokenTTL
+	if ttl <= 0 {
+		ttl = DefaultAccessTokenTTL
+	}
+	expiresAt := time.Now().Add(ttl)
+	accessToken, err := s.buildJWTString(refreshToken.UserID, refreshToken.FamilyID, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &http.AuthTokens{
+		AccessToken:           accessToken,
+		AccessTokenExpiresAt:  expiresAt,
+		RefreshToken:          secret,
+		RefreshTokenExpiresAt: refreshToken.ExpiresAt,
+	}, nil
+}
+
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
 		return "", err
 	}
-	return jwt, nil
+	return base64.RawURLEncoding.EncodeToString(buf), nil
 }
 
-func checkPasswordHash(password, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+// в базе хранится только хэш refresh-токена и токена сброса пароля, чтобы утечка таблицы не давала входа
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 type Claims struct {
 	jwt.RegisteredClaims
-	UserID int
-}
-
-func buildJWTString(userID int) (string, error) {
-	token := jwt.NewWithClaims(
-		jwt.SigningMethodHS256, &Claims{
-			RegisteredClaims: jwt.RegisteredClaims{
-				ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-				IssuedAt:  jwt.NewNumericDate(time.Now()),
-				NotBefore: jwt.NewNumericDate(time.Now()),
-			},
-			UserID: userID,
-		},
-	)
-	tokenString, err := token.SignedString([]byte("secretkey"))
+	UserID    int
+	SessionID string `json:"sid,omitempty"`
+	// Purpose задан у токенов, которые не дают доступа к API, например у токена второго шага входа
+	Purpose string `json:"pur,omitempty"`
+}
+
+func (s *UserService) buildJWTString(userID int, sessionID string, expiresAt time.Time) (string, error) {
+	return s.signClaims(&Claims{UserID: userID, SessionID: sessionID}, expiresAt)
+}
+
+func (s *UserService) signClaims(claims *Claims, expiresAt time.Time) (string, error) {
+	key, err := s.c.SigningJWTKey()
+	if err != nil {
+		return "", err
+	}
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		NotBefore: jwt.NewNumericDate(time.Now()),
+	}
+	token := jwt.NewWithClaims(signingMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+	var signingKey interface{} = key.PrivateKey
+	if key.Algorithm == config.JWTAlgorithmHS256 {
+		signingKey = key.Secret
+	}
+	tokenString, err := token.SignedString(signingKey)
 	if err != nil {
 		return "", err
 	}
@@ -98,14 +284,46 @@ func buildJWTString(userID int) (string, error) {
 }
 
 func (s *UserService) GetUserIDFromToken(token string) (int, error) {
+	claims, err := s.parseToken(token)
+	if err != nil {
+		return 0, err
+	}
+	return claims.UserID, nil
+}
+
+// parseToken проверяет подпись ключом из заголовка kid. Алгоритм токена должен совпадать
+// с алгоритмом ключа, иначе открытый ключ RS256/EdDSA можно было бы подсунуть как секрет HS256.
+func (s *UserService) parseToken(token string) (*Claims, error) {
 	claims := &Claims{}
 	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
-		return []byte("secretkey"), nil
+		kid, _ := t.Header["kid"].(string)
+		key := s.c.FindJWTKey(kid)
+		if key == nil {
+			return nil, errors.New("unknown jwt key id")
+		}
+		if t.Method.Alg() != key.Algorithm {
+			return nil, errors.New("unexpected jwt signing method")
+		}
+		if key.Algorithm == config.JWTAlgorithmHS256 {
+			return key.Secret, nil
+		}
+		return key.PublicKey, nil
 	})
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	return claims, nil
+}
+
+func signingMethod(algorithm string) jwt.SigningMethod {
+	switch algorithm {
+	case config.JWTAlgorithmRS256:
+		return jwt.SigningMethodRS256
+	case config.JWTAlgorithmEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
 	}
-	return int(claims.UserID), nil
 }
 
 func (s *UserService) GetUserIDFromContext(ctx context.Context) (int, error) {
@@ -119,3 +337,12 @@ func (s *UserService) GetUserIDFromContext(ctx context.Context) (int, error) {
 func (s *UserService) ExistsUser(ctx context.Context, userID int) bool {
 	return s.ExistsByID(ctx, userID)
 }
+
+// HasRole читает роль из базы на каждый запрос, поэтому снятие роли действует сразу, без перевыпуска токенов
+func (s *UserService) HasRole(ctx context.Context, userID int, role entity.Role) bool {
+	user, err := s.FindByID(ctx, userID)
+	if err != nil {
+		return false
+	}
+	return user.Role == role
+}
@@ -0,0 +1,4 @@
+drop table if exists "user_balance";
+drop table if exists "ledger_posting";
+drop function if exists "ledger_posting_immutable";
+alter table "balance_operation" alter column "sum" type integer;
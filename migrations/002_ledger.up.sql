@@ -0,0 +1,55 @@
+-- суммы в копейках не помещаются в integer уже после 21 474 836.47 балла
+alter table "balance_operation" alter column "sum" type bigint;
+
+create table "ledger_posting" (
+	"id" bigserial not null,
+	"debit_account" varchar(255) not null,
+	"credit_account" varchar(255) not null,
+	"amount" bigint not null,
+	"user_id" integer not null,
+	"balance_operation_id" integer,
+	"created_at" timestamp default now(),
+	constraint "ledger_posting_pk" primary key ("id"),
+	constraint "ledger_posting_amount_check" check ("amount" > 0),
+	constraint "ledger_posting_accounts_check" check ("debit_account" <> "credit_account")
+);
+create table "user_balance" (
+	"user_id" integer not null,
+	"current" bigint not null default 0,
+	"withdrawn" bigint not null default 0,
+	"updated_at" timestamp default now(),
+	constraint "user_balance_pk" primary key ("user_id"),
+	constraint "user_balance_current_check" check ("current" >= 0)
+);
+
+ALTER TABLE "ledger_posting" ADD CONSTRAINT "ledger_posting_user_fk" FOREIGN KEY ("user_id") REFERENCES "user"("id");
+ALTER TABLE "ledger_posting" ADD CONSTRAINT "ledger_posting_balance_operation_fk" FOREIGN KEY ("balance_operation_id") REFERENCES "balance_operation"("id");
+ALTER TABLE "user_balance" ADD CONSTRAINT "user_balance_user_fk" FOREIGN KEY ("user_id") REFERENCES "user"("id");
+CREATE UNIQUE INDEX "ledger_posting_balance_operation_idx" ON "ledger_posting"("balance_operation_id");
+CREATE INDEX "ledger_posting_user_idx" ON "ledger_posting"("user_id");
+
+create function "ledger_posting_immutable"() returns trigger as $$
+begin
+	raise exception 'ledger_posting is append-only';
+end;
+$$ language plpgsql;
+create trigger "ledger_posting_immutable" before update or delete on "ledger_posting"
+	for each row execute function "ledger_posting_immutable"();
+
+insert into "ledger_posting" ("debit_account", "credit_account", "amount", "user_id", "balance_operation_id", "created_at")
+	select 'ACCRUAL_SOURCE', 'USER_WALLET:' || "user_id", "sum", "user_id", "id", "created_at" from "balance_operation"
+	where "deleted_at" is null and type = 'ACCRUAL' and status = 'PROCESSED' and "sum" > 0;
+insert into "ledger_posting" ("debit_account", "credit_account", "amount", "user_id", "balance_operation_id", "created_at")
+	select 'USER_WALLET:' || "user_id", 'WITHDRAWAL_SINK', -"sum", "user_id", "id", "created_at" from "balance_operation"
+	where "deleted_at" is null and type = 'WITHDRAW' and status = 'PROCESSED' and "sum" < 0;
+-- счета, которые гонка параллельных списаний успела увести в минус, выравниваются до нуля
+-- корректирующей проводкой: иначе user_balance_current_check прервал бы всю миграцию
+insert into "ledger_posting" ("debit_account", "credit_account", "amount", "user_id")
+	select 'MANUAL_ADJUSTMENT', 'USER_WALLET:' || "user_id", -sum("sum"), "user_id" from "balance_operation"
+	where "deleted_at" is null and status = 'PROCESSED'
+	group by "user_id"
+	having sum("sum") < 0;
+insert into "user_balance" ("user_id", "current", "withdrawn")
+	select "user_id", greatest(sum("sum"), 0), -sum(case when type = 'WITHDRAW' then "sum" else 0 end) from "balance_operation"
+	where "deleted_at" is null and status = 'PROCESSED'
+	group by "user_id";
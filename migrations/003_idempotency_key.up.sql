@@ -0,0 +1,16 @@
+create table "idempotency_key" (
+	"id" serial not null,
+	"user_id" integer not null,
+	"key" varchar(255) not null,
+	"fingerprint" varchar(64) not null,
+	"status_code" integer not null default 0,
+	"content_type" varchar(255) not null default '',
+	"body" bytea,
+	"created_at" timestamp default now(),
+	"expires_at" timestamp not null,
+	constraint "idempotency_key_pk" primary key ("id")
+);
+
+ALTER TABLE "idempotency_key" ADD CONSTRAINT "idempotency_key_user_fk" FOREIGN KEY ("user_id") REFERENCES "user"("id");
+CREATE UNIQUE INDEX "idempotency_key_idx" ON "idempotency_key"("user_id", "key");
+CREATE INDEX "idempotency_key_expires_at_idx" ON "idempotency_key"("expires_at");
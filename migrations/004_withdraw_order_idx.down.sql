@@ -0,0 +1 @@
+DROP INDEX IF EXISTS "withdraw_order_idx";
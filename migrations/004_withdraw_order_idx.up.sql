@@ -0,0 +1 @@
+CREATE UNIQUE INDEX "withdraw_order_idx" ON "balance_operation"("order") where "deleted_at" is null and type = 'WITHDRAW';
@@ -0,0 +1,3 @@
+drop index if exists "balance_operation_claim_idx";
+alter table "balance_operation" drop column if exists "lease_expires_at";
+alter table "balance_operation" drop column if exists "lease_owner";
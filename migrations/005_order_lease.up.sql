@@ -0,0 +1,4 @@
+alter table "balance_operation" add column "lease_owner" varchar(255);
+alter table "balance_operation" add column "lease_expires_at" timestamp;
+create index "balance_operation_claim_idx" on "balance_operation"("status", "lease_expires_at")
+	where "deleted_at" is null and "type" = 'ACCRUAL' and "status" in ('NEW', 'PROCESSING');
@@ -0,0 +1,14 @@
+create table "refresh_token" (
+	"id" serial not null,
+	"user_id" integer not null,
+	"family_id" varchar(64) not null,
+	"token_hash" varchar(64) not null,
+	"created_at" timestamp default now(),
+	"expires_at" timestamp not null,
+	"revoked_at" timestamp,
+	constraint "refresh_token_pk" primary key ("id")
+);
+
+ALTER TABLE "refresh_token" ADD CONSTRAINT "refresh_token_user_fk" FOREIGN KEY ("user_id") REFERENCES "user"("id");
+CREATE UNIQUE INDEX "refresh_token_hash_idx" ON "refresh_token"("token_hash");
+CREATE INDEX "refresh_token_family_idx" ON "refresh_token"("family_id");
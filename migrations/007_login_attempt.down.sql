@@ -0,0 +1,2 @@
+drop table if exists "login_attempt";
+drop table if exists "attempt_counter";
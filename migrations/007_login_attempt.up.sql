@@ -0,0 +1,18 @@
+create table "attempt_counter" (
+	"key" varchar(512) not null,
+	"failures" integer not null default 0,
+	"last_failure" timestamp not null default now(),
+	"locked_until" timestamp,
+	constraint "attempt_counter_pk" primary key ("key")
+);
+create table "login_attempt" (
+	"id" serial not null,
+	"login" varchar(255) not null,
+	"ip" varchar(64) not null,
+	"reason" varchar(255) not null,
+	"created_at" timestamp default now(),
+	constraint "login_attempt_pk" primary key ("id")
+);
+
+CREATE INDEX "login_attempt_login_idx" ON "login_attempt"("login", "created_at");
+CREATE INDEX "login_attempt_ip_idx" ON "login_attempt"("ip", "created_at");
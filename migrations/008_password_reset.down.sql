@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS "refresh_token_user_idx";
+drop table if exists "password_reset_token";
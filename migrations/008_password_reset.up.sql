@@ -0,0 +1,13 @@
+create table "password_reset_token" (
+	"id" serial not null,
+	"user_id" integer not null,
+	"token_hash" varchar(64) not null,
+	"created_at" timestamp default now(),
+	"expires_at" timestamp not null,
+	"used_at" timestamp,
+	constraint "password_reset_token_pk" primary key ("id")
+);
+
+ALTER TABLE "password_reset_token" ADD CONSTRAINT "password_reset_token_user_fk" FOREIGN KEY ("user_id") REFERENCES "user"("id");
+CREATE UNIQUE INDEX "password_reset_token_hash_idx" ON "password_reset_token"("token_hash");
+CREATE INDEX "refresh_token_user_idx" ON "refresh_token"("user_id");
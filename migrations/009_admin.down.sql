@@ -0,0 +1,3 @@
+drop table if exists "admin_audit";
+ALTER TABLE "user" DROP COLUMN IF EXISTS "blocked_at";
+ALTER TABLE "user" DROP COLUMN IF EXISTS "role";
@@ -0,0 +1,16 @@
+ALTER TABLE "user" ADD COLUMN "role" varchar(32) not null default 'USER';
+ALTER TABLE "user" ADD COLUMN "blocked_at" timestamp;
+
+create table "admin_audit" (
+	"id" bigserial not null,
+	"admin_id" integer,
+	"action" varchar(64) not null,
+	"user_id" integer,
+	"details" text not null default '',
+	"created_at" timestamp default now(),
+	constraint "admin_audit_pk" primary key ("id")
+);
+
+ALTER TABLE "admin_audit" ADD CONSTRAINT "admin_audit_admin_fk" FOREIGN KEY ("admin_id") REFERENCES "user"("id");
+ALTER TABLE "admin_audit" ADD CONSTRAINT "admin_audit_user_fk" FOREIGN KEY ("user_id") REFERENCES "user"("id");
+CREATE INDEX "admin_audit_user_idx" ON "admin_audit"("user_id", "created_at");
@@ -0,0 +1,16 @@
+create table "api_key" (
+	"id" serial not null,
+	"user_id" integer not null,
+	"name" varchar(255) not null,
+	"prefix" varchar(32) not null,
+	"key_hash" varchar(64) not null,
+	"scopes" text[] not null,
+	"created_at" timestamp default now(),
+	"last_used_at" timestamp,
+	"revoked_at" timestamp,
+	constraint "api_key_pk" primary key ("id")
+);
+
+ALTER TABLE "api_key" ADD CONSTRAINT "api_key_user_fk" FOREIGN KEY ("user_id") REFERENCES "user"("id");
+CREATE UNIQUE INDEX "api_key_hash_idx" ON "api_key"("key_hash");
+CREATE INDEX "api_key_user_idx" ON "api_key"("user_id");
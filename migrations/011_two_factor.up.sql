@@ -0,0 +1,11 @@
+create table "two_factor" (
+	"user_id" integer not null,
+	"secret" varchar(64) not null,
+	"recovery_codes" text[] not null default '{}',
+	"last_step" bigint not null default 0,
+	"created_at" timestamp default now(),
+	"confirmed_at" timestamp,
+	constraint "two_factor_pk" primary key ("user_id")
+);
+
+ALTER TABLE "two_factor" ADD CONSTRAINT "two_factor_user_fk" FOREIGN KEY ("user_id") REFERENCES "user"("id");
@@ -0,0 +1,13 @@
+create table "session" (
+	"id" varchar(64) not null,
+	"user_id" integer not null,
+	"ip" varchar(64) not null,
+	"user_agent" varchar(512) not null,
+	"device" varchar(128) not null,
+	"created_at" timestamp default now(),
+	"last_seen_at" timestamp default now(),
+	constraint "session_pk" primary key ("id")
+);
+
+ALTER TABLE "session" ADD CONSTRAINT "session_user_fk" FOREIGN KEY ("user_id") REFERENCES "user"("id");
+CREATE INDEX "session_user_idx" ON "session"("user_id");
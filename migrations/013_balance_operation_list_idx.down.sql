@@ -0,0 +1,2 @@
+drop index if exists "balance_operation_user_withdraws_idx";
+drop index if exists "balance_operation_user_orders_idx";
@@ -0,0 +1,4 @@
+create index "balance_operation_user_orders_idx" on "balance_operation"("user_id", "created_at", "id")
+	where "deleted_at" is null and "type" = 'ACCRUAL';
+create index "balance_operation_user_withdraws_idx" on "balance_operation"("user_id", "created_at", "id")
+	where "deleted_at" is null and "type" = 'WITHDRAW' and "status" = 'PROCESSED';
@@ -0,0 +1,4 @@
+-- возвращаем order_idx в том виде, в каком его создаёт 001; индекс без фильтра по типу
+-- из баз до миграций не восстанавливаем: с ним списания по номеру заказа начисления теряются
+DROP INDEX IF EXISTS "order_idx";
+CREATE UNIQUE INDEX "order_idx" ON "balance_operation"("order") where "deleted_at" is null and type = 'ACCRUAL';
@@ -0,0 +1,4 @@
+-- в базах, созданных до миграций (initTables), order_idx не фильтровал тип операции,
+-- и списание по номеру заказа начисления молча не сохранялось
+DROP INDEX IF EXISTS "order_idx";
+CREATE UNIQUE INDEX "order_idx" ON "balance_operation"("order") where "deleted_at" is null and type = 'ACCRUAL';